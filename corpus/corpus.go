@@ -0,0 +1,30 @@
+// Copyright 2023 The RNN Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package corpus
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"strings"
+)
+
+// Load loads a corpus from a file, decompressing it if it is gzipped
+func Load(name string) ([]byte, error) {
+	input, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer input.Close()
+	if !strings.HasSuffix(name, ".gz") {
+		return io.ReadAll(input)
+	}
+	reader, err := gzip.NewReader(input)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
@@ -5,19 +5,20 @@
 package discrete
 
 import (
+	"context"
 	"fmt"
 	"math"
 	"math/rand"
-	"runtime"
 	"sort"
 
 	. "github.com/pointlander/rnn/matrix/f32"
+	"github.com/pointlander/rnn/train"
 )
 
 const (
 	// Size is the number of instructions
 	Size = 512
-	// Window is the window size
+	// Window is the default window size
 	Window = 16
 )
 
@@ -55,6 +56,21 @@ type Sample struct {
 	Loss float64
 }
 
+// Stddev returns the mean standard deviation of the random variables of the distribution
+func (d Distribution) Stddev() float64 {
+	sum, count := 0.0, 0
+	for _, randoms := range d.Instructions {
+		for _, r := range randoms {
+			sum += r.Stddev
+		}
+		count += len(randoms)
+	}
+	if count == 0 {
+		return 0
+	}
+	return sum / float64(count)
+}
+
 // Sample samples the distribution
 func (d Distribution) Sample(rng *rand.Rand) Sample {
 	instructions := NewMatrix(0, int(InstructionNum), Size)
@@ -86,16 +102,64 @@ func (d Distribution) Sample(rng *rand.Rand) Sample {
 	}
 }
 
-// Learn learns a BF program
-func Learn() {
-	rng := rand.New(rand.NewSource(1))
+// Defaults are the default options of Learn, the output is the distribution file
+var Defaults = train.Options{
+	Seed:        1,
+	Population:  1024,
+	Generations: 1024,
+	Window:      Window,
+	Elite:       64,
+	Output:      "discrete.distribution",
+}
+
+// Trainer learns a BF program that prints Hello World!
+type Trainer struct {
+	Options  train.Options
+	Observer train.Observer
+}
+
+// Learn learns a BF program, if the context is done the distribution so far is saved
+func Learn(ctx context.Context, options train.Options) {
+	options = options.Merge(Defaults)
+	progress, err := train.NewProgress(options)
+	if err != nil {
+		panic(err)
+	}
+	defer progress.Close()
+	trainer := Trainer{
+		Options:  options,
+		Observer: progress.Observe,
+	}
+	best, d, err := trainer.Train(ctx)
+	if err != nil && !train.Interrupted(err) {
+		panic(err)
+	}
+	if progress.Improved {
+		fmt.Println(best.Output)
+		fmt.Println(best.String())
+	}
+	err = d.Save(options.Output)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// Train learns a BF program, it returns the best sample and the distribution so far
+// with the error of the context if the context is done
+func (t Trainer) Train(ctx context.Context) (Sample, Distribution, error) {
+	options := t.Options.Merge(Defaults)
+	if err := options.Validate(); err != nil {
+		return Sample{}, Distribution{}, err
+	}
+	rng := rand.New(rand.NewSource(options.Seed))
 	d := NewDistribution(rng)
-	samples := make([]Sample, 1024)
+	samples := make([]Sample, options.Population)
+	best := Sample{}
 	minLoss := math.MaxFloat64
-	done := make(chan bool, 8)
-	cpus := runtime.NumCPU()
-	inference := func(seed int64, j int) {
-		rng := rand.New(rand.NewSource(seed))
+	pool := train.NewPool[struct{}](options.Workers, nil)
+	defer pool.Close()
+	window := options.Window
+	inference := func(rng *rand.Rand, j int) {
 		samples[j] = d.Sample(rng)
 		samples[j].Run()
 		output := samples[j].Output
@@ -128,62 +192,63 @@ func Learn() {
 			loss = math.MaxInt
 		}
 		samples[j].Loss = float64(loss)
-		done <- true
 	}
-	for e := 0; e < 1024; e++ {
-		k, flight := 0, 0
-		for j := 0; j < cpus && k < len(samples); j++ {
-			go inference(rng.Int63(), k)
-			flight++
-			k++
-		}
-		for k < len(samples) {
-			<-done
-			flight--
-			go inference(rng.Int63(), k)
-			flight++
-			k++
+	for i := 0; i < options.Generations; i++ {
+		if err := ctx.Err(); err != nil {
+			return best, d, err
 		}
-		for flight > 0 {
-			<-done
-			flight--
+		err := pool.Run(ctx, len(samples), func(w *train.Worker[struct{}], j int) error {
+			inference(w.Seed(train.Seed(options.Seed, i, j)), j)
+			return nil
+		})
+		if err != nil {
+			return best, d, err
 		}
 		sort.Slice(samples, func(i, j int) bool {
 			return samples[i].Loss < samples[j].Loss
 		})
 		min, index := math.MaxFloat64, 0
-		for j := 0; j < 64-Window; j++ {
+		for j := 0; j < options.Elite-window; j++ {
 			mean := 0.0
-			for k := 0; k < Window; k++ {
+			for k := 0; k < window; k++ {
 				mean += samples[j+k].Loss
 			}
-			mean /= Window
+			mean /= float64(window)
 			stddev := 0.0
-			for k := 0; k < Window; k++ {
+			for k := 0; k < window; k++ {
 				diff := mean - samples[j+k].Loss
 				stddev += diff * diff
 			}
-			stddev /= Window
+			stddev /= float64(window)
 			stddev = math.Sqrt(stddev)
 			if stddev < min {
 				min, index = stddev, j
 			}
 		}
+		losses := make([]float64, len(samples))
+		for j := range samples {
+			losses[j] = samples[j].Loss
+		}
+		stats := train.NewStats(i, losses)
+		stats.Spread = d.Stddev()
+		stats.Elite, stats.Stddev = index, min
 		if samples[index].Loss < minLoss {
+			best = samples[index]
 			minLoss = samples[index].Loss
-		} else {
+			stats.Improved = true
+		}
+		stats.Loss = minLoss
+		t.Observer.Observe(stats)
+		if !stats.Improved {
 			continue
 		}
-		fmt.Println(min, index, samples[index].Loss)
-		fmt.Println(samples[index].Output)
-		fmt.Println(samples[index].String())
 		next := Distribution{
 			Instructions: make([][]Random, Size),
 		}
 		for i := range next.Instructions {
 			next.Instructions[i] = make([]Random, int(InstructionNum))
 		}
-		for j := 0; j < Window; j++ {
+		for j := 0; j < window; j++ {
 			for x := 0; x < Size; x++ {
 				for y := 0; y < int(InstructionNum); y++ {
 					next.Instructions[x][y].Mean += float64(samples[index+j].Instructions.Data[x*int(InstructionNum)+y])
@@ -192,10 +257,10 @@ func Learn() {
 		}
 		for j := range next.Instructions {
 			for x := range next.Instructions[j] {
-				next.Instructions[j][x].Mean /= Window
+				next.Instructions[j][x].Mean /= float64(window)
 			}
 		}
-		for j := 0; j < Window; j++ {
+		for j := 0; j < window; j++ {
 			for x := 0; x < Size; x++ {
 				for y := 0; y < int(InstructionNum); y++ {
 					diff := next.Instructions[x][y].Mean -
@@ -206,12 +271,22 @@ func Learn() {
 		}
 		for j := range next.Instructions {
 			for x := range next.Instructions[j] {
-				next.Instructions[j][x].Stddev /= Window
+				next.Instructions[j][x].Stddev /= float64(window)
 				next.Instructions[j][x].Stddev = math.Sqrt(next.Instructions[j][x].Stddev)
 			}
 		}
 		d = next
 	}
+	return best, d, nil
+}
+
+// Infer samples a BF program from the distribution and runs it
+func Infer(d Distribution, seed int64) {
+	rng := rand.New(rand.NewSource(seed))
+	sample := d.Sample(rng)
+	sample.Run()
+	fmt.Println(sample.String())
+	fmt.Printf("%q\n", sample.Output)
 }
 
 // Instruction is a bf instruction
@@ -0,0 +1,72 @@
+// Copyright 2023 The RNN Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package discrete
+
+import (
+	"github.com/pointlander/rnn/model"
+)
+
+// DistributionArchitecture is the architecture name of the distribution files
+const DistributionArchitecture = "discrete.distribution"
+
+// Model converts the distribution into a model
+func (d Distribution) Model() *model.Model {
+	m := model.New(DistributionArchitecture)
+	m.Config["size"] = Size
+	m.Config["instructions"] = int(InstructionNum)
+	mean := make([]float64, 0, Size*int(InstructionNum))
+	stddev := make([]float64, 0, Size*int(InstructionNum))
+	for _, instruction := range d.Instructions {
+		for _, r := range instruction {
+			mean = append(mean, r.Mean)
+			stddev = append(stddev, r.Stddev)
+		}
+	}
+	m.Add(model.NewF64("Instructions.Mean", []int{Size, int(InstructionNum)}, mean))
+	m.Add(model.NewF64("Instructions.Stddev", []int{Size, int(InstructionNum)}, stddev))
+	return m
+}
+
+// DistributionFromModel creates a distribution from a model
+func DistributionFromModel(m *model.Model) (Distribution, error) {
+	var d Distribution
+	err := m.Check(DistributionArchitecture, map[string]int{"size": Size, "instructions": int(InstructionNum)})
+	if err != nil {
+		return d, err
+	}
+	mean, err := m.Float64("Instructions.Mean", Size, int(InstructionNum))
+	if err != nil {
+		return d, err
+	}
+	stddev, err := m.Float64("Instructions.Stddev", Size, int(InstructionNum))
+	if err != nil {
+		return d, err
+	}
+	d.Instructions = make([][]Random, Size)
+	for i := range d.Instructions {
+		for j := 0; j < int(InstructionNum); j++ {
+			index := i*int(InstructionNum) + j
+			d.Instructions[i] = append(d.Instructions[i], Random{
+				Mean:   mean[index],
+				Stddev: stddev[index],
+			})
+		}
+	}
+	return d, nil
+}
+
+// Save saves the distribution
+func (d Distribution) Save(name string) error {
+	return model.Save(name, d.Model())
+}
+
+// LoadDistribution loads a distribution
+func LoadDistribution(name string) (Distribution, error) {
+	m, err := model.Load(name)
+	if err != nil {
+		return Distribution{}, err
+	}
+	return DistributionFromModel(m)
+}
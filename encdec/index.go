@@ -0,0 +1,116 @@
+// Copyright 2023 The RNN Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package encdec
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/pointlander/rnn/corpus"
+	"github.com/pointlander/rnn/matrix/u64"
+)
+
+// Embed encodes data into a binary code packed from the signs of the latent vector
+func (n *Network) Embed(data []byte) u64.Matrix {
+	return u64.Pack(n.Encode(data))
+}
+
+// Index is a nearest neighbour index of lines embedded by an encoder
+type Index struct {
+	Codes []u64.Matrix
+	Lines []string
+}
+
+// Neighbour is a line found by a search
+type Neighbour struct {
+	Index    int
+	Line     string
+	Distance int
+}
+
+// Add embeds a line and adds it to the index
+func (x *Index) Add(n *Network, line string) {
+	x.Codes = append(x.Codes, n.Embed([]byte(line)))
+	x.Lines = append(x.Lines, line)
+}
+
+// Search finds the k lines with the smallest hamming distance to the query
+func (x *Index) Search(n *Network, query string, k int) []Neighbour {
+	code := n.Embed([]byte(query))
+	neighbours := make([]Neighbour, len(x.Codes))
+	for i, c := range x.Codes {
+		neighbours[i] = Neighbour{
+			Index:    i,
+			Line:     x.Lines[i],
+			Distance: u64.Hamming(code, c),
+		}
+	}
+	sort.SliceStable(neighbours, func(i, j int) bool {
+		return neighbours[i].Distance < neighbours[j].Distance
+	})
+	if k < len(neighbours) {
+		neighbours = neighbours[:k]
+	}
+	return neighbours
+}
+
+// NewIndex embeds the non empty lines of a file, optionally gzipped
+func NewIndex(n *Network, name string) (*Index, error) {
+	data, err := corpus.Load(name)
+	if err != nil {
+		return nil, err
+	}
+	x := &Index{}
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		line = bytes.TrimSuffix(line, []byte("\r"))
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		x.Add(n, string(line))
+	}
+	return x, nil
+}
+
+// Save saves the index
+func (x *Index) Save(name string) error {
+	output, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	err = gob.NewEncoder(output).Encode(x)
+	if err != nil {
+		output.Close()
+		return err
+	}
+	return output.Close()
+}
+
+// LoadIndex loads an index
+func LoadIndex(name string) (*Index, error) {
+	input, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer input.Close()
+	x := &Index{}
+	err = gob.NewDecoder(input).Decode(x)
+	if err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// Query prints the k nearest neighbours of each query
+func Query(n Network, x *Index, queries []string, k int) {
+	for _, query := range queries {
+		fmt.Printf("%q\n", query)
+		for _, neighbour := range x.Search(&n, query, k) {
+			fmt.Printf("\t%d %d %q\n", neighbour.Distance, neighbour.Index, neighbour.Line)
+		}
+	}
+}
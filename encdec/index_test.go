@@ -0,0 +1,52 @@
+// Copyright 2023 The RNN Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package encdec
+
+import (
+	"math/rand"
+	"path/filepath"
+	"testing"
+)
+
+func TestIndex(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	n := NewDistribution(rng).Sample(rng)
+	lines := []string{
+		"In the beginning God created the heaven and the earth.",
+		"And the earth was without form, and void.",
+		"And God said, Let there be light: and there was light.",
+		"And God saw the light, that it was good.",
+	}
+	x := &Index{}
+	for _, line := range lines {
+		x.Add(&n, line)
+	}
+	name := filepath.Join(t.TempDir(), "lines.index")
+	if err := x.Save(name); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadIndex(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, line := range lines {
+		neighbours := loaded.Search(&n, line, len(lines))
+		if len(neighbours) != len(lines) || neighbours[0].Distance != 0 {
+			t.Fatalf("neighbours of %q are %v", line, neighbours)
+		}
+		found := false
+		for _, neighbour := range neighbours {
+			if neighbour.Distance == 0 && neighbour.Index == i && neighbour.Line == line {
+				found = true
+			}
+		}
+		if !found {
+			t.Fatalf("%q is not its own nearest neighbour %v", line, neighbours)
+		}
+	}
+	if neighbours := loaded.Search(&n, lines[0], 2); len(neighbours) != 2 {
+		t.Fatalf("%d neighbours not 2", len(neighbours))
+	}
+}
@@ -0,0 +1,133 @@
+// Copyright 2023 The RNN Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package encdec
+
+import (
+	"strconv"
+
+	. "github.com/pointlander/rnn/matrix/f32"
+	"github.com/pointlander/rnn/model"
+)
+
+const (
+	// Architecture is the architecture name of the model files
+	Architecture = "encdec"
+	// DistributionArchitecture is the architecture name of the distribution files
+	DistributionArchitecture = Architecture + ".distribution"
+)
+
+// Model converts the network into a model
+func (n Network) Model() *model.Model {
+	m := model.New(Architecture)
+	m.Config["width"] = Width
+	m.Config["symbols"] = Symbols
+	m.Metadata["loss"] = strconv.FormatFloat(n.Loss, 'g', -1, 64)
+	m.AddMatrix("Embedding", n.Embedding.Matrix)
+	m.AddMatrix("EncoderWeights", n.EncoderWeights)
+	m.AddMatrix("EncoderBias", n.EncoderBias)
+	m.AddMatrix("DecoderWeights", n.DecoderWeights)
+	m.AddMatrix("DecoderBias", n.DecoderBias)
+	return m
+}
+
+// NewNetwork creates a network from a model
+func NewNetwork(m *model.Model) (Network, error) {
+	var n Network
+	err := m.Check(Architecture, map[string]int{"width": Width, "symbols": Symbols})
+	if err != nil {
+		return n, err
+	}
+	embedding, err := m.Matrix("Embedding", Width, Symbols)
+	if err != nil {
+		return n, err
+	}
+	n.Embedding = Embedding{Matrix: embedding}
+	if n.EncoderWeights, err = m.Matrix("EncoderWeights", EncoderCols, EncoderRows); err != nil {
+		return n, err
+	}
+	if n.EncoderBias, err = m.Matrix("EncoderBias", 1, EncoderRows); err != nil {
+		return n, err
+	}
+	if n.DecoderWeights, err = m.Matrix("DecoderWeights", DecoderCols, DecoderRows); err != nil {
+		return n, err
+	}
+	if n.DecoderBias, err = m.Matrix("DecoderBias", 1, DecoderRows); err != nil {
+		return n, err
+	}
+	n.EncoderState = NewMatrix(0, EncoderCols, 1)
+	n.EncoderState.Data = n.EncoderState.Data[:EncoderCols]
+	n.DecoderState = NewMatrix(0, DecoderCols, 1)
+	n.DecoderState.Data = n.DecoderState.Data[:DecoderCols]
+	if loss, ok := m.Metadata["loss"]; ok {
+		n.Loss, _ = strconv.ParseFloat(loss, 64)
+	}
+	return n, nil
+}
+
+// Save saves the network
+func (n Network) Save(name string) error {
+	return model.Save(name, n.Model())
+}
+
+// Load loads a network
+func Load(name string) (Network, error) {
+	m, err := model.Load(name)
+	if err != nil {
+		return Network{}, err
+	}
+	return NewNetwork(m)
+}
+
+// Model converts the distribution into a model
+func (d Distribution) Model() *model.Model {
+	m := model.New(DistributionArchitecture)
+	m.Config["width"] = Width
+	m.Config["symbols"] = Symbols
+	m.AddRandom("Embedding", Symbols, Width, d.Embedding)
+	m.AddRandom("EncoderWeights", EncoderRows, EncoderCols, d.EncoderWeights)
+	m.AddRandom("EncoderBias", EncoderRows, 1, d.EncoderBias)
+	m.AddRandom("DecoderWeights", DecoderRows, DecoderCols, d.DecoderWeights)
+	m.AddRandom("DecoderBias", DecoderRows, 1, d.DecoderBias)
+	return m
+}
+
+// DistributionFromModel creates a distribution from a model
+func DistributionFromModel(m *model.Model) (Distribution, error) {
+	var d Distribution
+	err := m.Check(DistributionArchitecture, map[string]int{"width": Width, "symbols": Symbols})
+	if err != nil {
+		return d, err
+	}
+	if d.Embedding, err = m.GetRandom("Embedding", Symbols, Width); err != nil {
+		return d, err
+	}
+	if d.EncoderWeights, err = m.GetRandom("EncoderWeights", EncoderRows, EncoderCols); err != nil {
+		return d, err
+	}
+	if d.EncoderBias, err = m.GetRandom("EncoderBias", EncoderRows, 1); err != nil {
+		return d, err
+	}
+	if d.DecoderWeights, err = m.GetRandom("DecoderWeights", DecoderRows, DecoderCols); err != nil {
+		return d, err
+	}
+	if d.DecoderBias, err = m.GetRandom("DecoderBias", DecoderRows, 1); err != nil {
+		return d, err
+	}
+	return d, nil
+}
+
+// Save saves the distribution
+func (d Distribution) Save(name string) error {
+	return model.Save(name, d.Model())
+}
+
+// LoadDistribution loads a distribution
+func LoadDistribution(name string) (Distribution, error) {
+	m, err := model.Load(name)
+	if err != nil {
+		return Distribution{}, err
+	}
+	return DistributionFromModel(m)
+}
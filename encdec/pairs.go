@@ -0,0 +1,149 @@
+// Copyright 2023 The RNN Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package encdec
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/pointlander/rnn/corpus"
+	"github.com/pointlander/rnn/train"
+)
+
+// Pair is a source sequence and the target sequence it maps to
+type Pair struct {
+	Source []byte
+	Target []byte
+}
+
+// record is a JSONL pair
+type record struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
+}
+
+// ParsePairs parses tab separated pairs, or JSONL records with source and target fields
+func ParsePairs(data []byte, jsonl bool) ([]Pair, error) {
+	var pairs []Pair
+	for i, line := range bytes.Split(data, []byte("\n")) {
+		line = bytes.TrimSuffix(line, []byte("\r"))
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		if jsonl {
+			var r record
+			if err := json.Unmarshal(line, &r); err != nil {
+				return nil, fmt.Errorf("line %d: %w", i+1, err)
+			}
+			pairs = append(pairs, Pair{Source: []byte(r.Source), Target: []byte(r.Target)})
+			continue
+		}
+		source, target, found := bytes.Cut(line, []byte("\t"))
+		if !found {
+			return nil, fmt.Errorf("line %d: no tab between source and target", i+1)
+		}
+		pairs = append(pairs, Pair{Source: source, Target: target})
+	}
+	return pairs, nil
+}
+
+// LoadPairs loads pairs from a .tsv or .jsonl file, optionally gzipped
+func LoadPairs(name string) ([]Pair, error) {
+	data, err := corpus.Load(name)
+	if err != nil {
+		return nil, err
+	}
+	return ParsePairs(data, strings.HasSuffix(strings.TrimSuffix(name, ".gz"), ".jsonl"))
+}
+
+// PairInference encodes the sources and scores the decoder against the targets
+// followed by the end of sequence symbol
+func (n *Network) PairInference(pairs []Pair) {
+	loss := 0.0
+	for _, pair := range pairs {
+		n.Encode(pair.Source)
+		symbols := make([]int, 0, len(pair.Target)+1)
+		for _, symbol := range pair.Target {
+			symbols = append(symbols, int(symbol))
+		}
+		symbols = append(symbols, EOS)
+		loss += n.score(symbols)
+	}
+	n.Loss = loss
+}
+
+// LearnPairs learns to map the sources of the pairs in the data file to their targets,
+// if the context is done the best network and the distribution so far are saved
+func LearnPairs(ctx context.Context, options train.Options) {
+	options = options.Merge(Defaults)
+	pairs, err := LoadPairs(options.Data)
+	if err != nil {
+		panic(err)
+	}
+	progress, err := train.NewProgress(options)
+	if err != nil {
+		panic(err)
+	}
+	defer progress.Close()
+	trainer := Trainer{
+		Options:  options,
+		Observer: progress.Observe,
+	}
+	best, distribution, err := trainer.TrainPairs(ctx, pairs)
+	if err != nil && !train.Interrupted(err) {
+		panic(err)
+	}
+	if progress.Improved {
+		err = best.Save(options.Output)
+		if err != nil {
+			panic(err)
+		}
+	}
+	err = distribution.Save(options.Distribution())
+	if err != nil {
+		panic(err)
+	}
+}
+
+// TrainPairs learns a network that maps the sources of the pairs to their targets, it returns the best
+// network and the distribution so far with the error of the context if the context is done
+func (t Trainer) TrainPairs(ctx context.Context, pairs []Pair) (Network, Distribution, error) {
+	return t.learn(ctx, func(n *Network) {
+		n.PairInference(pairs)
+	})
+}
+
+// InferPairs decodes the source of each pair and reports the accuracy against the target
+func InferPairs(n Network, pairs []Pair) {
+	exact, correct, total := 0, 0, 0
+	for _, pair := range pairs {
+		output := n.Decode(n.Encode(pair.Source), 2*len(pair.Target)+1)
+		matches := 0
+		for i, symbol := range output {
+			if i < len(pair.Target) && symbol == pair.Target[i] {
+				matches++
+			}
+		}
+		length := len(pair.Target)
+		if len(output) > length {
+			length = len(output)
+		}
+		if bytes.Equal(output, pair.Target) {
+			exact++
+		}
+		fmt.Printf("%q -> %q expected %q %d/%d\n", pair.Source, output, pair.Target, matches, length)
+		correct += matches
+		total += length
+	}
+	if total > 0 {
+		fmt.Println("accuracy", correct, total, float64(correct)/float64(total))
+	}
+	if len(pairs) > 0 {
+		fmt.Println("exact", exact, len(pairs), float64(exact)/float64(len(pairs)))
+	}
+}
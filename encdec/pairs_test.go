@@ -0,0 +1,32 @@
+// Copyright 2023 The RNN Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package encdec
+
+import (
+	"testing"
+)
+
+func TestParsePairs(t *testing.T) {
+	tsv := "hello\tbonjour\r\n\nyes\toui\tsi\n"
+	pairs, err := ParsePairs([]byte(tsv), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pairs) != 2 || string(pairs[0].Target) != "bonjour" || string(pairs[1].Target) != "oui\tsi" {
+		t.Fatalf("tsv pairs %q", pairs)
+	}
+	if _, err := ParsePairs([]byte("no tab\n"), false); err == nil {
+		t.Fatal("expected an error for a line without a tab")
+	}
+
+	jsonl := `{"source": "a\tb", "target": "c"}` + "\n" + `{"source": "d", "target": ""}`
+	pairs, err = ParsePairs([]byte(jsonl), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pairs) != 2 || string(pairs[0].Source) != "a\tb" || len(pairs[1].Target) != 0 {
+		t.Fatalf("jsonl pairs %q", pairs)
+	}
+}
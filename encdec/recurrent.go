@@ -5,25 +5,29 @@
 package encdec
 
 import (
-	"compress/gzip"
+	"context"
 	"fmt"
-	"io/ioutil"
 	"math"
 	"math/rand"
-	"os"
-	"runtime"
 	"sort"
 
+	"github.com/pointlander/rnn/corpus"
 	. "github.com/pointlander/rnn/matrix/f32"
+	"github.com/pointlander/rnn/model"
+	"github.com/pointlander/rnn/train"
 )
 
 const (
 	// Width is the width of the network
 	Width = 256
-	// Offset is the offset
+	// Offset is the offset of the symbols in the decoder output
 	Offset = Width
+	// EOS is the end of sequence symbol
+	EOS = 256
+	// Symbols is the number of symbols, the bytes and the end of sequence symbol
+	Symbols = 257
 	// EncoderCols is the number of encoder columns
-	EncoderCols = Width + 256
+	EncoderCols = Width
 	// EncoderRows is the number of encoder rows
 	EncoderRows = Width
 	// EncoderSize is the number of encoder parameters
@@ -31,19 +35,17 @@ const (
 	// DecoderCols is the number of decoder columns
 	DecoderCols = Width
 	// DecoderRows is the number of decoder rows
-	DecoderRows = Width + 256
+	DecoderRows = Width + Symbols
 	// DecoderSize is the number of decoder parameters
 	DecoderSize = DecoderCols * DecoderRows
 )
 
 // Random is a random variable
-type Random struct {
-	Mean   float64
-	Stddev float64
-}
+type Random = model.Random
 
 // Distribution is a distribution of a neural network
 type Distribution struct {
+	Embedding      []Random
 	EncoderWeights []Random
 	EncoderBias    []Random
 	DecoderWeights []Random
@@ -54,6 +56,12 @@ type Distribution struct {
 func NewDistribution(rng *rand.Rand) Distribution {
 	var d Distribution
 	factor := math.Sqrt(2.0 / float64(EncoderCols))
+	for i := 0; i < Symbols*Width; i++ {
+		d.Embedding = append(d.Embedding, Random{
+			Mean:   factor * rng.NormFloat64(),
+			Stddev: factor * rng.NormFloat64(),
+		})
+	}
 	for i := 0; i < EncoderSize; i++ {
 		d.EncoderWeights = append(d.EncoderWeights, Random{
 			Mean:   factor * rng.NormFloat64(),
@@ -84,6 +92,7 @@ func NewDistribution(rng *rand.Rand) Distribution {
 
 // Network is a neural network
 type Network struct {
+	Embedding      Embedding
 	EncoderState   Matrix
 	EncoderWeights Matrix
 	EncoderBias    Matrix
@@ -93,9 +102,29 @@ type Network struct {
 	Loss           float64
 }
 
+// Stddev returns the mean standard deviation of the random variables of the distribution
+func (d Distribution) Stddev() float64 {
+	sum, count := 0.0, 0
+	for _, randoms := range [][]Random{d.Embedding, d.EncoderWeights, d.EncoderBias, d.DecoderWeights, d.DecoderBias} {
+		for _, r := range randoms {
+			sum += r.Stddev
+		}
+		count += len(randoms)
+	}
+	if count == 0 {
+		return 0
+	}
+	return sum / float64(count)
+}
+
 // Sample samples a network from the distribution
 func (d Distribution) Sample(rng *rand.Rand) Network {
 	var n Network
+	n.Embedding = NewEmbedding(0, Width, Symbols)
+	for i := 0; i < Symbols*Width; i++ {
+		r := d.Embedding[i]
+		n.Embedding.Data = append(n.Embedding.Data, float32(rng.NormFloat64()*r.Stddev+r.Mean))
+	}
 	n.EncoderState = NewMatrix(0, EncoderCols, 1)
 	n.EncoderState.Data = n.EncoderState.Data[:EncoderCols]
 	n.EncoderWeights = NewMatrix(0, EncoderCols, EncoderRows)
@@ -125,118 +154,217 @@ func (d Distribution) Sample(rng *rand.Rand) Network {
 	return n
 }
 
-// Inference run inference on the network
-func (n *Network) Inference(data []byte) {
+// Encode encodes data into the latent vector of the encoder state
+func (n *Network) Encode(data []byte) []float32 {
+	for i := range n.EncoderState.Data {
+		n.EncoderState.Data[i] = 0
+	}
 	for _, symbol := range data {
-		for i := 0; i < 256; i++ {
-			n.EncoderState.Data[Offset+i] = -1
+		output := Step(Add(Add(MulT(n.EncoderWeights, n.EncoderState), n.Embedding.Lookup(int(symbol))), n.EncoderBias))
+		copy(n.EncoderState.Data, output.Data)
+	}
+	latent := make([]float32, EncoderCols)
+	copy(latent, n.EncoderState.Data)
+	return latent
+}
+
+// decode advances the decoder state and returns the decoder output
+func (n *Network) decode() Matrix {
+	direct := Add(MulT(n.DecoderWeights, n.DecoderState), n.DecoderBias)
+	output := Step(direct)
+	copy(n.DecoderState.Data, output.Data[:Offset])
+	return direct
+}
+
+// Decode decodes up to length bytes from a latent vector, stopping at the end of sequence symbol
+func (n *Network) Decode(latent []float32, length int) []byte {
+	copy(n.DecoderState.Data, latent)
+	data := make([]byte, 0, length)
+	for i := 0; i < length; i++ {
+		direct := n.decode()
+		max, index := float32(math.Inf(-1)), 0
+		for key, value := range direct.Data[Offset:] {
+			if value > max {
+				max, index = value, key
+			}
 		}
-		n.EncoderState.Data[Offset+int(symbol)] = 1
-		output := Step(Add(MulT(n.EncoderWeights, n.EncoderState), n.EncoderBias))
-		copy(n.EncoderState.Data[:Offset], output.Data)
+		if index == EOS {
+			break
+		}
+		data = append(data, byte(index))
 	}
-	copy(n.DecoderState.Data, n.EncoderState.Data[:Offset])
+	return data
+}
+
+// score decodes from the encoder state and returns the loss against the expected symbols
+func (n *Network) score(symbols []int) float64 {
+	copy(n.DecoderState.Data, n.EncoderState.Data)
 	loss := 0.0
-	for _, symbol := range data {
-		direct := Add(MulT(n.DecoderWeights, n.DecoderState), n.DecoderBias)
-		output := Step(direct)
-		copy(n.DecoderState.Data, output.Data[:Offset])
-		expected := make([]float64, 256)
-		expected[int(symbol)] = 1
+	expected := make([]float64, Symbols)
+	for _, symbol := range symbols {
+		direct := n.decode()
+		for i := range expected {
+			expected[i] = 0
+		}
+		expected[symbol] = 1
 		sum := 0.0
-		for i := 0; i < 256; i++ {
+		for i := 0; i < Symbols; i++ {
 			diff := expected[i] - float64(direct.Data[Offset+i])
 			sum += diff * diff
 		}
-		loss += sum / 256
+		loss += sum / Symbols
+	}
+	return loss
+}
+
+// Inference run inference on the network
+func (n *Network) Inference(data []byte) {
+	n.Encode(data)
+	symbols := make([]int, len(data))
+	for i, symbol := range data {
+		symbols[i] = int(symbol)
 	}
-	n.Loss = loss
+	n.Loss = n.score(symbols)
+}
+
+// Defaults are the default options of Learn and LearnPairs
+var Defaults = train.Options{
+	Seed:        1,
+	Population:  128,
+	Generations: 128,
+	Window:      8,
+	Elite:       64,
+	Data:        "pg10.txt.gz",
+	Output:      "encdec.model",
 }
 
-// Learn learns the mode
-func Learn() {
-	rng := rand.New(rand.NewSource(1))
-	input, err := os.Open("pg10.txt.gz")
+// Learn learns the mode, if the context is done the best network and the distribution so far are saved
+func Learn(ctx context.Context, options train.Options) {
+	options = options.Merge(Defaults)
+	data, err := corpus.Load(options.Data)
 	if err != nil {
 		panic(err)
 	}
-	defer input.Close()
-	reader, err := gzip.NewReader(input)
+
+	//data = data[:1024]
+
+	progress, err := train.NewProgress(options)
 	if err != nil {
 		panic(err)
 	}
-	defer reader.Close()
-	data, err := ioutil.ReadAll(reader)
+	defer progress.Close()
+	trainer := Trainer{
+		Options:  options,
+		Observer: progress.Observe,
+	}
+	best, distribution, err := trainer.Train(ctx, data)
+	if err != nil && !train.Interrupted(err) {
+		panic(err)
+	}
+	if progress.Improved {
+		err = best.Save(options.Output)
+		if err != nil {
+			panic(err)
+		}
+	}
+	err = distribution.Save(options.Distribution())
 	if err != nil {
 		panic(err)
 	}
+}
 
-	//data = data[:1024]
+// Trainer learns a network that reconstructs text or maps sources to targets
+type Trainer struct {
+	Options  train.Options
+	Observer train.Observer
+}
+
+// Train learns a network that reconstructs the text, it returns the best network and the
+// distribution so far with the error of the context if the context is done
+func (t Trainer) Train(ctx context.Context, data []byte) (Network, Distribution, error) {
+	return t.learn(ctx, func(n *Network) {
+		n.Inference(data)
+	})
+}
 
+// learn searches for the network with the lowest loss computed by inference
+func (t Trainer) learn(ctx context.Context, inference func(n *Network)) (Network, Distribution, error) {
+	options := t.Options.Merge(Defaults)
+	if err := options.Validate(); err != nil {
+		return Network{}, Distribution{}, err
+	}
+	rng := rand.New(rand.NewSource(options.Seed))
 	distribution := NewDistribution(rng)
-	networks := make([]Network, 128)
+	networks := make([]Network, options.Population)
+	best := Network{}
 	minLoss := math.MaxFloat64
-	done := make(chan bool, 8)
-	cpus := runtime.NumCPU()
-	inference := func(j int) {
-		networks[j].Inference(data)
-		done <- true
+	pool := train.NewPool[struct{}](options.Workers, nil)
+	defer pool.Close()
+	window := options.Window
+	infer := func(rng *rand.Rand, j int) {
+		networks[j] = distribution.Sample(rng)
+		inference(&networks[j])
 	}
-	for i := 0; i < 128; i++ {
-		for j := range networks {
-			networks[j] = distribution.Sample(rng)
-		}
-		k, flight := 0, 0
-		for j := 0; j < cpus && k < len(networks); j++ {
-			go inference(k)
-			flight++
-			k++
+	for i := 0; i < options.Generations; i++ {
+		if err := ctx.Err(); err != nil {
+			return best, distribution, err
 		}
-		for k < len(networks) {
-			<-done
-			flight--
-			go inference(k)
-			flight++
-			k++
-		}
-		for flight > 0 {
-			<-done
-			flight--
+		err := pool.Run(ctx, len(networks), func(w *train.Worker[struct{}], j int) error {
+			infer(w.Seed(train.Seed(options.Seed, i, j)), j)
+			return nil
+		})
+		if err != nil {
+			return best, distribution, err
 		}
 		sort.Slice(networks, func(i, j int) bool {
 			return networks[i].Loss < networks[j].Loss
 		})
 		min, index := math.MaxFloat64, 0
-		for j := 0; j < 64-8; j++ {
+		for j := 0; j < options.Elite-window; j++ {
 			mean := 0.0
-			for k := 0; k < 8; k++ {
+			for k := 0; k < window; k++ {
 				mean += networks[j+k].Loss
 			}
-			mean /= 8
+			mean /= float64(window)
 			stddev := 0.0
-			for k := 0; k < 8; k++ {
+			for k := 0; k < window; k++ {
 				diff := mean - networks[j+k].Loss
 				stddev += diff * diff
 			}
-			stddev /= 8
+			stddev /= float64(window)
 			stddev = math.Sqrt(stddev)
 			if stddev < min {
 				min, index = stddev, j
 			}
 		}
+		losses := make([]float64, len(networks))
+		for j := range networks {
+			losses[j] = networks[j].Loss
+		}
+		stats := train.NewStats(i, losses)
+		stats.Spread = distribution.Stddev()
+		stats.Elite, stats.Stddev = index, min
 		if networks[index].Loss < minLoss {
+			best = networks[index]
 			minLoss = networks[index].Loss
-		} else {
+			stats.Improved = true
+		}
+		stats.Loss = minLoss
+		t.Observer.Observe(stats)
+		if !stats.Improved {
 			continue
 		}
-		fmt.Println(min, index, networks[index].Loss)
 		next := Distribution{
+			Embedding:      make([]Random, len(distribution.Embedding)),
 			EncoderWeights: make([]Random, len(distribution.EncoderWeights)),
 			EncoderBias:    make([]Random, len(distribution.EncoderBias)),
 			DecoderWeights: make([]Random, len(distribution.DecoderWeights)),
 			DecoderBias:    make([]Random, len(distribution.DecoderBias)),
 		}
-		for j := 0; j < 8; j++ {
+		for j := 0; j < window; j++ {
+			for k, value := range networks[index+j].Embedding.Data {
+				next.Embedding[k].Mean += float64(value)
+			}
 			for k, value := range networks[index+j].EncoderWeights.Data {
 				next.EncoderWeights[k].Mean += float64(value)
 			}
@@ -250,19 +378,26 @@ func Learn() {
 				next.DecoderBias[k].Mean += float64(value)
 			}
 		}
+		for j := range next.Embedding {
+			next.Embedding[j].Mean /= float64(window)
+		}
 		for j := range next.EncoderWeights {
-			next.EncoderWeights[j].Mean /= 8
+			next.EncoderWeights[j].Mean /= float64(window)
 		}
 		for j := range next.EncoderBias {
-			next.EncoderBias[j].Mean /= 8
+			next.EncoderBias[j].Mean /= float64(window)
 		}
 		for j := range next.DecoderWeights {
-			next.DecoderWeights[j].Mean /= 8
+			next.DecoderWeights[j].Mean /= float64(window)
 		}
 		for j := range next.DecoderBias {
-			next.DecoderBias[j].Mean /= 8
+			next.DecoderBias[j].Mean /= float64(window)
 		}
-		for j := 0; j < 8; j++ {
+		for j := 0; j < window; j++ {
+			for k, value := range networks[index+j].Embedding.Data {
+				diff := next.Embedding[k].Mean - float64(value)
+				next.Embedding[k].Stddev += diff * diff
+			}
 			for k, value := range networks[index+j].EncoderWeights.Data {
 				diff := next.EncoderWeights[k].Mean - float64(value)
 				next.EncoderWeights[k].Stddev += diff * diff
@@ -280,22 +415,53 @@ func Learn() {
 				next.DecoderBias[k].Stddev += diff * diff
 			}
 		}
+		for j := range next.Embedding {
+			next.Embedding[j].Stddev /= float64(window)
+			next.Embedding[j].Stddev = math.Sqrt(next.Embedding[j].Stddev)
+		}
 		for j := range next.EncoderWeights {
-			next.EncoderWeights[j].Stddev /= 8
+			next.EncoderWeights[j].Stddev /= float64(window)
 			next.EncoderWeights[j].Stddev = math.Sqrt(next.EncoderWeights[j].Stddev)
 		}
 		for j := range next.EncoderBias {
-			next.EncoderBias[j].Stddev /= 8
+			next.EncoderBias[j].Stddev /= float64(window)
 			next.EncoderBias[j].Stddev = math.Sqrt(next.EncoderBias[j].Stddev)
 		}
 		for j := range next.DecoderWeights {
-			next.DecoderWeights[j].Stddev /= 8
+			next.DecoderWeights[j].Stddev /= float64(window)
 			next.DecoderWeights[j].Stddev = math.Sqrt(next.DecoderWeights[j].Stddev)
 		}
 		for j := range next.DecoderBias {
-			next.DecoderBias[j].Stddev /= 8
+			next.DecoderBias[j].Stddev /= float64(window)
 			next.DecoderBias[j].Stddev = math.Sqrt(next.DecoderBias[j].Stddev)
 		}
 		distribution = next
 	}
+	return best, distribution, nil
+}
+
+// Infer reconstructs each input through the latent vector and reports the accuracy
+func Infer(n Network, inputs []string) {
+	correct, total := 0, 0
+	for _, input := range inputs {
+		data := []byte(input)
+		latent := n.Encode(data)
+		output := n.Decode(latent, len(data))
+		matches := 0
+		for i, symbol := range output {
+			if symbol == data[i] {
+				matches++
+			}
+		}
+		accuracy := 0.0
+		if len(data) > 0 {
+			accuracy = float64(matches) / float64(len(data))
+		}
+		fmt.Printf("%q -> %q %d/%d %f\n", input, output, matches, len(data), accuracy)
+		correct += matches
+		total += len(data)
+	}
+	if total > 0 {
+		fmt.Println("accuracy", correct, total, float64(correct)/float64(total))
+	}
 }
@@ -0,0 +1,90 @@
+// Copyright 2023 The RNN Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package ensemble summarizes the predictions of networks sampled from a distribution
+//
+// The distributions learned by the models are factorized posteriors over the
+// parameters, so averaging the outputs of networks sampled from them gives a
+// predictive distribution with uncertainty estimates
+package ensemble
+
+import (
+	"math"
+)
+
+// Prediction is the averaged prediction of an ensemble
+type Prediction struct {
+	// Mean is the mean probability of each class
+	Mean []float64
+	// Variance is the variance of the probability of each class across the ensemble
+	Variance []float64
+	// Entropy is the entropy of the mean probabilities, the total uncertainty
+	Entropy float64
+	// Expected is the mean entropy of the members, the uncertainty in the data
+	Expected float64
+}
+
+// entropy computes the entropy in nats of a probability vector
+func entropy(probabilities []float64) float64 {
+	sum := 0.0
+	for _, p := range probabilities {
+		if p > 0 {
+			sum -= p * math.Log(p)
+		}
+	}
+	return sum
+}
+
+// Predict averages the probability vectors output by the members of an ensemble
+func Predict(outputs [][]float32) Prediction {
+	if len(outputs) == 0 {
+		return Prediction{}
+	}
+	classes := len(outputs[0])
+	p := Prediction{
+		Mean:     make([]float64, classes),
+		Variance: make([]float64, classes),
+	}
+	probabilities := make([]float64, classes)
+	for _, output := range outputs {
+		for i, value := range output {
+			probabilities[i] = float64(value)
+			p.Mean[i] += probabilities[i]
+		}
+		p.Expected += entropy(probabilities)
+	}
+	n := float64(len(outputs))
+	for i := range p.Mean {
+		p.Mean[i] /= n
+	}
+	p.Expected /= n
+	for _, output := range outputs {
+		for i, value := range output {
+			diff := float64(value) - p.Mean[i]
+			p.Variance[i] += diff * diff
+		}
+	}
+	for i := range p.Variance {
+		p.Variance[i] /= n
+	}
+	p.Entropy = entropy(p.Mean)
+	return p
+}
+
+// Disagreement is the mutual information between the prediction and the parameters,
+// the uncertainty due to the members of the ensemble disagreeing
+func (p Prediction) Disagreement() float64 {
+	return p.Entropy - p.Expected
+}
+
+// Max returns the most probable class and its mean probability
+func (p Prediction) Max() (int, float64) {
+	max, index := -1.0, 0
+	for i, value := range p.Mean {
+		if value > max {
+			max, index = value, i
+		}
+	}
+	return index, max
+}
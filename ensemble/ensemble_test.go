@@ -0,0 +1,34 @@
+// Copyright 2023 The RNN Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ensemble
+
+import (
+	"math"
+	"testing"
+)
+
+func TestPredict(t *testing.T) {
+	agree := Predict([][]float32{{.5, .5}, {.5, .5}})
+	if math.Abs(agree.Entropy-math.Log(2)) > 1e-9 || math.Abs(agree.Disagreement()) > 1e-9 {
+		t.Fatalf("agreeing members: %+v", agree)
+	}
+	if agree.Variance[0] != 0 {
+		t.Fatalf("agreeing members have variance %f", agree.Variance[0])
+	}
+
+	disagree := Predict([][]float32{{1, 0}, {0, 1}, {1, 0}})
+	if disagree.Expected != 0 {
+		t.Fatalf("certain members have entropy %f", disagree.Expected)
+	}
+	if disagree.Disagreement() <= 0 {
+		t.Fatalf("disagreeing members have no disagreement")
+	}
+	if index, p := disagree.Max(); index != 0 || math.Abs(p-2.0/3) > 1e-9 {
+		t.Fatalf("max is %d %f", index, p)
+	}
+	if math.Abs(disagree.Variance[1]-2.0/9) > 1e-9 {
+		t.Fatalf("variance is %f", disagree.Variance[1])
+	}
+}
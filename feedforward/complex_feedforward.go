@@ -5,22 +5,25 @@
 package feedforward
 
 import (
+	"context"
 	"fmt"
 	"math"
 	"math/cmplx"
 	"math/rand"
-	"runtime"
 	"sort"
 
 	"github.com/pointlander/datum/iris"
 	. "github.com/pointlander/rnn/matrix/complex"
+	"github.com/pointlander/rnn/train"
 )
 
 const (
-	// ComplexWindow is the distribution window
+	// ComplexWindow is the default distribution window
 	ComplexWindow = 16
 	// ComplexMiddle is the width of the middle layer
 	ComplexMiddle = 16
+	// noiseSalt derives the seed of the noise of the measures from the seed of the search
+	noiseSalt = 0x6e6f697365
 )
 
 // ComplexRandom is a random variable
@@ -48,16 +51,16 @@ type ComplexSample struct {
 	Loss          float64
 }
 
-// NewComplexDistrution creates a new distribution of feed forward layers
-func NewComplexDistribution(rng *rand.Rand) ComplexDistribution {
+// NewComplexDistrution creates a new distribution of feed forward layers with weights of standard deviation stddev
+func NewComplexDistribution(rng *rand.Rand, stddev float64) ComplexDistribution {
 	layer1Weights := make([]ComplexRandom, 0, 4*ComplexMiddle)
 	//factor := math.Sqrt(2.0 / float64(4))
 	for i := 0; i < 4*ComplexMiddle; i++ {
 		layer1Weights = append(layer1Weights, ComplexRandom{
-			Mean:    0,  //factor * rng.NormFloat64(),
-			Stddev:  .5, //factor * rng.NormFloat64(),
+			Mean:    0,      //factor * rng.NormFloat64(),
+			Stddev:  stddev, //factor * rng.NormFloat64(),
 			IMean:   0,
-			IStddev: .5,
+			IStddev: stddev,
 		})
 	}
 	layer1Bias := make([]ComplexRandom, 0, ComplexMiddle)
@@ -73,10 +76,10 @@ func NewComplexDistribution(rng *rand.Rand) ComplexDistribution {
 	layer2Weights := make([]ComplexRandom, 0, 2*ComplexMiddle*3)
 	for i := 0; i < 2*ComplexMiddle*3; i++ {
 		layer2Weights = append(layer2Weights, ComplexRandom{
-			Mean:    0,  //factor * rng.NormFloat64(),
-			Stddev:  .5, //factor * rng.NormFloat64(),
+			Mean:    0,      //factor * rng.NormFloat64(),
+			Stddev:  stddev, //factor * rng.NormFloat64(),
 			IMean:   0,
-			IStddev: .5,
+			IStddev: stddev,
 		})
 	}
 	//factor = math.Sqrt(2.0 / float64(3))
@@ -97,6 +100,21 @@ func NewComplexDistribution(rng *rand.Rand) ComplexDistribution {
 	}
 }
 
+// Stddev returns the mean standard deviation of the random variables of the distribution
+func (d ComplexDistribution) Stddev() float64 {
+	sum, count := 0.0, 0
+	for _, randoms := range [][]ComplexRandom{d.Layer1Weights, d.Layer1Bias, d.Layer2Weights, d.Layer2Bias} {
+		for _, r := range randoms {
+			sum += r.Stddev + r.IStddev
+		}
+		count += 2 * len(randoms)
+	}
+	if count == 0 {
+		return 0
+	}
+	return sum / float64(count)
+}
+
 // Sample returns a sampled feedforward neural network
 func (d ComplexDistribution) Sample(rng *rand.Rand) ComplexSample {
 	var s ComplexSample
@@ -129,43 +147,73 @@ func (d ComplexDistribution) Sample(rng *rand.Rand) ComplexSample {
 	return s
 }
 
-// ComplexLearn learn the mode
-func ComplexLearn() {
-	rng := rand.New(rand.NewSource(1))
-	data, err := iris.Load()
+// ComplexDefaults are the default options of ComplexLearn
+var ComplexDefaults = train.Options{
+	Seed:        1,
+	Population:  150,
+	Generations: 4 * 1024,
+	Window:      ComplexWindow,
+	Stddev:      .5,
+}
+
+// ComplexTrainer learns a complex network that classifies the iris data set
+type ComplexTrainer struct {
+	Options  train.Options
+	Observer train.Observer
+}
+
+// ComplexLearn learn the mode, if the context is done the best sample so far is evaluated
+func ComplexLearn(ctx context.Context, options train.Options) {
+	progress, err := train.NewProgress(options)
 	if err != nil {
 		panic(err)
 	}
+	defer progress.Close()
+	trainer := ComplexTrainer{
+		Options:  options,
+		Observer: progress.Observe,
+	}
+	best, err := trainer.Train(ctx)
+	if err != nil && !train.Interrupted(err) {
+		panic(err)
+	}
+	if !progress.Improved {
+		return
+	}
+	data := loadIris()
+	fmt.Print(Evaluate(best, data, rows(data)))
+}
 
-	for _, value := range data.Fisher {
-		sum := 0.0
-		for _, v := range value.Measures {
-			sum += v * v
-		}
-		length := math.Sqrt(sum)
-		for i := range value.Measures {
-			value.Measures[i] /= length
-		}
+// Train learns a sample from the iris data set, it returns the best sample so far
+// with the error of the context if the context is done
+func (t ComplexTrainer) Train(ctx context.Context) (ComplexSample, error) {
+	data := loadIris()
+	return t.learn(ctx, data, rows(data))
+}
+
+// learn learns a sample from the rows of the data set
+func (t ComplexTrainer) learn(ctx context.Context, data iris.Datum, rows []int) (ComplexSample, error) {
+	options := t.Options.Merge(ComplexDefaults)
+	if err := options.Validate(); err != nil {
+		return ComplexSample{}, err
 	}
+	rng := rand.New(rand.NewSource(options.Seed))
 
-	distribution := NewComplexDistribution(rng)
-	networks := make([]ComplexSample, 150)
+	distribution := NewComplexDistribution(rng, options.Stddev)
+	networks := make([]ComplexSample, options.Population)
 	minLoss := math.MaxFloat64
-	done := make(chan bool, 8)
-	cpus := runtime.NumCPU()
+	pool := train.NewPool[struct{}](options.Workers, nil)
+	defer pool.Close()
+	window := options.Window
 	best := ComplexSample{}
-	noise := make([][]float64, 150)
+	noise := make([][]float64, len(data.Fisher))
 	for i := range noise {
-		s := make([]float64, 4)
-		for j := range s {
-			s[j] = rng.NormFloat64() * .1
-		}
-		noise[i] = s
+		noise[i] = make([]float64, 4)
 	}
-	inference := func(seed int64, j int) {
-		//rng := rand.New(rand.NewSource(seed))
+	inference := func(rng *rand.Rand, j int) {
+		networks[j] = distribution.Sample(rng)
 		loss := 0.0
-		for i := 0; i < 150; i++ {
+		for _, i := range rows {
 			fisher := data.Fisher[i]
 			input := NewMatrix(0, 4, 1)
 			for j, v := range fisher.Measures {
@@ -183,69 +231,71 @@ func ComplexLearn() {
 			}
 		}
 		networks[j].Loss = loss
-		done <- true
 	}
-	for i := 0; i < 4*1024; i++ {
-		for j := range networks {
-			networks[j] = distribution.Sample(rng)
+	for i := 0; i < options.Generations; i++ {
+		if err := ctx.Err(); err != nil {
+			return best, err
 		}
-		k, flight := 0, 0
-		for j := 0; j < cpus && k < len(networks); j++ {
-			go inference(rng.Int63(), k)
-			flight++
-			k++
-		}
-		for k < len(networks) {
-			<-done
-			flight--
-			go inference(rng.Int63(), k)
-			flight++
-			k++
+		// the noise is reseeded per generation from the seed of the search so that every
+		// network of the generation is evaluated on the same noisy measures
+		noisy := rand.New(rand.NewSource(train.Seed(options.Seed^noiseSalt, i, 0)))
+		for _, s := range noise {
+			for j := range s {
+				s[j] = noisy.NormFloat64() * .1
+			}
 		}
-		for flight > 0 {
-			<-done
-			flight--
+		err := pool.Run(ctx, len(networks), func(w *train.Worker[struct{}], j int) error {
+			inference(w.Seed(train.Seed(options.Seed, i, j)), j)
+			return nil
+		})
+		if err != nil {
+			return best, err
 		}
 		sort.Slice(networks, func(i, j int) bool {
 			return networks[i].Loss < networks[j].Loss
 		})
 		min, index := math.MaxFloat64, 0
-		for j := 0; j < 150-ComplexWindow; j++ {
+		for j := 0; j < options.Elite-window; j++ {
 			mean := 0.0
-			for k := 0; k < ComplexWindow; k++ {
+			for k := 0; k < window; k++ {
 				mean += networks[j+k].Loss
 			}
-			mean /= ComplexWindow
+			mean /= float64(window)
 			stddev := 0.0
-			for k := 0; k < ComplexWindow; k++ {
+			for k := 0; k < window; k++ {
 				diff := mean - networks[j+k].Loss
 				stddev += diff * diff
 			}
-			stddev /= ComplexWindow
+			stddev /= float64(window)
 			stddev = math.Sqrt(stddev)
 			if stddev < min {
 				min, index = stddev, j
 			}
 		}
-		for _, s := range noise {
-			for j := range s {
-				s[j] = rng.NormFloat64() * .1
-			}
+		losses := make([]float64, len(networks))
+		for j := range networks {
+			losses[j] = networks[j].Loss
 		}
+		stats := train.NewStats(i, losses)
+		stats.Spread = distribution.Stddev()
+		stats.Elite, stats.Stddev = index, min
 		if networks[index].Loss < minLoss {
 			best = networks[index]
 			minLoss = networks[index].Loss
-		} else {
+			stats.Improved = true
+		}
+		stats.Loss = minLoss
+		t.Observer.Observe(stats)
+		if !stats.Improved {
 			continue
 		}
-		fmt.Println(min, index, networks[index].Loss)
 		next := ComplexDistribution{
 			Layer1Weights: make([]ComplexRandom, len(distribution.Layer1Weights)),
 			Layer1Bias:    make([]ComplexRandom, len(distribution.Layer1Bias)),
 			Layer2Weights: make([]ComplexRandom, len(distribution.Layer2Weights)),
 			Layer2Bias:    make([]ComplexRandom, len(distribution.Layer2Bias)),
 		}
-		for j := 0; j < ComplexWindow; j++ {
+		for j := 0; j < window; j++ {
 			for k, value := range networks[index+j].Layer1Weights.Data {
 				next.Layer1Weights[k].Mean += float64(real(value))
 				next.Layer1Weights[k].IMean += float64(imag(value))
@@ -264,22 +314,22 @@ func ComplexLearn() {
 			}
 		}
 		for j := range next.Layer1Weights {
-			next.Layer1Weights[j].Mean /= ComplexWindow
-			next.Layer1Weights[j].IMean /= ComplexWindow
+			next.Layer1Weights[j].Mean /= float64(window)
+			next.Layer1Weights[j].IMean /= float64(window)
 		}
 		for j := range next.Layer1Bias {
-			next.Layer1Bias[j].Mean /= ComplexWindow
-			next.Layer1Bias[j].IMean /= ComplexWindow
+			next.Layer1Bias[j].Mean /= float64(window)
+			next.Layer1Bias[j].IMean /= float64(window)
 		}
 		for j := range next.Layer2Weights {
-			next.Layer2Weights[j].Mean /= ComplexWindow
-			next.Layer2Weights[j].IMean /= ComplexWindow
+			next.Layer2Weights[j].Mean /= float64(window)
+			next.Layer2Weights[j].IMean /= float64(window)
 		}
 		for j := range next.Layer2Bias {
-			next.Layer2Bias[j].Mean /= ComplexWindow
-			next.Layer2Bias[j].IMean /= ComplexWindow
+			next.Layer2Bias[j].Mean /= float64(window)
+			next.Layer2Bias[j].IMean /= float64(window)
 		}
-		for j := 0; j < ComplexWindow; j++ {
+		for j := 0; j < window; j++ {
 			for k, value := range networks[index+j].Layer1Weights.Data {
 				diff := next.Layer1Weights[k].Mean - float64(real(value))
 				next.Layer1Weights[k].Stddev += diff * diff
@@ -306,63 +356,48 @@ func ComplexLearn() {
 			}
 		}
 		for j := range next.Layer1Weights {
-			next.Layer1Weights[j].Stddev /= ComplexWindow
+			next.Layer1Weights[j].Stddev /= float64(window)
 			next.Layer1Weights[j].Stddev = math.Sqrt(next.Layer1Weights[j].Stddev)
-			next.Layer1Weights[j].IStddev /= ComplexWindow
+			next.Layer1Weights[j].IStddev /= float64(window)
 			next.Layer1Weights[j].IStddev = math.Sqrt(next.Layer1Weights[j].IStddev)
 		}
 		for j := range next.Layer1Bias {
-			next.Layer1Bias[j].Stddev /= ComplexWindow
+			next.Layer1Bias[j].Stddev /= float64(window)
 			next.Layer1Bias[j].Stddev = math.Sqrt(next.Layer1Bias[j].Stddev)
-			next.Layer1Bias[j].IStddev /= ComplexWindow
+			next.Layer1Bias[j].IStddev /= float64(window)
 			next.Layer1Bias[j].IStddev = math.Sqrt(next.Layer1Bias[j].IStddev)
 		}
 		for j := range next.Layer2Weights {
-			next.Layer2Weights[j].Stddev /= ComplexWindow
+			next.Layer2Weights[j].Stddev /= float64(window)
 			next.Layer2Weights[j].Stddev = math.Sqrt(next.Layer2Weights[j].Stddev)
-			next.Layer2Weights[j].IStddev /= ComplexWindow
+			next.Layer2Weights[j].IStddev /= float64(window)
 			next.Layer2Weights[j].IStddev = math.Sqrt(next.Layer2Weights[j].IStddev)
 		}
 		for j := range next.Layer2Bias {
-			next.Layer2Bias[j].Stddev /= ComplexWindow
+			next.Layer2Bias[j].Stddev /= float64(window)
 			next.Layer2Bias[j].Stddev = math.Sqrt(next.Layer2Bias[j].Stddev)
-			next.Layer2Bias[j].IStddev /= ComplexWindow
+			next.Layer2Bias[j].IStddev /= float64(window)
 			next.Layer2Bias[j].IStddev = math.Sqrt(next.Layer2Bias[j].IStddev)
 		}
 		distribution = next
 	}
+	return best, nil
+}
 
-	correct := 0
-	loss := 0.0
-	for _, fisher := range data.Fisher {
-		input := NewMatrix(0, 4, 1)
-		for _, v := range fisher.Measures {
-			input.Data = append(input.Data, complex(v, 0))
-		}
-
-		output := EverettActivation(Add(MulT(best.Layer1Weights, input),
-			best.Layer1Bias))
-		output = TaylorSoftmax(Add(MulT(best.Layer2Weights, output), best.Layer2Bias))
-		max, index := float32(0.0), 0
-		for i, value := range output.Data {
-			v := float32(cmplx.Abs(value))
-			if v > max {
-				max, index = v, i
-			}
-		}
-		fmt.Println(index, max)
-		if index == iris.Labels[fisher.Label] {
-			correct++
-		}
-
-		expected := make([]float32, 3)
-		expected[iris.Labels[fisher.Label]] = 1
-
-		for i, v := range output.Data {
-			diff := float64(float32(cmplx.Abs(v)) - expected[i])
-			loss += diff * diff
+// Classify returns the most probable class of the measures
+func (s ComplexSample) Classify(measures []float64) int {
+	input := NewMatrix(0, 4, 1)
+	for _, v := range measures {
+		input.Data = append(input.Data, complex(v, 0))
+	}
+	output := EverettActivation(Add(MulT(s.Layer1Weights, input), s.Layer1Bias))
+	output = TaylorSoftmax(Add(MulT(s.Layer2Weights, output), s.Layer2Bias))
+	max, index := float32(0.0), 0
+	for i, value := range output.Data {
+		v := float32(cmplx.Abs(value))
+		if v > max {
+			max, index = v, i
 		}
 	}
-	fmt.Println("correct", correct, float64(correct)/150)
-	fmt.Println("loss", loss)
+	return index
 }
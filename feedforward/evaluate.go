@@ -0,0 +1,132 @@
+// Copyright 2023 The RNN Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package feedforward
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sort"
+
+	"github.com/pointlander/datum/iris"
+	"github.com/pointlander/rnn/metrics"
+	"github.com/pointlander/rnn/train"
+)
+
+// Classifier classifies the measures of an iris
+type Classifier interface {
+	Classify(measures []float64) int
+}
+
+// Learner learns a classifier from rows of the iris data set until the context is done,
+// zero options are set from the defaults of the variant
+type Learner func(ctx context.Context, data iris.Datum, rows []int, options train.Options) (Classifier, error)
+
+// Learners are the learners of the real, complex and quaternion feedforward networks
+var Learners = map[string]Learner{
+	"real": func(ctx context.Context, data iris.Datum, rows []int, options train.Options) (Classifier, error) {
+		best, _, err := Trainer{Options: options}.learn(ctx, data, rows)
+		return best, err
+	},
+	"complex": func(ctx context.Context, data iris.Datum, rows []int, options train.Options) (Classifier, error) {
+		return ComplexTrainer{Options: options}.learn(ctx, data, rows)
+	},
+	"quaternion": func(ctx context.Context, data iris.Datum, rows []int, options train.Options) (Classifier, error) {
+		best, _, err := QuatTrainer{Options: options}.learn(ctx, data, rows)
+		return best, err
+	},
+}
+
+// Classes are the names of the iris classes in label order
+func Classes() []string {
+	classes := make([]string, len(iris.Labels))
+	for name, label := range iris.Labels {
+		classes[label] = name
+	}
+	return classes
+}
+
+// labels returns the labels of the data set
+func labels(data iris.Datum) []int {
+	labels := make([]int, len(data.Fisher))
+	for i, fisher := range data.Fisher {
+		labels[i] = iris.Labels[fisher.Label]
+	}
+	return labels
+}
+
+// Evaluate classifies rows of the data set and returns the confusion matrix
+func Evaluate(c Classifier, data iris.Datum, rows []int) *metrics.Confusion {
+	confusion := metrics.NewConfusion(Classes())
+	for _, row := range rows {
+		fisher := data.Fisher[row]
+		confusion.Add(iris.Labels[fisher.Label], c.Classify(fisher.Measures))
+	}
+	return confusion
+}
+
+// learner finds a learner by name
+func learner(name string) (Learner, error) {
+	learner, ok := Learners[name]
+	if !ok {
+		names := make([]string, 0, len(Learners))
+		for name := range Learners {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown feedforward variant %s, expected one of %v", name, names)
+	}
+	return learner, nil
+}
+
+// CrossValidate reports the stratified k-fold cross validation of a feedforward variant,
+// the seed of the options also shuffles the folds
+func CrossValidate(ctx context.Context, name string, k int, options train.Options) error {
+	learn, err := learner(name)
+	if err != nil {
+		return err
+	}
+	data := loadIris()
+	folds, err := metrics.StratifiedKFold(labels(data), k, rand.New(rand.NewSource(options.Seed)))
+	if err != nil {
+		return err
+	}
+	total := metrics.NewConfusion(Classes())
+	for i, fold := range folds {
+		c, err := learn(ctx, data, metrics.Complement(len(data.Fisher), fold), options)
+		if err != nil {
+			return err
+		}
+		confusion := Evaluate(c, data, fold)
+		fmt.Printf("fold %d accuracy %.4f macro f1 %.4f\n", i, confusion.Accuracy(), confusion.MacroF1())
+		total.Merge(confusion)
+	}
+	fmt.Printf("%s %d-fold cross validation\n", name, k)
+	fmt.Print(total)
+	return nil
+}
+
+// HoldOut reports the accuracy of a feedforward variant on a stratified held out test split,
+// the seed of the options also selects the split
+func HoldOut(ctx context.Context, name string, test float64, options train.Options) error {
+	learn, err := learner(name)
+	if err != nil {
+		return err
+	}
+	data := loadIris()
+	training, held, err := metrics.Split(labels(data), test, rand.New(rand.NewSource(options.Seed)))
+	if err != nil {
+		return err
+	}
+	c, err := learn(ctx, data, training, options)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s train\n", name)
+	fmt.Print(Evaluate(c, data, training))
+	fmt.Printf("%s test\n", name)
+	fmt.Print(Evaluate(c, data, held))
+	return nil
+}
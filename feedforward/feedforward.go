@@ -5,28 +5,28 @@
 package feedforward
 
 import (
+	"context"
 	"fmt"
 	"math"
 	"math/rand"
-	"runtime"
 	"sort"
 
 	"github.com/pointlander/datum/iris"
+	"github.com/pointlander/rnn/ensemble"
 	. "github.com/pointlander/rnn/matrix/f32"
+	"github.com/pointlander/rnn/model"
+	"github.com/pointlander/rnn/train"
 )
 
 const (
-	// Window is the distribution window
+	// Window is the default distribution window
 	Window = 16
 	// Middle is the width of the middle layer
 	Middle = 16
 )
 
 // Random is a random variable
-type Random struct {
-	Mean   float64
-	Stddev float64
-}
+type Random = model.Random
 
 // Distribution is a distribution of a neural network
 type Distribution struct {
@@ -46,14 +46,14 @@ type Sample struct {
 	Loss          float64
 }
 
-// NewDistrution creates a new distribution of feed forward layers
-func NewDistribution(rng *rand.Rand) Distribution {
+// NewDistrution creates a new distribution of feed forward layers with weights of standard deviation stddev
+func NewDistribution(rng *rand.Rand, stddev float64) Distribution {
 	layer1Weights := make([]Random, 0, 4*Middle)
 	//factor := math.Sqrt(2.0 / float64(4))
 	for i := 0; i < 4*Middle; i++ {
 		layer1Weights = append(layer1Weights, Random{
-			Mean:   0, //factor * rng.NormFloat64(),
-			Stddev: 1, //factor * rng.NormFloat64(),
+			Mean:   0,      //factor * rng.NormFloat64(),
+			Stddev: stddev, //factor * rng.NormFloat64(),
 		})
 	}
 	layer1Bias := make([]Random, 0, Middle)
@@ -67,8 +67,8 @@ func NewDistribution(rng *rand.Rand) Distribution {
 	layer2Weights := make([]Random, 0, 2*Middle*3)
 	for i := 0; i < Middle*3; i++ {
 		layer2Weights = append(layer2Weights, Random{
-			Mean:   0, //factor * rng.NormFloat64(),
-			Stddev: 1, //factor * rng.NormFloat64(),
+			Mean:   0,      //factor * rng.NormFloat64(),
+			Stddev: stddev, //factor * rng.NormFloat64(),
 		})
 	}
 	//factor = math.Sqrt(2.0 / float64(3))
@@ -87,6 +87,35 @@ func NewDistribution(rng *rand.Rand) Distribution {
 	}
 }
 
+// Stddev returns the mean standard deviation of the random variables of the distribution,
+// the standard deviations of a multivariate distribution are the square roots of the diagonal of AA^T
+func (d Distribution) Stddev() float64 {
+	sum, count := 0.0, 0
+	if d.Multi != nil {
+		for _, m := range d.Multi {
+			cols := m.A.Cols
+			for i := 0; i < m.A.Rows; i++ {
+				variance := 0.0
+				for _, a := range m.A.Data[i*cols : (i+1)*cols] {
+					variance += float64(a) * float64(a)
+				}
+				sum += math.Sqrt(variance)
+			}
+			count += m.A.Rows
+		}
+	}
+	for _, randoms := range [][]Random{d.Layer1Weights, d.Layer1Bias, d.Layer2Weights, d.Layer2Bias} {
+		for _, r := range randoms {
+			sum += r.Stddev
+		}
+		count += len(randoms)
+	}
+	if count == 0 {
+		return 0
+	}
+	return sum / float64(count)
+}
+
 // Sample returns a sampled feedforward neural network
 func (d Distribution) Sample(rng *rand.Rand) Sample {
 	var s Sample
@@ -140,9 +169,48 @@ func (d Distribution) Sample(rng *rand.Rand) Sample {
 	return s
 }
 
-// Learn learn the mode
-func Learn() {
-	rng := rand.New(rand.NewSource(1))
+// Forward computes the class probabilities of an input
+func (s Sample) Forward(input Matrix) Matrix {
+	output := Step(Add(MulT(s.Layer1Weights, input), s.Layer1Bias))
+	return TaylorSoftmax(Add(MulT(s.Layer2Weights, output), s.Layer2Bias))
+}
+
+// Classify returns the most probable class of the measures
+func (s Sample) Classify(measures []float64) int {
+	input := NewMatrix(0, 4, 1)
+	for _, v := range measures {
+		input.Data = append(input.Data, float32(v))
+	}
+	output := s.Forward(input)
+	max, index := float32(0.0), 0
+	for i, value := range output.Data {
+		if value > max {
+			max, index = value, i
+		}
+	}
+	return index
+}
+
+// Probabilities returns the class probabilities of raw measures, normalizing them to a unit vector
+// like the training data
+func (s Sample) Probabilities(measures []float64) []float32 {
+	sum := 0.0
+	for _, v := range measures {
+		sum += v * v
+	}
+	length := math.Sqrt(sum)
+	if length == 0 {
+		length = 1
+	}
+	input := NewMatrix(0, 4, 1)
+	for _, v := range measures {
+		input.Data = append(input.Data, float32(v/length))
+	}
+	return s.Forward(input).Data
+}
+
+// loadIris loads the iris data set and normalizes the measures to unit vectors
+func loadIris() iris.Datum {
 	data, err := iris.Load()
 	if err != nil {
 		panic(err)
@@ -158,15 +226,97 @@ func Learn() {
 			value.Measures[i] /= length
 		}
 	}
+	return data
+}
+
+// rows returns the indexes of all of the rows of the data set
+func rows(data iris.Datum) []int {
+	rows := make([]int, len(data.Fisher))
+	for i := range rows {
+		rows[i] = i
+	}
+	return rows
+}
+
+// Defaults are the default options of Learn, the iris data set is built in
+var Defaults = train.Options{
+	Seed:        1,
+	Population:  1024,
+	Generations: 4 * 1024,
+	Window:      Window,
+	Output:      "feedforward.model",
+	Stddev:      1,
+}
+
+// Trainer learns a network that classifies the iris data set
+type Trainer struct {
+	Options  train.Options
+	Observer train.Observer
+}
+
+// Learn learn the mode, if the context is done the best sample and the distribution so far are saved
+func Learn(ctx context.Context, options train.Options) {
+	options = options.Merge(Defaults)
+	progress, err := train.NewProgress(options)
+	if err != nil {
+		panic(err)
+	}
+	defer progress.Close()
+	trainer := Trainer{
+		Options:  options,
+		Observer: progress.Observe,
+	}
+	best, distribution, err := trainer.Train(ctx)
+	if err != nil && !train.Interrupted(err) {
+		panic(err)
+	}
+	err = distribution.Save(options.Distribution())
+	if err != nil {
+		panic(err)
+	}
+	if !progress.Improved {
+		return
+	}
+	err = best.Save(options.Output)
+	if err != nil {
+		panic(err)
+	}
+	Infer(best)
+}
 
-	distribution := NewDistribution(rng)
-	networks := make([]Sample, 1024)
+// Train learns a sample from the iris data set, it returns the best sample and the distribution
+// so far with the error of the context if the context is done
+func (t Trainer) Train(ctx context.Context) (Sample, Distribution, error) {
+	data := loadIris()
+	return t.learn(ctx, data, rows(data))
+}
+
+// learn learns a sample from the rows of the data set
+func (t Trainer) learn(ctx context.Context, data iris.Datum, rows []int) (Sample, Distribution, error) {
+	options := t.Options.Merge(Defaults)
+	if err := options.Validate(); err != nil {
+		return Sample{}, Distribution{}, err
+	}
+	rng := rand.New(rand.NewSource(options.Seed))
+	classes := make([][]int, 3)
+	for _, row := range rows {
+		label := iris.Labels[data.Fisher[row].Label]
+		classes[label] = append(classes[label], row)
+	}
+	pick := func() [3]int {
+		return [3]int{classes[0][rng.Intn(len(classes[0]))], classes[1][rng.Intn(len(classes[1]))],
+			classes[2][rng.Intn(len(classes[2]))]}
+	}
+
+	distribution := NewDistribution(rng, options.Stddev)
+	networks := make([]Sample, options.Population)
 	minLoss := math.MaxFloat64
-	done := make(chan bool, 8)
-	cpus := runtime.NumCPU()
+	pool := train.NewPool[struct{}](options.Workers, nil)
+	defer pool.Close()
+	window := options.Window
 	best := Sample{}
-	inference := func(seed int64, i [3]int, j int) {
-		//rng := rand.New(rand.NewSource(seed))
+	inference := func(rng *rand.Rand, i [3]int, j int) {
+		networks[j] = distribution.Sample(rng)
 		loss := 0.0
 		for _, i := range i {
 			fisher := data.Fisher[i]
@@ -186,49 +336,57 @@ func Learn() {
 			}
 		}
 		networks[j].Loss = loss
-		done <- true
 	}
-	indexes := [3]int{rng.Intn(50), 50 + rng.Intn(50), 100 + rng.Intn(50)}
-	for i := 0; i < 4*1024; i++ {
-		for j := range networks {
-			networks[j] = distribution.Sample(rng)
-		}
-		k, flight := 0, 0
-		for j := 0; j < cpus && k < len(networks); j++ {
-			go inference(rng.Int63(), indexes, k)
-			flight++
-			k++
+	indexes := pick()
+	for i := 0; i < options.Generations; i++ {
+		if err := ctx.Err(); err != nil {
+			return best, distribution, err
 		}
-		for k < len(networks) {
-			<-done
-			flight--
-			go inference(rng.Int63(), indexes, k)
-			flight++
-			k++
-		}
-		for flight > 0 {
-			<-done
-			flight--
+		err := pool.Run(ctx, len(networks), func(w *train.Worker[struct{}], j int) error {
+			inference(w.Seed(train.Seed(options.Seed, i, j)), indexes, j)
+			return nil
+		})
+		if err != nil {
+			return best, distribution, err
 		}
 		sort.Slice(networks, func(i, j int) bool {
 			return networks[i].Loss < networks[j].Loss
 		})
 
+		losses := make([]float64, len(networks))
+		for j := range networks {
+			losses[j] = networks[j].Loss
+		}
+		stats := train.NewStats(i, losses)
+		stats.Spread = distribution.Stddev()
+		mean := 0.0
+		for _, loss := range losses[:window] {
+			mean += loss
+		}
+		mean /= float64(window)
+		for _, loss := range losses[:window] {
+			diff := mean - loss
+			stats.Stddev += diff * diff
+		}
+		stats.Stddev = math.Sqrt(stats.Stddev / float64(window))
 		if networks[0].Loss < minLoss {
 			best = networks[0]
 			minLoss = networks[0].Loss
-			indexes = [3]int{rng.Intn(50), 50 + rng.Intn(50), 100 + rng.Intn(50)}
-		} else {
-			//fmt.Println("continue", networks[0].Loss)
+			indexes = pick()
+			stats.Improved = true
+		}
+		stats.Loss = minLoss
+		t.Observer.Observe(stats)
+		if !stats.Improved {
 			continue
 		}
 		multi := make([]Multi, 0, Middle+3)
 		for n := 0; n < Middle; n++ {
 			vars := make([][]float32, 4+1)
 			for i := range vars {
-				vars[i] = make([]float32, Window)
+				vars[i] = make([]float32, window)
 			}
-			for j := 0; j < Window; j++ {
+			for j := 0; j < window; j++ {
 				k := 0
 				for _, value := range networks[j].Layer1Weights.Data[n*4 : (n+1)*4] {
 					vars[k][j] = float32(value)
@@ -244,9 +402,9 @@ func Learn() {
 		for n := 0; n < 3; n++ {
 			vars := make([][]float32, Middle+1)
 			for i := range vars {
-				vars[i] = make([]float32, Window)
+				vars[i] = make([]float32, window)
 			}
-			for j := 0; j < Window; j++ {
+			for j := 0; j < window; j++ {
 				k := 0
 				for _, value := range networks[j].Layer2Weights.Data[n*4 : (n+1)*4] {
 					vars[k][j] = float32(value)
@@ -260,44 +418,56 @@ func Learn() {
 			multi = append(multi, Factor(vars, false))
 		}
 
-		fmt.Println(networks[0].Loss)
 		next := Distribution{
 			Multi: multi,
 		}
 		distribution = next
 	}
+	return best, distribution, nil
+}
+
+// Infer classifies the iris data set with a sample
+func Infer(best Sample) {
+	data := loadIris()
+	fmt.Print(Evaluate(best, data, rows(data)))
+}
 
-	correct := 0
-	loss := 0.0
+// Ensemble classifies the iris data set with k networks sampled from the distribution,
+// reporting the uncertainty of each prediction
+func Ensemble(d Distribution, rng *rand.Rand, k int) {
+	data := loadIris()
+	samples := make([]Sample, k)
+	for i := range samples {
+		samples[i] = d.Sample(rng)
+	}
+	correct, wrong := 0, 0
+	correctEntropy, wrongEntropy := 0.0, 0.0
+	outputs := make([][]float32, k)
 	for _, fisher := range data.Fisher {
 		input := NewMatrix(0, 4, 1)
 		for _, v := range fisher.Measures {
 			input.Data = append(input.Data, float32(v))
 		}
-
-		output := Step(Add(MulT(best.Layer1Weights, input),
-			best.Layer1Bias))
-		output = TaylorSoftmax(Add(MulT(best.Layer2Weights, output), best.Layer2Bias))
-		max, index := float32(0.0), 0
-		for i, value := range output.Data {
-			v := float32(value)
-			if v > max {
-				max, index = v, i
-			}
+		for i := range samples {
+			outputs[i] = samples[i].Forward(input).Data
 		}
-		fmt.Println(index, max)
+		prediction := ensemble.Predict(outputs)
+		index, max := prediction.Max()
+		fmt.Printf("%d %f entropy=%f variance=%f disagreement=%f\n",
+			index, max, prediction.Entropy, prediction.Variance[index], prediction.Disagreement())
 		if index == iris.Labels[fisher.Label] {
 			correct++
-		}
-
-		expected := make([]float32, 3)
-		expected[iris.Labels[fisher.Label]] = 1
-
-		for i, v := range output.Data {
-			diff := float64(float32(v) - expected[i])
-			loss += diff * diff
+			correctEntropy += prediction.Entropy
+		} else {
+			wrong++
+			wrongEntropy += prediction.Entropy
 		}
 	}
 	fmt.Println("correct", correct, float64(correct)/150)
-	fmt.Println("loss", loss)
+	if correct > 0 {
+		fmt.Println("mean entropy correct", correctEntropy/float64(correct))
+	}
+	if wrong > 0 {
+		fmt.Println("mean entropy wrong", wrongEntropy/float64(wrong))
+	}
 }
@@ -0,0 +1,162 @@
+// Copyright 2023 The RNN Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package feedforward
+
+import (
+	"fmt"
+	"strconv"
+
+	. "github.com/pointlander/rnn/matrix/f32"
+	"github.com/pointlander/rnn/model"
+)
+
+const (
+	// Architecture is the architecture name of the model files
+	Architecture = "feedforward"
+	// DistributionArchitecture is the architecture name of the distribution files
+	DistributionArchitecture = Architecture + ".distribution"
+)
+
+// Model converts the sample into a model
+func (s Sample) Model() *model.Model {
+	m := model.New(Architecture)
+	m.Config["inputs"] = 4
+	m.Config["middle"] = Middle
+	m.Config["outputs"] = 3
+	m.Metadata["loss"] = strconv.FormatFloat(s.Loss, 'g', -1, 64)
+	m.AddMatrix("Layer1Weights", s.Layer1Weights)
+	m.AddMatrix("Layer1Bias", s.Layer1Bias)
+	m.AddMatrix("Layer2Weights", s.Layer2Weights)
+	m.AddMatrix("Layer2Bias", s.Layer2Bias)
+	return m
+}
+
+// SampleFromModel creates a sample from a model
+func SampleFromModel(m *model.Model) (Sample, error) {
+	var s Sample
+	err := m.Check(Architecture, map[string]int{"inputs": 4, "middle": Middle, "outputs": 3})
+	if err != nil {
+		return s, err
+	}
+	if s.Layer1Weights, err = m.Matrix("Layer1Weights", 4, Middle); err != nil {
+		return s, err
+	}
+	if s.Layer1Bias, err = m.Matrix("Layer1Bias", 1, Middle); err != nil {
+		return s, err
+	}
+	if s.Layer2Weights, err = m.Matrix("Layer2Weights", Middle, 3); err != nil {
+		return s, err
+	}
+	if s.Layer2Bias, err = m.Matrix("Layer2Bias", 1, 3); err != nil {
+		return s, err
+	}
+	if loss, ok := m.Metadata["loss"]; ok {
+		s.Loss, _ = strconv.ParseFloat(loss, 64)
+	}
+	return s, nil
+}
+
+// Save saves the sample
+func (s Sample) Save(name string) error {
+	return model.Save(name, s.Model())
+}
+
+// Load loads a sample
+func Load(name string) (Sample, error) {
+	m, err := model.Load(name)
+	if err != nil {
+		return Sample{}, err
+	}
+	return SampleFromModel(m)
+}
+
+// Model converts the distribution into a model
+// Distributions that have been factored store the A matrix and mean U of each neuron
+func (d Distribution) Model() *model.Model {
+	m := model.New(DistributionArchitecture)
+	m.Config["inputs"] = 4
+	m.Config["middle"] = Middle
+	m.Config["outputs"] = 3
+	m.Config["multi"] = len(d.Multi)
+	if d.Multi != nil {
+		for i, multi := range d.Multi {
+			m.AddMatrix(fmt.Sprintf("Multi.%d.A", i), multi.A)
+			m.Add(model.NewF32(fmt.Sprintf("Multi.%d.U", i), []int{len(multi.U)}, multi.U))
+		}
+		return m
+	}
+	m.AddRandom("Layer1Weights", Middle, 4, d.Layer1Weights)
+	m.AddRandom("Layer1Bias", Middle, 1, d.Layer1Bias)
+	m.AddRandom("Layer2Weights", 3, Middle, d.Layer2Weights)
+	m.AddRandom("Layer2Bias", 3, 1, d.Layer2Bias)
+	return m
+}
+
+// DistributionFromModel creates a distribution from a model
+func DistributionFromModel(m *model.Model) (Distribution, error) {
+	var d Distribution
+	err := m.Check(DistributionArchitecture, map[string]int{"inputs": 4, "middle": Middle, "outputs": 3})
+	if err != nil {
+		return d, err
+	}
+	if count := m.Config["multi"]; count > 0 {
+		if count != Middle+3 {
+			return d, fmt.Errorf("distribution has %d neurons not %d", count, Middle+3)
+		}
+		for i := 0; i < count; i++ {
+			size := 4 + 1
+			if i >= Middle {
+				size = Middle + 1
+			}
+			a, err := m.Matrix(fmt.Sprintf("Multi.%d.A", i), size, size)
+			if err != nil {
+				return d, err
+			}
+			t, err := m.Tensor(fmt.Sprintf("Multi.%d.U", i))
+			if err != nil {
+				return d, err
+			}
+			u, err := t.Float32()
+			if err != nil {
+				return d, err
+			}
+			if len(u) != size {
+				return d, fmt.Errorf("tensor %s has %d values not %d", t.Name, len(u), size)
+			}
+			d.Multi = append(d.Multi, Multi{
+				A: a,
+				U: u,
+			})
+		}
+		return d, nil
+	}
+	if d.Layer1Weights, err = m.GetRandom("Layer1Weights", Middle, 4); err != nil {
+		return d, err
+	}
+	if d.Layer1Bias, err = m.GetRandom("Layer1Bias", Middle, 1); err != nil {
+		return d, err
+	}
+	if d.Layer2Weights, err = m.GetRandom("Layer2Weights", 3, Middle); err != nil {
+		return d, err
+	}
+	if d.Layer2Bias, err = m.GetRandom("Layer2Bias", 3, 1); err != nil {
+		return d, err
+	}
+	return d, nil
+}
+
+// Save saves the distribution
+func (d Distribution) Save(name string) error {
+	return model.Save(name, d.Model())
+}
+
+// LoadDistribution loads a distribution
+func LoadDistribution(name string) (Distribution, error) {
+	m, err := model.Load(name)
+	if err != nil {
+		return Distribution{}, err
+	}
+	return DistributionFromModel(m)
+}
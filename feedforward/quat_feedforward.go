@@ -5,14 +5,16 @@
 package feedforward
 
 import (
+	"context"
 	"fmt"
 	"math"
 	"math/rand"
-	"runtime"
 	"sort"
 
 	"github.com/pointlander/datum/iris"
 	. "github.com/pointlander/rnn/matrix/quaternion"
+	"github.com/pointlander/rnn/metrics"
+	"github.com/pointlander/rnn/train"
 
 	"gonum.org/v1/gonum/num/quat"
 )
@@ -49,14 +51,14 @@ type QuatSample struct {
 	Loss          float64
 }
 
-// NewQuatDistrution creates a new distribution of feed forward layers
-func NewQuatDistribution(rng *rand.Rand) QuatDistribution {
+// NewQuatDistrution creates a new distribution of feed forward layers with weights of standard deviation stddev
+func NewQuatDistribution(rng *rand.Rand, stddev float64) QuatDistribution {
 	layer1Weights := make([]QuatRandom, 0, 4*QuatMiddle)
 	//factor := math.Sqrt(2.0 / float64(4))
 	for i := 0; i < 4*QuatMiddle; i++ {
 		layer1Weights = append(layer1Weights, QuatRandom{
-			Mean:   [4]float64{0, 0, 0, 0},         //factor * rng.NormFloat64(),
-			Stddev: [4]float64{.25, .25, .25, .25}, //factor * rng.NormFloat64(),
+			Mean:   [4]float64{0, 0, 0, 0},                     //factor * rng.NormFloat64(),
+			Stddev: [4]float64{stddev, stddev, stddev, stddev}, //factor * rng.NormFloat64(),
 		})
 	}
 	layer1Bias := make([]QuatRandom, 0, QuatMiddle)
@@ -70,8 +72,8 @@ func NewQuatDistribution(rng *rand.Rand) QuatDistribution {
 	layer2Weights := make([]QuatRandom, 0, 2*QuatMiddle*3)
 	for i := 0; i < 2*QuatMiddle*3; i++ {
 		layer2Weights = append(layer2Weights, QuatRandom{
-			Mean:   [4]float64{0, 0, 0, 0},         //factor * rng.NormFloat64(),
-			Stddev: [4]float64{.25, .25, .25, .25}, //factor * rng.NormFloat64(),
+			Mean:   [4]float64{0, 0, 0, 0},                     //factor * rng.NormFloat64(),
+			Stddev: [4]float64{stddev, stddev, stddev, stddev}, //factor * rng.NormFloat64(),
 		})
 	}
 	//factor = math.Sqrt(2.0 / float64(3))
@@ -90,6 +92,23 @@ func NewQuatDistribution(rng *rand.Rand) QuatDistribution {
 	}
 }
 
+// Stddev returns the mean standard deviation of the random variables of the distribution
+func (d QuatDistribution) Stddev() float64 {
+	sum, count := 0.0, 0
+	for _, randoms := range [][]QuatRandom{d.Layer1Weights, d.Layer1Bias, d.Layer2Weights, d.Layer2Bias} {
+		for _, r := range randoms {
+			for _, stddev := range r.Stddev {
+				sum += stddev
+			}
+		}
+		count += 4 * len(randoms)
+	}
+	if count == 0 {
+		return 0
+	}
+	return sum / float64(count)
+}
+
 // QuatSample returns a sampled feedforward neural network
 func (d QuatDistribution) Sample(rng *rand.Rand) QuatSample {
 	var s QuatSample
@@ -138,35 +157,91 @@ func (d QuatDistribution) Sample(rng *rand.Rand) QuatSample {
 	return s
 }
 
-// QuatLearn learn the mode
-func QuatLearn() {
-	rng := rand.New(rand.NewSource(1))
-	data, err := iris.Load()
+// QuatDefaults are the default options of QuatLearn
+var QuatDefaults = train.Options{
+	Seed:        1,
+	Population:  QuatCount,
+	Generations: 2 * 1024,
+	Window:      QuatWindow,
+	Stddev:      .25,
+}
+
+// QuatResult is the result of learning the quaternion feedforward network
+type QuatResult struct {
+	Options   train.Options
+	Loss      float64
+	Updates   int
+	Confusion *metrics.Confusion
+	Sample    QuatSample `json:"-"`
+}
+
+// String is the report of the result
+func (r QuatResult) String() string {
+	return fmt.Sprintf("loss %f updates %d\n%s", r.Loss, r.Updates, r.Confusion)
+}
+
+// QuatTrainer learns a quaternion network that classifies the iris data set
+type QuatTrainer struct {
+	Options  train.Options
+	Observer train.Observer
+}
+
+// QuatLearn learn the mode, if the context is done the result of the best sample so far is returned
+func QuatLearn(ctx context.Context, options train.Options) QuatResult {
+	progress, err := train.NewProgress(options)
 	if err != nil {
 		panic(err)
 	}
+	defer progress.Close()
+	trainer := QuatTrainer{
+		Options:  options,
+		Observer: progress.Observe,
+	}
+	result, err := trainer.Train(ctx)
+	if err != nil && !train.Interrupted(err) {
+		panic(err)
+	}
+	return result
+}
 
-	for _, value := range data.Fisher {
-		sum := 0.0
-		for _, v := range value.Measures {
-			sum += v * v
-		}
-		length := math.Sqrt(sum)
-		for i := range value.Measures {
-			value.Measures[i] /= length
-		}
+// Train learns a sample from the iris data set, it returns the result of the best sample so far
+// with the error of the context if the context is done
+func (t QuatTrainer) Train(ctx context.Context) (QuatResult, error) {
+	data := loadIris()
+	best, updates, err := t.learn(ctx, data, rows(data))
+	result := QuatResult{
+		Options: t.Options.Merge(QuatDefaults),
+		Loss:    best.Loss,
+		Updates: updates,
+		Sample:  best,
+	}
+	// there is no sample to evaluate if the context was done before the first update
+	if updates > 0 {
+		result.Confusion = Evaluate(best, data, rows(data))
 	}
+	return result, err
+}
+
+// learn learns a sample from the rows of the data set and returns the
+// number of times the distribution was updated
+func (t QuatTrainer) learn(ctx context.Context, data iris.Datum, rows []int) (QuatSample, int, error) {
+	options := t.Options.Merge(QuatDefaults)
+	if err := options.Validate(); err != nil {
+		return QuatSample{}, 0, err
+	}
+	rng := rand.New(rand.NewSource(options.Seed))
 
-	distribution := NewQuatDistribution(rng)
-	networks := make([]QuatSample, QuatCount)
+	distribution := NewQuatDistribution(rng, options.Stddev)
+	networks := make([]QuatSample, options.Population)
+	window, updates := options.Window, 0
 	minLoss := math.MaxFloat64
-	done := make(chan bool, 8)
-	cpus := runtime.NumCPU()
+	pool := train.NewPool[struct{}](options.Workers, nil)
+	defer pool.Close()
 	best := QuatSample{}
-	inference := func(seed int64, j int) {
-		//rng := rand.New(rand.NewSource(seed))
+	inference := func(rng *rand.Rand, j int) {
+		networks[j] = distribution.Sample(rng)
 		loss := 0.0
-		for i := 0; i < 150; i++ {
+		for _, i := range rows {
 			fisher := data.Fisher[i]
 			input := NewMatrix(0, 4, 1)
 			for _, v := range fisher.Measures {
@@ -204,64 +279,64 @@ func QuatLearn() {
 			}
 		}
 		networks[j].Loss = loss
-		done <- true
 	}
-	for i := 0; i < 2*1024; i++ {
-		for j := range networks {
-			networks[j] = distribution.Sample(rng)
-		}
-		k, flight := 0, 0
-		for j := 0; j < cpus && k < len(networks); j++ {
-			go inference(rng.Int63(), k)
-			flight++
-			k++
+	for i := 0; i < options.Generations; i++ {
+		if err := ctx.Err(); err != nil {
+			return best, updates, err
 		}
-		for k < len(networks) {
-			<-done
-			flight--
-			go inference(rng.Int63(), k)
-			flight++
-			k++
-		}
-		for flight > 0 {
-			<-done
-			flight--
+		err := pool.Run(ctx, len(networks), func(w *train.Worker[struct{}], j int) error {
+			inference(w.Seed(train.Seed(options.Seed, i, j)), j)
+			return nil
+		})
+		if err != nil {
+			return best, updates, err
 		}
 		sort.Slice(networks, func(i, j int) bool {
 			return networks[i].Loss < networks[j].Loss
 		})
 		min, index := math.MaxFloat64, 0
-		for j := 0; j < QuatCount-QuatWindow; j++ {
+		for j := 0; j < options.Elite-window; j++ {
 			mean := 0.0
-			for k := 0; k < QuatWindow; k++ {
+			for k := 0; k < window; k++ {
 				mean += networks[j+k].Loss
 			}
-			mean /= QuatWindow
+			mean /= float64(window)
 			stddev := 0.0
-			for k := 0; k < QuatWindow; k++ {
+			for k := 0; k < window; k++ {
 				diff := mean - networks[j+k].Loss
 				stddev += diff * diff
 			}
-			stddev /= QuatWindow
+			stddev /= float64(window)
 			stddev = math.Sqrt(stddev)
 			if stddev < min {
 				min, index = stddev, j
 			}
 		}
+		losses := make([]float64, len(networks))
+		for j := range networks {
+			losses[j] = networks[j].Loss
+		}
+		stats := train.NewStats(i, losses)
+		stats.Spread = distribution.Stddev()
+		stats.Elite, stats.Stddev = index, min
 		if networks[index].Loss < minLoss {
 			best = networks[index]
 			minLoss = networks[index].Loss
-		} else {
+			stats.Improved = true
+		}
+		stats.Loss = minLoss
+		t.Observer.Observe(stats)
+		if !stats.Improved {
 			continue
 		}
-		fmt.Println(min, index, networks[index].Loss)
+		updates++
 		next := QuatDistribution{
 			Layer1Weights: make([]QuatRandom, len(distribution.Layer1Weights)),
 			Layer1Bias:    make([]QuatRandom, len(distribution.Layer1Bias)),
 			Layer2Weights: make([]QuatRandom, len(distribution.Layer2Weights)),
 			Layer2Bias:    make([]QuatRandom, len(distribution.Layer2Bias)),
 		}
-		for j := 0; j < QuatWindow; j++ {
+		for j := 0; j < window; j++ {
 			for k, value := range networks[index+j].Layer1Weights.Data {
 				next.Layer1Weights[k].Mean[0] += value.Real
 				next.Layer1Weights[k].Mean[1] += value.Imag
@@ -289,25 +364,25 @@ func QuatLearn() {
 		}
 		for j := range next.Layer1Weights {
 			for k := range next.Layer1Weights[j].Mean {
-				next.Layer1Weights[j].Mean[k] /= QuatWindow
+				next.Layer1Weights[j].Mean[k] /= float64(window)
 			}
 		}
 		for j := range next.Layer1Bias {
 			for k := range next.Layer1Bias[j].Mean {
-				next.Layer1Bias[j].Mean[k] /= QuatWindow
+				next.Layer1Bias[j].Mean[k] /= float64(window)
 			}
 		}
 		for j := range next.Layer2Weights {
 			for k := range next.Layer2Weights[j].Mean {
-				next.Layer2Weights[j].Mean[k] /= QuatWindow
+				next.Layer2Weights[j].Mean[k] /= float64(window)
 			}
 		}
 		for j := range next.Layer2Bias {
 			for k := range next.Layer2Bias[j].Mean {
-				next.Layer2Bias[j].Mean[k] /= QuatWindow
+				next.Layer2Bias[j].Mean[k] /= float64(window)
 			}
 		}
-		for j := 0; j < QuatWindow; j++ {
+		for j := 0; j < window; j++ {
 			for k, value := range networks[index+j].Layer1Weights.Data {
 				for l := range next.Layer1Weights[k].Mean {
 					v := 0.0
@@ -379,67 +454,49 @@ func QuatLearn() {
 		}
 		for j := range next.Layer1Weights {
 			for k := range next.Layer1Weights[j].Stddev {
-				next.Layer1Weights[j].Stddev[k] /= QuatWindow
+				next.Layer1Weights[j].Stddev[k] /= float64(window)
 				next.Layer1Weights[j].Stddev[k] = math.Sqrt(next.Layer1Weights[j].Stddev[k])
 			}
 		}
 		for j := range next.Layer1Bias {
 			for k := range next.Layer1Bias[j].Stddev {
-				next.Layer1Bias[j].Stddev[k] /= QuatWindow
+				next.Layer1Bias[j].Stddev[k] /= float64(window)
 				next.Layer1Bias[j].Stddev[k] = math.Sqrt(next.Layer1Bias[j].Stddev[k])
 			}
 		}
 		for j := range next.Layer2Weights {
 			for k := range next.Layer2Weights[j].Stddev {
-				next.Layer2Weights[j].Stddev[k] /= QuatWindow
+				next.Layer2Weights[j].Stddev[k] /= float64(window)
 				next.Layer2Weights[j].Stddev[k] = math.Sqrt(next.Layer2Weights[j].Stddev[k])
 			}
 		}
 		for j := range next.Layer2Bias {
 			for k := range next.Layer2Bias[j].Stddev {
-				next.Layer2Bias[j].Stddev[k] /= QuatWindow
+				next.Layer2Bias[j].Stddev[k] /= float64(window)
 				next.Layer2Bias[j].Stddev[k] = math.Sqrt(next.Layer2Bias[j].Stddev[k])
 			}
 		}
 		distribution = next
 	}
+	return best, updates, nil
+}
 
-	correct := 0
-	loss := 0.0
-	for _, fisher := range data.Fisher {
-		input := NewMatrix(0, 4, 1)
-		for _, v := range fisher.Measures {
-			input.Data = append(input.Data, quat.Number{
-				Real: v,
-				Imag: 0,
-				Jmag: 0,
-				Kmag: 0,
-			})
-		}
-
-		output := EverettActivation(Add(MulT(best.Layer1Weights, input),
-			best.Layer1Bias))
-		output = Add(MulT(best.Layer2Weights, output), best.Layer2Bias)
-		max, index := float32(0.0), 0
-		for i, value := range output.Data {
-			v := float32(quat.Abs(value))
-			if v > max {
-				max, index = v, i
-			}
-		}
-		fmt.Println(index, max)
-		if index == iris.Labels[fisher.Label] {
-			correct++
-		}
-
-		expected := make([]float32, 3)
-		expected[iris.Labels[fisher.Label]] = 1
-
-		for i, v := range output.Data {
-			diff := float64(float32(quat.Abs(v)) - expected[i])
-			loss += diff * diff
+// Classify returns the most probable class of the measures
+func (s QuatSample) Classify(measures []float64) int {
+	input := NewMatrix(0, 4, 1)
+	for _, v := range measures {
+		input.Data = append(input.Data, quat.Number{
+			Real: v,
+		})
+	}
+	output := EverettActivation(Add(MulT(s.Layer1Weights, input), s.Layer1Bias))
+	output = Add(MulT(s.Layer2Weights, output), s.Layer2Bias)
+	max, index := float32(0.0), 0
+	for i, value := range output.Data {
+		v := float32(quat.Abs(value))
+		if v > max {
+			max, index = v, i
 		}
 	}
-	fmt.Println("correct", correct, float64(correct)/150)
-	fmt.Println("loss", loss)
+	return index
 }
@@ -3,8 +3,12 @@ module github.com/pointlander/rnn
 go 1.21.3
 
 require (
+	github.com/pointlander/datum/iris v0.0.0-20200802052503-0ee610caba95
+	github.com/pointlander/gradient v0.0.0-20230828203002-af1492b01f47
 	github.com/ziutek/blas v0.0.0-20190227122918-da4ca23e90bb
 	gonum.org/v1/gonum v0.14.0
+	gonum.org/v1/plot v0.14.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -18,11 +22,8 @@ require (
 	github.com/golang/protobuf v1.4.2 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
 	github.com/pointlander/datum v0.0.0-20200802052503-0ee610caba95 // indirect
-	github.com/pointlander/datum/iris v0.0.0-20200802052503-0ee610caba95 // indirect
-	github.com/pointlander/gradient v0.0.0-20230828203002-af1492b01f47 // indirect
 	github.com/texttheater/golang-levenshtein/levenshtein v0.0.0-20200805054039-cae8b0eaed6c // indirect
 	golang.org/x/image v0.11.0 // indirect
 	golang.org/x/text v0.12.0 // indirect
-	gonum.org/v1/plot v0.14.0 // indirect
 	google.golang.org/protobuf v1.24.0 // indirect
 )
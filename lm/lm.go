@@ -0,0 +1,190 @@
+// Copyright 2023 The RNN Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package lm samples text from the recurrent and trnn language models
+package lm
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+
+	"github.com/pointlander/rnn/model"
+	"github.com/pointlander/rnn/recurrent"
+	"github.com/pointlander/rnn/tokenizer"
+	"github.com/pointlander/rnn/trnn"
+)
+
+// Sequence is the state of a language model reading a sequence of symbols
+type Sequence interface {
+	// Next feeds a symbol into the state and returns the probabilities of the next symbol
+	Next(symbol int) []float32
+}
+
+// Model is a language model with its tokenizer
+type Model struct {
+	Architecture string
+	Tokenizer    tokenizer.Tokenizer
+	// New creates the state of a new sequence, the states are independent
+	New func() Sequence
+}
+
+// NewModel creates a language model from a recurrent or trnn model
+func NewModel(m *model.Model) (Model, error) {
+	switch m.Architecture {
+	case recurrent.Architecture:
+		n, t, err := recurrent.NewNetwork(m)
+		if err != nil {
+			return Model{}, err
+		}
+		return Recurrent(n, t), nil
+	case trnn.Architecture:
+		n, t, err := trnn.NewNetwork(m)
+		if err != nil {
+			return Model{}, err
+		}
+		return TRNN(n, t), nil
+	}
+	return Model{}, fmt.Errorf("%s is not a language model", m.Architecture)
+}
+
+// Recurrent creates a language model from a recurrent network
+func Recurrent(n recurrent.Network, t tokenizer.Tokenizer) Model {
+	return Model{
+		Architecture: recurrent.Architecture,
+		Tokenizer:    t,
+		New: func() Sequence {
+			return n.NewState()
+		},
+	}
+}
+
+// TRNN creates a language model from a trnn network
+func TRNN(n trnn.Network, t tokenizer.Tokenizer) Model {
+	return Model{
+		Architecture: trnn.Architecture,
+		Tokenizer:    t,
+		New: func() Sequence {
+			return n.NewState()
+		},
+	}
+}
+
+// Load loads a recurrent or trnn model file
+func Load(name string) (Model, error) {
+	m, err := model.Load(name)
+	if err != nil {
+		return Model{}, err
+	}
+	return NewModel(m)
+}
+
+// Read feeds the symbols into the sequence and returns the probabilities of the next symbol,
+// nil if there are no symbols
+func Read(s Sequence, symbols []int) []float32 {
+	var probabilities []float32
+	for _, symbol := range symbols {
+		probabilities = s.Next(symbol)
+	}
+	return probabilities
+}
+
+// Symbol is a symbol with its text and probability
+type Symbol struct {
+	Symbol      int     `json:"symbol"`
+	Text        string  `json:"text"`
+	Probability float64 `json:"probability"`
+}
+
+// Top returns the k most probable symbols, all of them if k is not positive
+func (m Model) Top(probabilities []float32, k int) []Symbol {
+	sum := 0.0
+	for _, p := range probabilities {
+		sum += float64(p)
+	}
+	if sum == 0 {
+		sum = 1
+	}
+	symbols := make([]Symbol, len(probabilities))
+	for i, p := range probabilities {
+		symbols[i] = Symbol{
+			Symbol:      i,
+			Text:        string(m.Tokenizer.Decode([]int{i})),
+			Probability: float64(p) / sum,
+		}
+	}
+	sort.SliceStable(symbols, func(i, j int) bool {
+		return symbols[i].Probability > symbols[j].Probability
+	})
+	if k > 0 && k < len(symbols) {
+		symbols = symbols[:k]
+	}
+	return symbols
+}
+
+// Sampler picks the next symbol from the probabilities
+type Sampler struct {
+	// Temperature scales the probabilities, 0 picks the most probable symbol
+	Temperature float64 `json:"temperature"`
+	// TopK samples from the k most probable symbols, 0 for all of them
+	TopK int `json:"top_k"`
+}
+
+// Sample picks a symbol
+func (s Sampler) Sample(rng *rand.Rand, probabilities []float32) int {
+	if s.Temperature <= 0 {
+		max, index := float32(-1), 0
+		for i, p := range probabilities {
+			if p > max {
+				max, index = p, i
+			}
+		}
+		return index
+	}
+	indexes := make([]int, len(probabilities))
+	for i := range indexes {
+		indexes[i] = i
+	}
+	if s.TopK > 0 && s.TopK < len(indexes) {
+		sort.SliceStable(indexes, func(i, j int) bool {
+			return probabilities[indexes[i]] > probabilities[indexes[j]]
+		})
+		indexes = indexes[:s.TopK]
+	}
+	weights := make([]float64, len(indexes))
+	sum := 0.0
+	for i, index := range indexes {
+		weights[i] = math.Pow(math.Max(float64(probabilities[index]), 0), 1/s.Temperature)
+		sum += weights[i]
+	}
+	if sum == 0 {
+		return indexes[rng.Intn(len(indexes))]
+	}
+	r := rng.Float64() * sum
+	for i, weight := range weights {
+		r -= weight
+		if r < 0 {
+			return indexes[i]
+		}
+	}
+	return indexes[len(indexes)-1]
+}
+
+// Complete reads the prompt into a new sequence and samples length symbols after it
+func (m Model) Complete(rng *rand.Rand, prompt []byte, length int, sampler Sampler) ([]byte, error) {
+	symbols := m.Tokenizer.Encode(prompt)
+	if len(symbols) == 0 {
+		return nil, fmt.Errorf("the prompt is empty")
+	}
+	s := m.New()
+	probabilities := Read(s, symbols)
+	completion := make([]int, 0, length)
+	for i := 0; i < length; i++ {
+		symbol := sampler.Sample(rng, probabilities)
+		completion = append(completion, symbol)
+		probabilities = s.Next(symbol)
+	}
+	return m.Tokenizer.Decode(completion), nil
+}
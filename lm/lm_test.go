@@ -0,0 +1,69 @@
+// Copyright 2023 The RNN Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lm
+
+import (
+	"bytes"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/pointlander/rnn/tokenizer"
+	"github.com/pointlander/rnn/trnn"
+)
+
+func TestSampler(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	probabilities := []float32{.1, .6, .3}
+	if symbol := (Sampler{}).Sample(rng, probabilities); symbol != 1 {
+		t.Fatalf("greedy sample is %d", symbol)
+	}
+	counts := make([]int, len(probabilities))
+	for i := 0; i < 1000; i++ {
+		counts[Sampler{Temperature: 1, TopK: 2}.Sample(rng, probabilities)]++
+	}
+	if counts[0] != 0 || counts[1] < counts[2] || counts[2] == 0 {
+		t.Fatalf("top k counts %v", counts)
+	}
+}
+
+func TestREPL(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	m := TRNN(trnn.NewDistribution(rng, 256, trnn.Defaults.Stddev).Sample(rng), tokenizer.Byte{})
+	expected := m.Top(Read(m.New(), m.Tokenizer.Encode([]byte("ab\nc"))), 3)
+
+	r := REPL{
+		Model:  m,
+		TopK:   3,
+		Length: 0,
+		Rand:   rng,
+	}
+	var output bytes.Buffer
+	err := r.Run(strings.NewReader("ab\n:bogus\nc\n:quit\nd\n"), &output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	top := m.Top(r.probabilities, 3)
+	for i := range top {
+		if top[i] != expected[i] {
+			t.Fatalf("the lines were not fed separated by a newline %v %v", top, expected)
+		}
+	}
+	if !strings.Contains(output.String(), "unknown command :bogus") {
+		t.Fatalf("output %s", output.String())
+	}
+	r.Reset()
+	if r.probabilities != nil || r.Generate(4) != nil {
+		t.Fatal("the state was not reset")
+	}
+	expected = m.Top(Read(m.New(), m.Tokenizer.Encode([]byte("c"))), 3)
+	r.FeedLine([]byte("c"))
+	top = m.Top(r.probabilities, 3)
+	for i := range top {
+		if top[i] != expected[i] {
+			t.Fatalf("the first line after a reset was separated %v %v", top, expected)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+// Copyright 2023 The RNN Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lm
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+// REPL is an interactive session with a language model, the state of the model is kept across lines
+type REPL struct {
+	Model   Model
+	Sampler Sampler
+	// TopK is the number of next symbol probabilities shown after each line
+	TopK int
+	// Length is the number of symbols sampled after each line
+	Length int
+	Rand   *rand.Rand
+	// Load loads a model file for the load command
+	Load func(name string) (Model, error)
+
+	sequence      Sequence
+	probabilities []float32
+	// lines is the number of lines fed since the sequence was started
+	lines int
+}
+
+// REPLHelp is the help of the REPL commands
+const REPLHelp = `lines are fed to the model separated by newlines, which then continues the text
+:reset                 start a new sequence
+:temperature <value>   set the sampling temperature, 0 picks the most probable symbol
+:topk <k>              show the k most probable next symbols and sample from them, 0 samples from all of them
+:length <n>            sample n symbols after each line
+:load <file>           load another model file and start a new sequence
+:help                  show this help
+:quit                  exit
+`
+
+// Reset starts a new sequence
+func (r *REPL) Reset() {
+	r.sequence = r.Model.New()
+	r.probabilities = nil
+	r.lines = 0
+}
+
+// Feed feeds text into the sequence
+func (r *REPL) Feed(text []byte) {
+	if r.sequence == nil {
+		r.Reset()
+	}
+	if probabilities := Read(r.sequence, r.Model.Tokenizer.Encode(text)); probabilities != nil {
+		r.probabilities = probabilities
+	}
+}
+
+// FeedLine feeds a line into the sequence, separating it from the previous line with a newline
+func (r *REPL) FeedLine(line []byte) {
+	if r.sequence == nil {
+		r.Reset()
+	}
+	if r.lines > 0 {
+		line = append([]byte{'\n'}, line...)
+	}
+	r.Feed(line)
+	r.lines++
+}
+
+// Generate samples length symbols continuing the sequence
+func (r *REPL) Generate(length int) []byte {
+	if r.probabilities == nil {
+		return nil
+	}
+	symbols := make([]int, 0, length)
+	for i := 0; i < length; i++ {
+		symbol := r.Sampler.Sample(r.Rand, r.probabilities)
+		symbols = append(symbols, symbol)
+		r.probabilities = r.sequence.Next(symbol)
+	}
+	return r.Model.Tokenizer.Decode(symbols)
+}
+
+// Command runs a command line, it returns false if the session is over
+func (r *REPL) Command(w io.Writer, line string) (bool, error) {
+	fields := strings.Fields(line)
+	argument := func() (string, error) {
+		if len(fields) != 2 {
+			return "", fmt.Errorf("%s needs one argument", fields[0])
+		}
+		return fields[1], nil
+	}
+	switch fields[0] {
+	case ":quit", ":exit":
+		return false, nil
+	case ":help":
+		fmt.Fprint(w, REPLHelp)
+	case ":reset":
+		r.Reset()
+		fmt.Fprintln(w, "reset")
+	case ":temperature":
+		value, err := argument()
+		if err != nil {
+			return true, err
+		}
+		temperature, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return true, err
+		}
+		r.Sampler.Temperature = temperature
+		fmt.Fprintln(w, "temperature", temperature)
+	case ":topk":
+		value, err := argument()
+		if err != nil {
+			return true, err
+		}
+		k, err := strconv.Atoi(value)
+		if err != nil {
+			return true, err
+		}
+		r.TopK, r.Sampler.TopK = k, k
+		fmt.Fprintln(w, "topk", k)
+	case ":length":
+		value, err := argument()
+		if err != nil {
+			return true, err
+		}
+		length, err := strconv.Atoi(value)
+		if err != nil {
+			return true, err
+		}
+		if length < 0 {
+			return true, fmt.Errorf("length %d is negative", length)
+		}
+		r.Length = length
+		fmt.Fprintln(w, "length", length)
+	case ":load":
+		name, err := argument()
+		if err != nil {
+			return true, err
+		}
+		if r.Load == nil {
+			return true, fmt.Errorf("loading is not supported")
+		}
+		m, err := r.Load(name)
+		if err != nil {
+			return true, err
+		}
+		r.Model = m
+		r.Reset()
+		fmt.Fprintln(w, "loaded", m.Architecture, name)
+	default:
+		return true, fmt.Errorf("unknown command %s, :help lists the commands", fields[0])
+	}
+	return true, nil
+}
+
+// Run reads lines from the reader until it ends or the quit command, printing the
+// continuation of each line and the most probable next symbols
+func (r *REPL) Run(reader io.Reader, w io.Writer) error {
+	if r.sequence == nil {
+		r.Reset()
+	}
+	scanner := bufio.NewScanner(reader)
+	fmt.Fprint(w, "> ")
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, ":") {
+			more, err := r.Command(w, line)
+			if err != nil {
+				fmt.Fprintln(w, "error:", err)
+			}
+			if !more {
+				return nil
+			}
+		} else if line != "" {
+			r.FeedLine([]byte(line))
+			fmt.Fprintf(w, "%q\n", r.Generate(r.Length))
+			if r.TopK > 0 && r.probabilities != nil {
+				for _, symbol := range r.Model.Top(r.probabilities, r.TopK) {
+					fmt.Fprintf(w, "  %q %d %f\n", symbol.Text, symbol.Symbol, symbol.Probability)
+				}
+			}
+		}
+		fmt.Fprint(w, "> ")
+	}
+	return scanner.Err()
+}
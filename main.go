@@ -5,73 +5,1012 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"flag"
+	"fmt"
 	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
+	"github.com/pointlander/rnn/corpus"
 	"github.com/pointlander/rnn/discrete"
 	"github.com/pointlander/rnn/encdec"
 	"github.com/pointlander/rnn/feedforward"
+	"github.com/pointlander/rnn/lm"
 	"github.com/pointlander/rnn/matrix/f32"
+	"github.com/pointlander/rnn/mlp"
+	"github.com/pointlander/rnn/model"
+	"github.com/pointlander/rnn/quanta"
 	"github.com/pointlander/rnn/recurrent"
+	"github.com/pointlander/rnn/serve"
+	"github.com/pointlander/rnn/sweep"
+	"github.com/pointlander/rnn/tokenizer"
+	"github.com/pointlander/rnn/train"
 	"github.com/pointlander/rnn/trnn"
+	"github.com/pointlander/rnn/visualize"
 )
 
-var (
-	// FlagRecurrent recurrent mode
-	FlagRecurrent = flag.Bool("recurrent", false, "recurrent mode")
-	// FlagEncDec is the encoder decoder mode
-	FlagEncDec = flag.Bool("encdec", false, "encoder decoder mode")
-	// FlagTRNN is the transformer recurrent neural network mode
-	FlagTRNN = flag.Bool("trnn", false, "transformer recurrent neural network mode")
-	// FlagDiscrete discrete mode
-	FlagDiscrete = flag.Bool("discrete", false, "discrete mode")
-	// FlagForward feedforward mode
-	FlagForward = flag.Bool("forward", false, "feedforward mode")
-	// FlagComplexForward feedforward mode
-	FlagComplexForward = flag.Bool("complexforward", false, "complex feedforward mode")
-	// FlagInfer inference mode
-	FlagInfer = flag.Bool("infer", false, "inference mode")
-)
+// Commands are the subcommands of every model
+var Commands = []string{"train", "infer", "eval", "export", "bench", "verify"}
 
-func main() {
-	flag.Parse()
+// Flags are the flags of a subcommand
+type Flags struct {
+	train.Options
+	// Context is canceled by the first interrupt
+	Context context.Context
+	// Set is the flag set the flags are parsed by
+	Set *flag.FlagSet
+	// Model is the model file used for inference
+	Model string
+	// Distribution samples the inference network from a distribution file
+	Distribution string
+	// Ensemble is the number of networks sampled from the distribution for ensemble inference
+	Ensemble int
+	// JSON prints the results as JSON
+	JSON bool
+	// Tokenizer is the tokenizer for the sequence models
+	Tokenizer string
+	// Vocabulary is the size of the bpe vocabulary
+	Vocabulary int
+	// Pairs is true if the data file holds source and target pairs
+	Pairs bool
+	// Index is a file of lines to index with the encdec encoder or a saved .index file
+	Index string
+	// Neighbours is the number of nearest neighbours returned for each query
+	Neighbours int
+	// Folds is the number of folds of the feedforward cross validation
+	Folds int
+	// HoldOut is the fraction of the data held out to test the feedforward variant
+	HoldOut float64
+	// Label is the name or index of the label column of the CSV file
+	Label string
+	// Header is true if the CSV file has a header row
+	Header bool
+	// Normalize is the normalization of the CSV features
+	Normalize string
+	// Layers is the widths of the hidden layers
+	Layers string
+	// Activations is the activations of the hidden layers and the output layer
+	Activations string
+	// Regression learns the label column as a numeric regression target
+	Regression bool
+	// Cost is the cost function of the regression
+	Cost string
+	// Batch is the number of rows each network is scored on per generation
+	Batch int
+	// Width is the width of the quanta layer or neuron
+	Width int
+	// Iterations is the number of quanta iterations
+	Iterations int
+	// Remote is the comma separated addresses of the trnn worker processes
+	Remote string
+}
 
-	if *FlagTRNN {
-		if *FlagInfer {
-			trnn.Infer()
-			return
+// IsSet returns true if the flag was set on the command line
+func (f *Flags) IsSet(name string) bool {
+	set := false
+	f.Set.Visit(func(flag *flag.Flag) {
+		if flag.Name == name {
+			set = true
 		}
-		trnn.Learn()
-		return
-	} else if *FlagRecurrent {
-		if *FlagInfer {
-			recurrent.Infer()
-			return
+	})
+	return set
+}
+
+// Rand returns a random number generator seeded with the seed flag
+func (f *Flags) Rand() *rand.Rand {
+	return rand.New(rand.NewSource(f.Seed))
+}
+
+// Report prints a result as text or as JSON
+func (f *Flags) Report(result fmt.Stringer) {
+	if f.JSON {
+		data, err := json.MarshalIndent(result, "", "  ")
+		if err != nil {
+			panic(err)
 		}
-		recurrent.Learn()
-		return
-	} else if *FlagEncDec {
-		encdec.Learn()
-		return
-	} else if *FlagDiscrete {
-		discrete.Learn()
-		return
-	} else if *FlagForward {
-		feedforward.Learn()
-		return
-	} else if *FlagComplexForward {
-		feedforward.ComplexLearn()
+		fmt.Println(string(data))
 		return
 	}
+	fmt.Print(result)
+}
+
+// NewTokenizer creates the tokenizer selected by the flags, the bpe vocabulary is learned from the data file
+func (f *Flags) NewTokenizer() tokenizer.Tokenizer {
+	if f.Tokenizer == "byte" {
+		return tokenizer.Byte{}
+	}
+	data, err := corpus.Load(f.Data)
+	if err != nil {
+		panic(err)
+	}
+	t, err := tokenizer.New(f.Tokenizer, data, f.Vocabulary)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// MLPConfig creates the multi-layer perceptron configuration selected by the flags
+func (f *Flags) MLPConfig() mlp.Config {
+	config := mlp.Config{
+		Population:  f.Population,
+		Generations: f.Generations,
+		Window:      f.Window,
+		Batch:       f.Batch,
+		Seed:        f.Seed,
+		Workers:     f.Workers,
+		Log:         f.Log,
+	}
+	for _, width := range strings.Split(f.Layers, ",") {
+		if width = strings.TrimSpace(width); width == "" {
+			continue
+		}
+		value, err := strconv.Atoi(width)
+		if err != nil {
+			panic(err)
+		}
+		config.Hidden = append(config.Hidden, value)
+	}
+	list := f.Activations
+	if f.Regression {
+		if !f.IsSet("activations") {
+			list = "everett,linear"
+		}
+		cost, err := mlp.ParseCost(f.Cost)
+		if err != nil {
+			panic(err)
+		}
+		config.Cost = cost
+	}
+	activations, err := mlp.ParseActivations(list)
+	if err != nil {
+		panic(err)
+	}
+	config.Activations = activations
+	return config
+}
+
+// QuantaConfig creates the quanta configuration selected by the flags, the flags that are not set keep the defaults
+func (f *Flags) QuantaConfig(config quanta.Config) (quanta.Config, error) {
+	config.Seed = f.Seed
+	if f.IsSet("width") {
+		config.Width = f.Width
+	}
+	if f.IsSet("iterations") {
+		config.Iterations = f.Iterations
+	}
+	if config.Width <= 0 || config.Width%64 != 0 {
+		return config, fmt.Errorf("-width %d is not a positive multiple of 64", config.Width)
+	}
+	if config.Iterations < 0 {
+		return config, fmt.Errorf("-iterations %d is negative", config.Iterations)
+	}
+	return config, nil
+}
+
+// Model is a model of the command line
+type Model struct {
+	// Defaults are the default options of the model
+	Defaults train.Options
+	// Flags adds the flags of the model
+	Flags func(f *Flags)
+	// Train, Infer, Eval and Bench run the subcommands, nil if the model does not support them
+	Train func(f *Flags)
+	Infer func(f *Flags)
+	Eval  func(f *Flags)
+	Bench func(f *Flags)
+}
+
+// TextFlags adds the flags of the text models
+func TextFlags(f *Flags) {
+	f.Set.StringVar(&f.Tokenizer, "tokenizer", "byte", "tokenizer: byte, rune or bpe")
+	f.Set.IntVar(&f.Vocabulary, "vocabulary", 512, "size of the bpe vocabulary")
+}
+
+// FeedforwardFlags adds the flags of the feedforward variants
+func FeedforwardFlags(f *Flags) {
+	f.Set.IntVar(&f.Folds, "folds", 0, "evaluate with stratified k-fold cross validation, at least 2 folds, 5 if -holdout is not set")
+	f.Set.Float64Var(&f.HoldOut, "holdout", 0, "evaluate on a held out fraction of the data in (0, 1)")
+	f.Set.BoolVar(&f.JSON, "json", false, "print the results as JSON")
+}
+
+// Feedforward evaluates a feedforward variant
+func Feedforward(variant string) func(f *Flags) {
+	return func(f *Flags) {
+		var err error
+		switch {
+		case f.IsSet("holdout") && !(f.HoldOut > 0 && f.HoldOut < 1):
+			err = fmt.Errorf("-holdout %g is not in (0, 1)", f.HoldOut)
+		case f.IsSet("folds") && f.Folds < 2:
+			err = fmt.Errorf("-folds %d is less than 2", f.Folds)
+		case f.HoldOut > 0:
+			err = feedforward.HoldOut(f.Context, variant, f.HoldOut, f.Options)
+		default:
+			folds := f.Folds
+			if folds <= 0 {
+				folds = 5
+			}
+			err = feedforward.CrossValidate(f.Context, variant, folds, f.Options)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+	}
+}
+
+// MLPDefaults are the default options of the multi-layer perceptron
+var MLPDefaults = train.Options{
+	Seed:        1,
+	Population:  256,
+	Generations: 256,
+	Window:      16,
+	Output:      "mlp.model",
+}
+
+// Models are the models of the command line
+var Models = map[string]Model{
+	"recurrent": {
+		Defaults: recurrent.Defaults,
+		Flags:    TextFlags,
+		Train: func(f *Flags) {
+			recurrent.Learn(f.Context, f.NewTokenizer(), f.Options)
+		},
+		Infer: func(f *Flags) {
+			if f.Ensemble > 0 {
+				d, t, err := recurrent.LoadDistribution(f.Distribution)
+				if err != nil {
+					panic(err)
+				}
+				recurrent.Ensemble(d, t, f.Rand(), f.Ensemble)
+				return
+			}
+			var n recurrent.Network
+			var t tokenizer.Tokenizer
+			var err error
+			if f.IsSet("distribution") {
+				var d recurrent.Distribution
+				d, t, err = recurrent.LoadDistribution(f.Distribution)
+				n = d.Sample(f.Rand())
+			} else {
+				n, t, err = recurrent.Load(f.Model)
+			}
+			if err != nil {
+				panic(err)
+			}
+			recurrent.Infer(n, t)
+		},
+	},
+	"trnn": {
+		Defaults: trnn.Defaults,
+		Flags: func(f *Flags) {
+			TextFlags(f)
+			f.Set.StringVar(&f.Remote, "remote", "", "comma separated addresses of worker processes the networks are evaluated on")
+		},
+		Train: func(f *Flags) {
+			var remote []string
+			if f.Remote != "" {
+				remote = strings.Split(f.Remote, ",")
+			}
+			trnn.Learn(f.Context, f.NewTokenizer(), f.Options, remote)
+		},
+		Infer: func(f *Flags) {
+			var n trnn.Network
+			var t tokenizer.Tokenizer
+			var err error
+			if f.IsSet("distribution") {
+				var d trnn.Distribution
+				d, t, err = trnn.LoadDistribution(f.Distribution)
+				n = d.Sample(f.Rand())
+			} else {
+				n, t, err = trnn.Load(f.Model)
+			}
+			if err != nil {
+				panic(err)
+			}
+			trnn.Infer(n, t)
+		},
+	},
+	"encdec": {
+		Defaults: encdec.Defaults,
+		Flags: func(f *Flags) {
+			f.Set.BoolVar(&f.Pairs, "pairs", false, "the -data file, which must be set, is a tsv or jsonl file of source and target pairs")
+			f.Set.StringVar(&f.Index, "index", "", "file of lines to index with the encoder, saved as <file>.index, or a saved .index file")
+			f.Set.IntVar(&f.Neighbours, "neighbours", 5, "number of nearest neighbours returned for each query")
+		},
+		Train: func(f *Flags) {
+			if f.Pairs {
+				if !f.IsSet("data") {
+					fmt.Fprintln(os.Stderr, "-pairs needs a -data file of source and target pairs")
+					os.Exit(2)
+				}
+				encdec.LearnPairs(f.Context, f.Options)
+				return
+			}
+			encdec.Learn(f.Context, f.Options)
+		},
+		Infer: func(f *Flags) {
+			n := loadEncDec(f)
+			if f.Index != "" {
+				var x *encdec.Index
+				var err error
+				if strings.HasSuffix(f.Index, ".index") {
+					x, err = encdec.LoadIndex(f.Index)
+				} else {
+					x, err = encdec.NewIndex(&n, f.Index)
+					if err == nil {
+						err = x.Save(f.Index + ".index")
+					}
+				}
+				if err != nil {
+					panic(err)
+				}
+				encdec.Query(n, x, f.Set.Args(), f.Neighbours)
+				return
+			}
+			inputs := f.Set.Args()
+			if len(inputs) == 0 {
+				inputs = []string{"In the beginning God created the heaven and the earth."}
+			}
+			encdec.Infer(n, inputs)
+		},
+		Eval: func(f *Flags) {
+			if !f.Pairs {
+				panic(fmt.Errorf("encdec is evaluated on a -data file of -pairs"))
+			}
+			n := loadEncDec(f)
+			pairs, err := encdec.LoadPairs(f.Data)
+			if err != nil {
+				panic(err)
+			}
+			encdec.InferPairs(n, pairs)
+		},
+	},
+	"discrete": {
+		Defaults: discrete.Defaults,
+		Train: func(f *Flags) {
+			discrete.Learn(f.Context, f.Options)
+		},
+		Infer: func(f *Flags) {
+			name := f.Output
+			if f.IsSet("distribution") {
+				name = f.Distribution
+			}
+			d, err := discrete.LoadDistribution(name)
+			if err != nil {
+				panic(err)
+			}
+			discrete.Infer(d, f.Seed)
+		},
+	},
+	"feedforward": {
+		Defaults: feedforward.Defaults,
+		Flags:    FeedforwardFlags,
+		Train: func(f *Flags) {
+			feedforward.Learn(f.Context, f.Options)
+		},
+		Infer: func(f *Flags) {
+			if f.Ensemble > 0 {
+				d, err := feedforward.LoadDistribution(f.Distribution)
+				if err != nil {
+					panic(err)
+				}
+				feedforward.Ensemble(d, f.Rand(), f.Ensemble)
+				return
+			}
+			var s feedforward.Sample
+			var err error
+			if f.IsSet("distribution") {
+				var d feedforward.Distribution
+				d, err = feedforward.LoadDistribution(f.Distribution)
+				s = d.Sample(f.Rand())
+			} else {
+				s, err = feedforward.Load(f.Model)
+			}
+			if err != nil {
+				panic(err)
+			}
+			feedforward.Infer(s)
+		},
+		Eval: Feedforward("real"),
+	},
+	"complex": {
+		Defaults: feedforward.ComplexDefaults,
+		Flags:    FeedforwardFlags,
+		Train: func(f *Flags) {
+			feedforward.ComplexLearn(f.Context, f.Options)
+		},
+		Eval: Feedforward("complex"),
+	},
+	"quaternion": {
+		Defaults: feedforward.QuatDefaults,
+		Flags:    FeedforwardFlags,
+		Train: func(f *Flags) {
+			f.Report(feedforward.QuatLearn(f.Context, f.Options))
+		},
+		Eval: Feedforward("quaternion"),
+	},
+	"mlp": {
+		Defaults: MLPDefaults,
+		Flags: func(f *Flags) {
+			f.Set.StringVar(&f.Label, "label", "-1", "name or index of the label column, negative indexes count from the end")
+			f.Set.BoolVar(&f.Header, "header", true, "the CSV file has a header row")
+			f.Set.StringVar(&f.Normalize, "normalize", "zscore", "normalization: none, minmax, zscore or unit")
+			f.Set.StringVar(&f.Layers, "layers", "16", "comma separated widths of the hidden layers")
+			f.Set.StringVar(&f.Activations, "activations", "step,softmax", "comma separated activations of the hidden and output layers: step, sigmoid, everett, softmax or linear, everett,linear for regression")
+			f.Set.BoolVar(&f.Regression, "regression", false, "learn the label column as a numeric regression target")
+			f.Set.StringVar(&f.Cost, "cost", "mse", "regression cost: mse, mae or huber")
+			f.Set.IntVar(&f.Batch, "batch", 0, "number of rows each network is scored on per generation, 0 for all")
+		},
+		Train: func(f *Flags) {
+			if f.Data == "" {
+				panic(fmt.Errorf("mlp is trained on a CSV -data file"))
+			}
+			if f.Regression {
+				mlp.LearnRegressionCSV(f.Context, f.Data, f.Label, f.Header, mlp.Normalization(f.Normalize), f.MLPConfig(), f.Output)
+				return
+			}
+			mlp.LearnCSV(f.Context, f.Data, f.Label, f.Header, mlp.Normalization(f.Normalize), f.MLPConfig(), f.Output)
+		},
+		Eval: func(f *Flags) {
+			if f.Data == "" {
+				panic(fmt.Errorf("mlp is evaluated on a CSV -data file"))
+			}
+			if f.Regression {
+				r, err := mlp.LoadRegressor(f.Model)
+				if err != nil {
+					panic(err)
+				}
+				mlp.InferRegressionCSV(r, f.Data, f.Label, f.Header)
+				return
+			}
+			c, err := mlp.Load(f.Model)
+			if err != nil {
+				panic(err)
+			}
+			mlp.InferCSV(c, f.Data, f.Label, f.Header)
+		},
+	},
+	"quanta": {
+		Defaults: train.Options{
+			Seed: quanta.DefaultConfig.Seed,
+		},
+		Flags: func(f *Flags) {
+			f.Set.IntVar(&f.Width, "width", 0, "width of the layer or neuron, a multiple of 64, 256 for bench and 1024 for eval if not set")
+			f.Set.IntVar(&f.Iterations, "iterations", 0, "number of benchmark iterations or test trials, 1000000 for bench and 128 for eval if not set")
+			f.Set.BoolVar(&f.JSON, "json", false, "print the results as JSON")
+		},
+		Eval: func(f *Flags) {
+			config, err := f.QuantaConfig(quanta.DefaultTestConfig)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(2)
+			}
+			f.Report(quanta.Test(config))
+		},
+		Bench: func(f *Flags) {
+			config, err := f.QuantaConfig(quanta.DefaultConfig)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(2)
+			}
+			f.Report(quanta.Learn(config))
+		},
+	},
+	"factor": {
+		Defaults: train.Options{
+			Seed: 1,
+		},
+		Bench: func(f *Flags) {
+			rng := f.Rand()
+			vars := make([][]float32, 16)
+			for i := range vars {
+				vars[i] = make([]float32, 8)
+				for j := range vars[i] {
+					vars[i][j] = float32(rng.NormFloat64())
+				}
+			}
+			f32.Factor(vars, true)
+		},
+	},
+}
+
+// loadEncDec loads the encdec network from the model file or samples it from the distribution file
+func loadEncDec(f *Flags) encdec.Network {
+	if f.IsSet("distribution") {
+		d, err := encdec.LoadDistribution(f.Distribution)
+		if err != nil {
+			panic(err)
+		}
+		return d.Sample(f.Rand())
+	}
+	n, err := encdec.Load(f.Model)
+	if err != nil {
+		panic(err)
+	}
+	return n
+}
+
+// Run returns the function of a subcommand of a model or nil if the model does not support it
+func (m Model) Run(command string) func(f *Flags) {
+	switch command {
+	case "train":
+		return m.Train
+	case "infer":
+		return m.Infer
+	case "eval":
+		return m.Eval
+	case "export":
+		return Export
+	case "bench":
+		if m.Bench == nil && m.Train != nil {
+			return Bench(m.Train)
+		}
+		return m.Bench
+	case "verify":
+		if m.Train != nil {
+			return Verify(m.Train)
+		}
+	}
+	return nil
+}
+
+// Export converts the -model file to the -output .safetensors, .npz or model file
+func Export(f *Flags) {
+	if !f.IsSet("output") {
+		panic(fmt.Errorf("export needs an -output file"))
+	}
+	m, err := model.LoadAny(f.Model)
+	if err != nil {
+		panic(err)
+	}
+	err = model.SaveAny(f.Output, m)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// Bench times the training of a model
+func Bench(learn func(f *Flags)) func(f *Flags) {
+	return func(f *Flags) {
+		start := time.Now()
+		learn(f)
+		elapsed := time.Since(start)
+		fmt.Println("bench", elapsed)
+		if f.Generations > 0 {
+			fmt.Println(elapsed/time.Duration(f.Generations), "per generation")
+		}
+	}
+}
 
-	//feedforward.QuatLearn()
-	rng := rand.New(rand.NewSource(1))
-	vars := make([][]float32, 16)
-	for i := range vars {
-		vars[i] = make([]float32, 8)
-		for j := range vars[i] {
-			vars[i][j] = float32(rng.NormFloat64())
+// Verify trains a model with one worker and with -workers workers, at least two, and checks that
+// the logs and the tensors of the saved models are identical
+func Verify(learn func(f *Flags)) func(f *Flags) {
+	return func(f *Flags) {
+		directory, err := os.MkdirTemp("", "verify")
+		if err != nil {
+			panic(err)
 		}
+		defer os.RemoveAll(directory)
+		workers := []int{1, f.Workers}
+		if workers[1] < 2 {
+			workers[1] = 2
+		}
+		outputs := make([]*Flags, len(workers))
+		for i, w := range workers {
+			run := *f
+			run.Workers = w
+			dir := filepath.Join(directory, strconv.Itoa(w))
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				panic(err)
+			}
+			if f.Output != "" {
+				run.Output = filepath.Join(dir, filepath.Base(f.Output))
+			}
+			run.Log = filepath.Join(dir, "log.jsonl")
+			learn(&run)
+			outputs[i] = &run
+		}
+		a, err := train.ReadLog(outputs[0].Log)
+		if err != nil {
+			panic(err)
+		}
+		b, err := train.ReadLog(outputs[1].Log)
+		if err != nil {
+			panic(err)
+		}
+		if len(a) != len(b) {
+			panic(fmt.Errorf("%d generations with %d workers and %d with %d", len(a), workers[0], len(b), workers[1]))
+		}
+		for i := range a {
+			if a[i].Best != b[i].Best || a[i].Median != b[i].Median || a[i].Worst != b[i].Worst ||
+				a[i].Stddev != b[i].Stddev || a[i].Spread != b[i].Spread || a[i].Loss != b[i].Loss {
+				panic(fmt.Errorf("generation %d differs with %d and %d workers: %+v %+v", i, workers[0], workers[1], a[i], b[i]))
+			}
+		}
+		if outputs[0].Output != "" {
+			for _, name := range []string{outputs[0].Output, outputs[0].Options.Distribution()} {
+				if _, err := os.Stat(name); os.IsNotExist(err) {
+					continue
+				}
+				other := filepath.Join(directory, strconv.Itoa(workers[1]), filepath.Base(name))
+				if err := sameModel(name, other); err != nil {
+					panic(fmt.Errorf("%s differs with %d and %d workers: %w", filepath.Base(name), workers[0], workers[1], err))
+				}
+			}
+		}
+		fmt.Printf("verified: %d generations are identical with %d and %d workers\n", len(a), workers[0], workers[1])
+	}
+}
+
+// sameModel checks that two model files have the same architecture, configuration, tokenizer and tensors,
+// the metadata is ignored because it holds the time the model was created
+func sameModel(x, y string) error {
+	a, err := model.Load(x)
+	if err != nil {
+		return err
+	}
+	b, err := model.Load(y)
+	if err != nil {
+		return err
+	}
+	if a.Architecture != b.Architecture || !reflect.DeepEqual(a.Config, b.Config) {
+		return fmt.Errorf("architecture %s %v and %s %v", a.Architecture, a.Config, b.Architecture, b.Config)
+	}
+	if !reflect.DeepEqual(a.Tokenizer, b.Tokenizer) {
+		return errors.New("the tokenizers differ")
+	}
+	if len(a.Tensors) != len(b.Tensors) {
+		return fmt.Errorf("%d and %d tensors", len(a.Tensors), len(b.Tensors))
+	}
+	for i, t := range a.Tensors {
+		u := b.Tensors[i]
+		if t.Name != u.Name || t.DType != u.DType || !reflect.DeepEqual(t.Shape, u.Shape) || !bytes.Equal(t.Data, u.Data) {
+			return fmt.Errorf("tensor %s differs", t.Name)
+		}
+	}
+	return nil
+}
+
+// NewFlags creates the flags of a subcommand of a model
+func NewFlags(command, name string, m Model) *Flags {
+	f := &Flags{
+		Set: flag.NewFlagSet(command+" "+name, flag.ExitOnError),
+	}
+	defaults := m.Defaults
+	f.Set.Int64Var(&f.Seed, "seed", defaults.Seed, "seed of the search, the data splits and the sampling from a distribution")
+	f.Set.IntVar(&f.Population, "population", defaults.Population, "number of networks sampled per generation")
+	f.Set.IntVar(&f.Generations, "generations", defaults.Generations, "number of generations")
+	f.Set.IntVar(&f.Window, "window", defaults.Window, "number of elite networks the distribution is estimated from")
+	f.Set.IntVar(&f.Elite, "elite", 0, "number of the best networks the elite window is searched in, 0 for the model default")
+	f.Set.IntVar(&f.Workers, "workers", 0, "number of networks evaluated in parallel, 0 for the number of CPUs")
+	f.Set.StringVar(&f.Data, "data", defaults.Data, "path of the data")
+	f.Set.StringVar(&f.Output, "output", defaults.Output, "path of the output model file, the distribution is saved next to it")
+	f.Set.IntVar(&f.Snapshot, "snapshot", 0, "save the distribution of the recurrent and trnn models every n generations next to the output file")
+	f.Set.StringVar(&f.Log, "log", "", "path of the per-generation training log, CSV if it ends in .csv and JSON lines otherwise")
+	f.Set.StringVar(&f.Model, "model", defaults.Output, "model file for inference, evaluation and export")
+	f.Set.StringVar(&f.Distribution, "distribution", defaults.Distribution(), "sample the inference network from a distribution file")
+	f.Set.IntVar(&f.Ensemble, "ensemble", 0, "number of networks sampled from the distribution for ensemble inference")
+	if defaults.Stddev > 0 {
+		f.Set.Float64Var(&f.Stddev, "stddev", defaults.Stddev, "initial standard deviation of the weights of the distribution")
+	}
+	if m.Flags != nil {
+		m.Flags(f)
+	}
+	return f
+}
+
+// Plot renders the loss curves of training logs
+func Plot(args []string) {
+	set := flag.NewFlagSet("plot", flag.ExitOnError)
+	output := set.String("output", "loss.png", "path of the PNG or SVG file")
+	set.Usage = func() {
+		fmt.Fprintf(set.Output(), "usage: %s plot [flags] <log>...\n", filepath.Base(os.Args[0]))
+		set.PrintDefaults()
+	}
+	set.Parse(args)
+	if set.NArg() == 0 {
+		set.Usage()
+		os.Exit(2)
+	}
+	logs := make([][]train.Record, set.NArg())
+	for i, name := range set.Args() {
+		records, err := train.ReadLog(name)
+		if err != nil {
+			panic(err)
+		}
+		logs[i] = records
+	}
+	err := train.Plot(*output, set.Args(), logs)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// Serve serves the language model and the classifier over HTTP until it is interrupted
+func Serve(args []string) {
+	set := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := set.String("addr", "localhost:8080", "address to listen on")
+	language := set.String("model", "", "recurrent or trnn model file for /complete and /next")
+	classifier := set.String("classifier", "", "feedforward or mlp model file for /classify")
+	seed := set.Int64("seed", 0, "seed of the sampling of requests without a seed, 0 for the time")
+	set.Parse(args)
+	if *language == "" && *classifier == "" {
+		fmt.Fprintln(os.Stderr, "serve needs a -model or a -classifier file")
+		set.Usage()
+		os.Exit(2)
+	}
+	s := serve.NewServer(*language, *classifier, *seed)
+	for _, file := range []*serve.File{s.Language, s.Classifier} {
+		if file.Name == "" {
+			continue
+		}
+		if _, err := file.Get(); err != nil {
+			panic(err)
+		}
+	}
+	server := &http.Server{
+		Addr:    *addr,
+		Handler: s.Handler(),
+	}
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	go func() {
+		<-ctx.Done()
+		server.Shutdown(context.Background())
+	}()
+	fmt.Println("serving on", *addr)
+	err := server.ListenAndServe()
+	if err != nil && err != http.ErrServerClosed {
+		panic(err)
+	}
+}
+
+// REPL runs an interactive session with a recurrent or trnn model
+func REPL(args []string) {
+	set := flag.NewFlagSet("repl", flag.ExitOnError)
+	name := set.String("model", recurrent.Defaults.Output, "recurrent or trnn model file")
+	temperature := set.Float64("temperature", 1, "sampling temperature, 0 picks the most probable symbol")
+	topk := set.Int("topk", 5, "number of next symbol probabilities shown and sampled from, 0 samples from all of them")
+	length := set.Int("length", 32, "number of symbols sampled after each line")
+	seed := set.Int64("seed", 1, "seed of the sampling")
+	set.Parse(args)
+	m, err := lm.Load(*name)
+	if err != nil {
+		panic(err)
+	}
+	r := lm.REPL{
+		Model: m,
+		Sampler: lm.Sampler{
+			Temperature: *temperature,
+			TopK:        *topk,
+		},
+		TopK:   *topk,
+		Length: *length,
+		Rand:   rand.New(rand.NewSource(*seed)),
+		Load:   lm.Load,
+	}
+	fmt.Printf("%s %s, :help lists the commands\n", m.Architecture, *name)
+	err = r.Run(os.Stdin, os.Stdout)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// Visualize renders the weights of a model, the histograms of distributions and the attention of a trnn model
+func Visualize(args []string) {
+	set := flag.NewFlagSet("visualize", flag.ExitOnError)
+	output := set.String("output", "visualize", "directory of the images")
+	name := set.String("model", "", "model file of the weight heatmaps and the attention map")
+	tensors := set.String("tensors", "", "comma separated tensors of the heatmaps, all of them if empty")
+	text := set.String("attention", "", "text of the attention map of a trnn model")
+	set.Usage = func() {
+		fmt.Fprintf(set.Output(), "usage: %s visualize [flags] [distribution]...\n", filepath.Base(os.Args[0]))
+		fmt.Fprintln(set.Output(), "the distributions, such as the -snapshot files, are rendered as histograms")
+		set.PrintDefaults()
+	}
+	set.Parse(args)
+	if *name == "" && set.NArg() == 0 {
+		set.Usage()
+		os.Exit(2)
+	}
+	err := os.MkdirAll(*output, 0755)
+	if err != nil {
+		panic(err)
+	}
+	var paths []string
+	if *name != "" {
+		m, err := model.Load(*name)
+		if err != nil {
+			panic(err)
+		}
+		var names []string
+		if *tensors != "" {
+			names = strings.Split(*tensors, ",")
+		}
+		weights, err := visualize.Weights(m, *output, names)
+		if err != nil {
+			panic(err)
+		}
+		paths = append(paths, weights...)
+		if *text != "" {
+			n, t, err := trnn.NewNetwork(m)
+			if err != nil {
+				panic(err)
+			}
+			path := filepath.Join(*output, "attention.png")
+			err = visualize.AttentionMap(path, n, t, []byte(*text))
+			if err != nil {
+				panic(err)
+			}
+			paths = append(paths, path)
+		}
+	}
+	if set.NArg() > 0 {
+		distributions := make([]*model.Model, set.NArg())
+		labels := make([]string, set.NArg())
+		for i, name := range set.Args() {
+			if distributions[i], err = model.Load(name); err != nil {
+				panic(err)
+			}
+			labels[i] = strings.TrimSuffix(filepath.Base(name), filepath.Ext(name))
+		}
+		histograms, err := visualize.Histograms(*output, labels, distributions)
+		if err != nil {
+			panic(err)
+		}
+		paths = append(paths, histograms...)
+	}
+	for _, path := range paths {
+		fmt.Println(path)
+	}
+}
+
+// Sweep runs the trials of a YAML or JSON search spec as train commands and prints them ranked by loss
+func Sweep(args []string) {
+	set := flag.NewFlagSet("sweep", flag.ExitOnError)
+	results := set.String("results", "results", "directory of the trials and results.json")
+	parallel := set.Int("parallel", 0, "number of trials run at the same time, overrides the spec if set")
+	set.Usage = func() {
+		fmt.Fprintf(set.Output(), "usage: %s sweep [flags] <spec>\n", filepath.Base(os.Args[0]))
+		fmt.Fprintln(set.Output(), "the spec is YAML if it ends in .yaml or .yml and JSON otherwise")
+		set.PrintDefaults()
+	}
+	set.Parse(args)
+	if set.NArg() != 1 {
+		set.Usage()
+		os.Exit(2)
+	}
+	spec, err := sweep.LoadSpec(set.Arg(0))
+	if err != nil {
+		panic(err)
+	}
+	if _, ok := Models[spec.Model]; !ok {
+		panic(fmt.Errorf("unknown model %s", spec.Model))
+	}
+	if *parallel > 0 {
+		spec.Parallel = *parallel
+	}
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	runner := sweep.Runner{
+		Spec:      spec,
+		Directory: *results,
+		Progress:  os.Stdout,
+	}
+	trials, err := runner.Run(ctx)
+	if len(trials) > 0 {
+		if err := sweep.Table(os.Stdout, spec, trials); err != nil {
+			panic(err)
+		}
+	}
+	if err != nil {
+		panic(err)
+	}
+}
+
+// Worker serves the evaluation of trnn networks for the -remote flag of trnn training until it is interrupted
+func Worker(args []string) {
+	set := flag.NewFlagSet("worker", flag.ExitOnError)
+	addr := set.String("addr", "localhost:9000", "address to listen on")
+	workers := set.Int("workers", 0, "number of networks evaluated in parallel, 0 for the number of CPUs")
+	set.Parse(args)
+	listener, err := net.Listen("tcp", *addr)
+	if err != nil {
+		panic(err)
+	}
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	fmt.Println("evaluating trnn networks on", listener.Addr())
+	err = trnn.ServeWorker(ctx, listener, *workers)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// Names returns the sorted names of the models
+func Names() []string {
+	names := make([]string, 0, len(Models))
+	for name := range Models {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Usage prints the usage of the command line
+func Usage() {
+	name := filepath.Base(os.Args[0])
+	fmt.Fprintf(os.Stderr, "usage: %s <command> <model> [flags] [inputs]\n", name)
+	fmt.Fprintf(os.Stderr, "       %s plot [flags] <log>...\n", name)
+	fmt.Fprintf(os.Stderr, "       %s serve [flags]\n", name)
+	fmt.Fprintf(os.Stderr, "       %s repl [flags]\n", name)
+	fmt.Fprintf(os.Stderr, "       %s visualize [flags] [distribution]...\n", name)
+	fmt.Fprintf(os.Stderr, "       %s sweep [flags] <spec>\n", name)
+	fmt.Fprintf(os.Stderr, "       %s worker [flags]\n", name)
+	fmt.Fprintf(os.Stderr, "commands: %s\n", strings.Join(Commands, ", "))
+	fmt.Fprintf(os.Stderr, "models: %s\n", strings.Join(Names(), ", "))
+	fmt.Fprintf(os.Stderr, "run %s <command> <model> -h for the flags\n", name)
+}
+
+func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "plot":
+			Plot(os.Args[2:])
+			return
+		case "serve":
+			Serve(os.Args[2:])
+			return
+		case "repl":
+			REPL(os.Args[2:])
+			return
+		case "visualize":
+			Visualize(os.Args[2:])
+			return
+		case "sweep":
+			Sweep(os.Args[2:])
+			return
+		case "worker":
+			Worker(os.Args[2:])
+			return
+		}
+	}
+	if len(os.Args) < 3 {
+		Usage()
+		os.Exit(2)
+	}
+	command, name := os.Args[1], os.Args[2]
+	m, ok := Models[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown model %s\n", name)
+		Usage()
+		os.Exit(2)
+	}
+	run := m.Run(command)
+	if run == nil {
+		fmt.Fprintf(os.Stderr, "%s does not support %s\n", name, command)
+		Usage()
+		os.Exit(2)
 	}
-	f32.Factor(vars, true)
+	f := NewFlags(command, name, m)
+	f.Set.Parse(os.Args[3:])
+	f.Options = f.Options.Merge(m.Defaults)
+	ctx, cancel := train.Interrupt(context.Background())
+	defer cancel()
+	f.Context = ctx
+	run(f)
 }
@@ -5,8 +5,13 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"math"
 	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/pointlander/rnn/discrete"
@@ -14,6 +19,8 @@ import (
 	"github.com/pointlander/rnn/matrix/f64"
 	"github.com/pointlander/rnn/matrix/i8"
 	"github.com/pointlander/rnn/matrix/u64"
+	"github.com/pointlander/rnn/model"
+	"github.com/pointlander/rnn/quanta"
 )
 
 const program = `
@@ -159,3 +166,135 @@ func BenchmarkUint64(b *testing.B) {
 		u64.Layer(layer, input, bias)
 	}
 }
+
+func BenchmarkOneHot(b *testing.B) {
+	rng := rand.New(rand.NewSource(1))
+	layer := f32.NewMatrix(0, 256, 256)
+	for i := 0; i < 256*256; i++ {
+		layer.Data = append(layer.Data, float32(rng.NormFloat64()))
+	}
+	input := f32.NewMatrix(0, 256, 1)
+	input.Data = input.Data[:256]
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for j := range input.Data {
+			input.Data[j] = 0
+		}
+		input.Data[i%256] = 1
+		f32.MulT(layer, input)
+	}
+}
+
+func BenchmarkEmbedding(b *testing.B) {
+	rng := rand.New(rand.NewSource(1))
+	embedding := f32.NewEmbedding(0, 256, 256)
+	for i := 0; i < 256*256; i++ {
+		embedding.Data = append(embedding.Data, float32(rng.NormFloat64()))
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		embedding.Lookup(i % 256)
+	}
+}
+
+func BenchmarkGather(b *testing.B) {
+	rng := rand.New(rand.NewSource(1))
+	embedding := f32.NewEmbedding(0, 256, 256)
+	for i := 0; i < 256*256; i++ {
+		embedding.Data = append(embedding.Data, float32(rng.NormFloat64()))
+	}
+	symbols := make([]int, 1024)
+	for i := range symbols {
+		symbols[i] = rng.Intn(256)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		embedding.Gather(symbols)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	directory := t.TempDir()
+	data := filepath.Join(directory, "data.csv")
+	var csv strings.Builder
+	csv.WriteString("x,y,label\n")
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 32; i++ {
+		label := i % 2
+		fmt.Fprintf(&csv, "%f,%f,%d\n", float64(label)+rng.Float64()/2, rng.Float64(), label)
+	}
+	if err := os.WriteFile(data, []byte(csv.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+	m := Models["mlp"]
+	f := NewFlags("verify", "mlp", m)
+	err := f.Set.Parse([]string{"-data=" + data, "-population=16", "-generations=3", "-window=4", "-workers=3",
+		"-layers=4", "-output=" + filepath.Join(directory, "mlp.model")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Options = f.Options.Merge(m.Defaults)
+	f.Context = context.Background()
+	Verify(m.Train)(f)
+
+	// the models of the two runs are saved at different times
+	run := 0
+	created := func(f *Flags) {
+		m.Train(f)
+		a, err := model.Load(f.Output)
+		if err != nil {
+			panic(err)
+		}
+		run++
+		a.Metadata["created"] = fmt.Sprint(run)
+		if err := model.Save(f.Output, a); err != nil {
+			panic(err)
+		}
+	}
+	Verify(created)(f)
+
+	changed := func(f *Flags) {
+		created(f)
+		a, err := model.Load(f.Output)
+		if err != nil {
+			panic(err)
+		}
+		a.Tensors[0].Data[0] ^= byte(run)
+		if err := model.Save(f.Output, a); err != nil {
+			panic(err)
+		}
+	}
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected the runs to differ")
+		}
+	}()
+	Verify(changed)(f)
+}
+
+func TestQuantaConfig(t *testing.T) {
+	for _, test := range []struct {
+		args       []string
+		width      int
+		iterations int
+		valid      bool
+	}{
+		{nil, quanta.DefaultConfig.Width, quanta.DefaultConfig.Iterations, true},
+		{[]string{"-width=128", "-iterations=0"}, 128, 0, true},
+		{[]string{"-width=100"}, 0, 0, false},
+		{[]string{"-width=0"}, 0, 0, false},
+		{[]string{"-iterations=-1"}, 0, 0, false},
+	} {
+		f := NewFlags("bench", "quanta", Models["quanta"])
+		if err := f.Set.Parse(test.args); err != nil {
+			t.Fatal(err)
+		}
+		config, err := f.QuantaConfig(quanta.DefaultConfig)
+		if (err == nil) != test.valid {
+			t.Fatalf("%v: %v", test.args, err)
+		}
+		if test.valid && (config.Width != test.width || config.Iterations != test.iterations) {
+			t.Fatalf("%v: %+v", test.args, config)
+		}
+	}
+}
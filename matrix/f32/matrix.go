@@ -59,6 +59,40 @@ func (m Matrix) Size() int {
 	return m.Cols * m.Rows
 }
 
+// Embedding is a lookup table with one row of Cols values per symbol
+type Embedding struct {
+	Matrix
+}
+
+// NewEmbedding creates a new embedding of width values for each of symbols
+func NewEmbedding(states, width, symbols int) Embedding {
+	return Embedding{
+		Matrix: NewMatrix(states, width, symbols),
+	}
+}
+
+// Lookup returns the embedding of a symbol, the result shares memory with the table
+func (e Embedding) Lookup(symbol int) Matrix {
+	return Matrix{
+		Cols: e.Cols,
+		Rows: 1,
+		Data: e.Data[symbol*e.Cols : (symbol+1)*e.Cols],
+	}
+}
+
+// Gather copies the embeddings of symbols into a matrix with one row per symbol
+func (e Embedding) Gather(symbols []int) Matrix {
+	o := Matrix{
+		Cols: e.Cols,
+		Rows: len(symbols),
+		Data: make([]float32, 0, e.Cols*len(symbols)),
+	}
+	for _, symbol := range symbols {
+		o.Data = append(o.Data, e.Data[symbol*e.Cols:(symbol+1)*e.Cols]...)
+	}
+	return o
+}
+
 // MulT multiplies two matrices and computes the transpose
 func MulT(m Matrix, n Matrix) Matrix {
 	if m.Cols != n.Cols {
@@ -190,9 +224,9 @@ func softmax(values []float32) {
 // SelfAttention computes the self attention of Q, K, V
 func SelfAttention(Q, K, V Matrix) Matrix {
 	o := Matrix{
-		Cols: V.Rows,
+		Cols: V.Cols,
 		Rows: K.Rows,
-		Data: make([]float32, 0, V.Rows*K.Rows),
+		Data: make([]float32, 0, V.Cols*K.Rows),
 	}
 	outputs, values := make([]float32, V.Cols), make([]float32, Q.Rows)
 	V = T(V)
@@ -214,6 +248,27 @@ func SelfAttention(Q, K, V Matrix) Matrix {
 	return o
 }
 
+// Attention computes the softmax attention weights of the rows of K over the rows of Q,
+// they are the weights SelfAttention averages the values with
+func Attention(Q, K Matrix) Matrix {
+	o := Matrix{
+		Cols: Q.Rows,
+		Rows: K.Rows,
+		Data: make([]float32, 0, Q.Rows*K.Rows),
+	}
+	for i := 0; i < K.Rows; i++ {
+		K := K.Data[i*K.Cols : (i+1)*K.Cols]
+		values := make([]float32, Q.Rows)
+		for j := 0; j < Q.Rows; j++ {
+			Q := Q.Data[j*Q.Cols : (j+1)*Q.Cols]
+			values[j] = dot(K, Q)
+		}
+		softmax(values)
+		o.Data = append(o.Data, values...)
+	}
+	return o
+}
+
 // EverettActivation is the everett complex activation function
 func EverettActivation(m Matrix) Matrix {
 	o := Matrix{
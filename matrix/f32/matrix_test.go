@@ -0,0 +1,36 @@
+// Copyright 2023 The RNN Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package f32
+
+import (
+	"math"
+	"testing"
+)
+
+func TestSelfAttention(t *testing.T) {
+	q, k, v := NewMatrix(0, 2, 4), NewMatrix(0, 2, 3), NewMatrix(0, 5, 4)
+	for i := 0; i < 4*2; i++ {
+		q.Data = append(q.Data, float32(i%3)-1)
+	}
+	for i := 0; i < 3*2; i++ {
+		k.Data = append(k.Data, float32(i%4)/4)
+	}
+	for i := 0; i < 4*5; i++ {
+		v.Data = append(v.Data, float32(i)/8)
+	}
+	o := SelfAttention(q, k, v)
+	if o.Cols != v.Cols || o.Rows != k.Rows || len(o.Data) != o.Cols*o.Rows {
+		t.Fatalf("self attention of %d values is %dx%d with %d values", v.Cols, o.Cols, o.Rows, len(o.Data))
+	}
+	for i := 0; i < o.Rows; i++ {
+		sum := float32(0)
+		for _, value := range o.Data[i*o.Cols : (i+1)*o.Cols] {
+			sum += value
+		}
+		if math.Abs(float64(sum-1)) > 1e-5 {
+			t.Fatalf("row %d sums to %f", i, sum)
+		}
+	}
+}
@@ -4,7 +4,10 @@
 
 package u64
 
-import "fmt"
+import (
+	"fmt"
+	"math/bits"
+)
 
 // Matrix is a matrix
 type Matrix struct {
@@ -31,6 +34,30 @@ func (m Matrix) Size() int {
 	return m.Cols * m.Rows
 }
 
+// Pack packs the signs of values into a one row matrix, setting the bits of positive values
+func Pack(values []float32) Matrix {
+	m := NewMatrix(len(values), 1)
+	m.Data = m.Data[:len(values)/64]
+	for i, value := range values {
+		if value > 0 {
+			m.Data[i/64] |= 1 << (i % 64)
+		}
+	}
+	return m
+}
+
+// Hamming is the number of bits that differ between two matrices
+func Hamming(m Matrix, n Matrix) int {
+	if m.Cols != n.Cols || m.Rows != n.Rows {
+		panic(fmt.Errorf("%dx%d != %dx%d", m.Cols, m.Rows, n.Cols, n.Rows))
+	}
+	distance := 0
+	for i, x := range m.Data {
+		distance += bits.OnesCount64(x ^ n.Data[i])
+	}
+	return distance
+}
+
 func dot(X, Y []uint64) int {
 	sum := 0
 	for i, x := range X {
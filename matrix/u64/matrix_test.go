@@ -0,0 +1,54 @@
+// Copyright 2023 The RNN Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package u64
+
+import (
+	"testing"
+)
+
+func TestPack(t *testing.T) {
+	values := make([]float32, 128)
+	for i := range values {
+		values[i] = -1
+	}
+	values[0], values[63], values[64], values[127] = 1, .5, 2, 0
+	m := Pack(values)
+	if m.Cols != 128 || m.Rows != 1 || len(m.Data) != 2 {
+		t.Fatalf("packed matrix is %dx%d with %d words", m.Cols, m.Rows, len(m.Data))
+	}
+	if m.Data[0] != 1|1<<63 || m.Data[1] != 1 {
+		t.Fatalf("packed bits are %x %x", m.Data[0], m.Data[1])
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected a panic for a width that is not a multiple of 64")
+		}
+	}()
+	Pack(make([]float32, 100))
+}
+
+func TestHamming(t *testing.T) {
+	values := make([]float32, 128)
+	a := Pack(values)
+	if distance := Hamming(a, a); distance != 0 {
+		t.Fatalf("distance to itself is %d", distance)
+	}
+	values[3], values[70], values[127] = 1, 1, 1
+	b := Pack(values)
+	if distance := Hamming(a, b); distance != 3 {
+		t.Fatalf("distance is %d not 3", distance)
+	}
+	if Hamming(a, b) != Hamming(b, a) {
+		t.Fatal("distance is not symmetric")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected a panic for matrices of different sizes")
+		}
+	}()
+	Hamming(a, Pack(make([]float32, 64)))
+}
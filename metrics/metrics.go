@@ -0,0 +1,229 @@
+// Copyright 2023 The RNN Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package metrics implements classification metrics and data splits for evaluation
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"strings"
+)
+
+// Confusion is a confusion matrix, Counts[actual][predicted] is the number of
+// samples of class actual that were predicted as class predicted
+type Confusion struct {
+	Classes []string
+	Counts  [][]int
+}
+
+// NewConfusion creates a confusion matrix for the classes
+func NewConfusion(classes []string) *Confusion {
+	counts := make([][]int, len(classes))
+	for i := range counts {
+		counts[i] = make([]int, len(classes))
+	}
+	return &Confusion{
+		Classes: classes,
+		Counts:  counts,
+	}
+}
+
+// Add adds a prediction to the confusion matrix
+func (c *Confusion) Add(actual, predicted int) {
+	c.Counts[actual][predicted]++
+}
+
+// Merge adds the counts of another confusion matrix with the same classes
+func (c *Confusion) Merge(other *Confusion) {
+	for i, row := range other.Counts {
+		for j, count := range row {
+			c.Counts[i][j] += count
+		}
+	}
+}
+
+// Total is the number of predictions
+func (c *Confusion) Total() int {
+	total := 0
+	for _, row := range c.Counts {
+		for _, count := range row {
+			total += count
+		}
+	}
+	return total
+}
+
+// Accuracy is the fraction of correct predictions
+func (c *Confusion) Accuracy() float64 {
+	total, correct := c.Total(), 0
+	if total == 0 {
+		return 0
+	}
+	for i := range c.Counts {
+		correct += c.Counts[i][i]
+	}
+	return float64(correct) / float64(total)
+}
+
+// Precision is the fraction of the predictions of a class that are correct
+func (c *Confusion) Precision(class int) float64 {
+	predicted := 0
+	for i := range c.Counts {
+		predicted += c.Counts[i][class]
+	}
+	if predicted == 0 {
+		return 0
+	}
+	return float64(c.Counts[class][class]) / float64(predicted)
+}
+
+// Recall is the fraction of the samples of a class that are predicted correctly
+func (c *Confusion) Recall(class int) float64 {
+	actual := 0
+	for _, count := range c.Counts[class] {
+		actual += count
+	}
+	if actual == 0 {
+		return 0
+	}
+	return float64(c.Counts[class][class]) / float64(actual)
+}
+
+// F1 is the harmonic mean of the precision and recall of a class
+func (c *Confusion) F1(class int) float64 {
+	precision, recall := c.Precision(class), c.Recall(class)
+	if precision+recall == 0 {
+		return 0
+	}
+	return 2 * precision * recall / (precision + recall)
+}
+
+// MacroF1 is the mean F1 score of the classes
+func (c *Confusion) MacroF1() float64 {
+	if len(c.Classes) == 0 {
+		return 0
+	}
+	sum := 0.0
+	for i := range c.Classes {
+		sum += c.F1(i)
+	}
+	return sum / float64(len(c.Classes))
+}
+
+// Report writes the confusion matrix and the per class metrics
+func (c *Confusion) Report(w io.Writer) {
+	width := len("actual")
+	for _, class := range c.Classes {
+		if len(class) > width {
+			width = len(class)
+		}
+	}
+	fmt.Fprintf(w, "%-*s", width, "actual")
+	for _, class := range c.Classes {
+		fmt.Fprintf(w, " %*s", width, class)
+	}
+	fmt.Fprintln(w)
+	for i, row := range c.Counts {
+		fmt.Fprintf(w, "%-*s", width, c.Classes[i])
+		for _, count := range row {
+			fmt.Fprintf(w, " %*d", width, count)
+		}
+		fmt.Fprintln(w)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "%-*s %9s %9s %9s %7s\n", width, "class", "precision", "recall", "f1", "support")
+	for i, class := range c.Classes {
+		support := 0
+		for _, count := range c.Counts[i] {
+			support += count
+		}
+		fmt.Fprintf(w, "%-*s %9.4f %9.4f %9.4f %7d\n", width, class, c.Precision(i), c.Recall(i), c.F1(i), support)
+	}
+	fmt.Fprintf(w, "accuracy %.4f macro f1 %.4f total %d\n", c.Accuracy(), c.MacroF1(), c.Total())
+}
+
+// String is the report of the confusion matrix
+func (c *Confusion) String() string {
+	var report strings.Builder
+	c.Report(&report)
+	return report.String()
+}
+
+// byClass groups the indexes of the labels by class in a random order
+func byClass(labels []int, rng *rand.Rand) [][]int {
+	classes := 0
+	for _, label := range labels {
+		if label+1 > classes {
+			classes = label + 1
+		}
+	}
+	groups := make([][]int, classes)
+	for i, label := range labels {
+		groups[label] = append(groups[label], i)
+	}
+	for _, group := range groups {
+		rng.Shuffle(len(group), func(i, j int) {
+			group[i], group[j] = group[j], group[i]
+		})
+	}
+	return groups
+}
+
+// StratifiedKFold splits the indexes of the labels into k folds with the same class proportions,
+// every class needs two indexes so that it keeps a training index when a fold is held out
+func StratifiedKFold(labels []int, k int, rng *rand.Rand) ([][]int, error) {
+	if k < 2 || k > len(labels) {
+		return nil, fmt.Errorf("%d folds is not in [2, %d]", k, len(labels))
+	}
+	groups := byClass(labels, rng)
+	for class, group := range groups {
+		if len(group) == 1 {
+			return nil, fmt.Errorf("class %d has one index, it needs two for cross validation", class)
+		}
+	}
+	folds := make([][]int, k)
+	fold := 0
+	for _, group := range groups {
+		// classes continue where the previous class stopped so the fold sizes stay balanced
+		for _, index := range group {
+			folds[fold] = append(folds[fold], index)
+			fold = (fold + 1) % k
+		}
+	}
+	return folds, nil
+}
+
+// Complement returns the indexes in [0, n) that are not in the fold
+func Complement(n int, fold []int) []int {
+	in := make([]bool, n)
+	for _, index := range fold {
+		in[index] = true
+	}
+	rest := make([]int, 0, n-len(fold))
+	for i := 0; i < n; i++ {
+		if !in[i] {
+			rest = append(rest, i)
+		}
+	}
+	return rest
+}
+
+// Split splits the indexes of the labels into a training set and a held out test set
+// containing the fraction test of each class, every class keeps at least one training index
+func Split(labels []int, test float64, rng *rand.Rand) (train, held []int, err error) {
+	if !(test > 0 && test < 1) {
+		return nil, nil, fmt.Errorf("held out fraction %g is not in (0, 1)", test)
+	}
+	for class, group := range byClass(labels, rng) {
+		n := int(test*float64(len(group)) + .5)
+		if len(group) > 0 && n >= len(group) {
+			return nil, nil, fmt.Errorf("holding out %g of class %d leaves no training indexes", test, class)
+		}
+		held = append(held, group[:n]...)
+		train = append(train, group[n:]...)
+	}
+	return train, held, nil
+}
@@ -0,0 +1,81 @@
+// Copyright 2023 The RNN Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package metrics
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestConfusion(t *testing.T) {
+	c := NewConfusion([]string{"a", "b"})
+	for _, prediction := range [][2]int{{0, 0}, {0, 0}, {0, 1}, {1, 1}} {
+		c.Add(prediction[0], prediction[1])
+	}
+	if c.Accuracy() != .75 || c.Precision(1) != .5 || c.Recall(0) != 2.0/3 {
+		t.Fatalf("accuracy %f precision %f recall %f", c.Accuracy(), c.Precision(1), c.Recall(0))
+	}
+	if f1 := c.F1(0); math.Abs(f1-.8) > 1e-9 {
+		t.Fatalf("f1 is %f", f1)
+	}
+}
+
+func TestSplits(t *testing.T) {
+	labels := make([]int, 30)
+	for i := range labels {
+		labels[i] = i % 3
+	}
+	folds, err := StratifiedKFold(labels, 5, rand.New(rand.NewSource(1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := make(map[int]bool)
+	for _, fold := range folds {
+		counts := make([]int, 3)
+		for _, index := range fold {
+			if seen[index] {
+				t.Fatalf("index %d is in two folds", index)
+			}
+			seen[index] = true
+			counts[labels[index]]++
+		}
+		if len(fold) != 6 || counts[0] != 2 || counts[1] != 2 || counts[2] != 2 {
+			t.Fatalf("fold %v is not stratified", fold)
+		}
+		if rest := Complement(len(labels), fold); len(rest) != 24 {
+			t.Fatalf("complement has %d indexes", len(rest))
+		}
+	}
+
+	train, test, err := Split(labels, .2, rand.New(rand.NewSource(1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(train) != 24 || len(test) != 6 {
+		t.Fatalf("split is %d %d", len(train), len(test))
+	}
+
+	for _, test := range []float64{-.5, 0, .97, 1, 1.5} {
+		if _, _, err := Split(labels, test, rand.New(rand.NewSource(1))); err == nil {
+			t.Fatalf("expected an error for the held out fraction %g", test)
+		}
+	}
+	for _, k := range []int{-1, 0, 1, 31} {
+		if _, err := StratifiedKFold(labels, k, rand.New(rand.NewSource(1))); err == nil {
+			t.Fatalf("expected an error for %d folds", k)
+		}
+	}
+	if _, err := StratifiedKFold(append(labels, 3), 5, rand.New(rand.NewSource(1))); err == nil {
+		t.Fatal("expected an error for a class with one index")
+	}
+}
+
+func TestRegression(t *testing.T) {
+	r := NewRegression([]float64{1, 2, 3, 4}, []float64{1, 2, 3, 5})
+	if r.MSE != .25 || r.MAE != .25 || math.Abs(r.R2-.8) > 1e-9 {
+		t.Fatalf("regression %+v", r)
+	}
+}
@@ -0,0 +1,55 @@
+// Copyright 2023 The RNN Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package metrics
+
+import (
+	"fmt"
+	"math"
+)
+
+// Regression is the error of regression predictions
+type Regression struct {
+	N    int
+	MSE  float64
+	RMSE float64
+	MAE  float64
+	// R2 is the coefficient of determination, the fraction of the variance of the targets that is explained
+	R2 float64
+}
+
+// NewRegression computes the error of the predicted values against the actual values
+func NewRegression(actual, predicted []float64) Regression {
+	r := Regression{
+		N: len(actual),
+	}
+	if r.N == 0 {
+		return r
+	}
+	mean := 0.0
+	for _, value := range actual {
+		mean += value
+	}
+	mean /= float64(r.N)
+	residual, total := 0.0, 0.0
+	for i, value := range actual {
+		diff := value - predicted[i]
+		residual += diff * diff
+		r.MAE += math.Abs(diff)
+		deviation := value - mean
+		total += deviation * deviation
+	}
+	r.MSE = residual / float64(r.N)
+	r.RMSE = math.Sqrt(r.MSE)
+	r.MAE /= float64(r.N)
+	if total > 0 {
+		r.R2 = 1 - residual/total
+	}
+	return r
+}
+
+// String is the report of the regression error
+func (r Regression) String() string {
+	return fmt.Sprintf("r2 %.4f mse %.4f rmse %.4f mae %.4f total %d\n", r.R2, r.MSE, r.RMSE, r.MAE, r.N)
+}
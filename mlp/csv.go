@@ -0,0 +1,325 @@
+// Copyright 2023 The RNN Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mlp
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pointlander/rnn/corpus"
+)
+
+// Dataset is a table of numeric features and class labels or regression targets
+type Dataset struct {
+	Columns []string
+	Classes []string
+	Inputs  [][]float32
+	Labels  []int
+	Targets []float32
+}
+
+// LoadCSV loads a dataset from a CSV file, optionally gzipped
+// The label is the name or index of the label column, negative indexes count from the end
+func LoadCSV(name, label string, header bool) (Dataset, error) {
+	data, err := corpus.Load(name)
+	if err != nil {
+		return Dataset{}, err
+	}
+	return ParseCSV(data, label, header)
+}
+
+// ParseCSV parses a dataset from CSV data
+func ParseCSV(data []byte, label string, header bool) (Dataset, error) {
+	d, labels, err := parseCSV(data, label, header)
+	if err != nil {
+		return d, err
+	}
+	seen := make(map[string]bool)
+	for _, class := range labels {
+		if !seen[class] {
+			seen[class] = true
+			d.Classes = append(d.Classes, class)
+		}
+	}
+	sort.Strings(d.Classes)
+	return d, d.SetClasses(d.Classes, labels)
+}
+
+// LoadRegressionCSV loads a dataset with a numeric target column from a CSV file, optionally gzipped
+func LoadRegressionCSV(name, target string, header bool) (Dataset, error) {
+	data, err := corpus.Load(name)
+	if err != nil {
+		return Dataset{}, err
+	}
+	return ParseRegressionCSV(data, target, header)
+}
+
+// ParseRegressionCSV parses a dataset with a numeric target column from CSV data
+func ParseRegressionCSV(data []byte, target string, header bool) (Dataset, error) {
+	d, targets, err := parseCSV(data, target, header)
+	if err != nil {
+		return d, err
+	}
+	d.Targets = make([]float32, len(targets))
+	for i, field := range targets {
+		value, err := strconv.ParseFloat(field, 64)
+		if err != nil {
+			return d, fmt.Errorf("record %d target: %w", i+1, err)
+		}
+		d.Targets[i] = float32(value)
+	}
+	return d, nil
+}
+
+// parseCSV parses the numeric features and the label column of CSV data
+func parseCSV(data []byte, label string, header bool) (Dataset, []string, error) {
+	var d Dataset
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.TrimLeadingSpace = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		return d, nil, err
+	}
+	if len(records) == 0 {
+		return d, nil, fmt.Errorf("no records")
+	}
+	width := len(records[0])
+	names := make([]string, width)
+	for i := range names {
+		names[i] = strconv.Itoa(i)
+	}
+	if header {
+		names, records = records[0], records[1:]
+	}
+
+	column := -1
+	if index, err := strconv.Atoi(label); err == nil {
+		column = index
+		if column < 0 {
+			column += width
+		}
+	} else {
+		for i, name := range names {
+			if name == label {
+				column = i
+			}
+		}
+	}
+	if column < 0 || column >= width {
+		return d, nil, fmt.Errorf("no label column %s", label)
+	}
+	for i, name := range names {
+		if i != column {
+			d.Columns = append(d.Columns, name)
+		}
+	}
+
+	labels := make([]string, 0, len(records))
+	for i, record := range records {
+		input := make([]float32, 0, width-1)
+		for j, field := range record {
+			if j == column {
+				continue
+			}
+			value, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+			if err != nil {
+				return d, nil, fmt.Errorf("record %d column %s: %w", i+1, names[j], err)
+			}
+			input = append(input, float32(value))
+		}
+		labels = append(labels, strings.TrimSpace(record[column]))
+		d.Inputs = append(d.Inputs, input)
+	}
+	return d, labels, nil
+}
+
+// SetClasses sets the classes and maps the labels to their indexes
+func (d *Dataset) SetClasses(classes []string, labels []string) error {
+	indexes := make(map[string]int, len(classes))
+	for i, class := range classes {
+		indexes[class] = i
+	}
+	d.Labels = make([]int, len(labels))
+	for i, label := range labels {
+		index, ok := indexes[label]
+		if !ok {
+			return fmt.Errorf("unknown class %s", label)
+		}
+		d.Labels[i] = index
+	}
+	d.Classes = classes
+	return nil
+}
+
+// Names returns the class names of the labels
+func (d *Dataset) Names() []string {
+	names := make([]string, len(d.Labels))
+	for i, label := range d.Labels {
+		names[i] = d.Classes[label]
+	}
+	return names
+}
+
+// Normalization is a method of normalizing the features
+type Normalization string
+
+const (
+	// None leaves the features as they are
+	None Normalization = "none"
+	// MinMax scales each feature to the range [0, 1]
+	MinMax Normalization = "minmax"
+	// ZScore scales each feature to zero mean and unit variance
+	ZScore Normalization = "zscore"
+	// Unit scales each row to a unit vector
+	Unit Normalization = "unit"
+)
+
+// Scaler normalizes features with statistics computed on training data
+type Scaler struct {
+	Method Normalization
+	Offset []float32
+	Scale  []float32
+}
+
+// NewScaler computes the statistics of a normalization method on a dataset
+func NewScaler(method Normalization, d Dataset) (Scaler, error) {
+	s := Scaler{
+		Method: method,
+	}
+	if len(d.Inputs) == 0 {
+		return s, nil
+	}
+	width := len(d.Inputs[0])
+	switch method {
+	case None, Unit:
+		return s, nil
+	case MinMax:
+		s.Offset, s.Scale = make([]float32, width), make([]float32, width)
+		for i := 0; i < width; i++ {
+			min, max := float32(math.MaxFloat32), float32(-math.MaxFloat32)
+			for _, input := range d.Inputs {
+				if input[i] < min {
+					min = input[i]
+				}
+				if input[i] > max {
+					max = input[i]
+				}
+			}
+			s.Offset[i], s.Scale[i] = min, max-min
+		}
+	case ZScore:
+		s.Offset, s.Scale = make([]float32, width), make([]float32, width)
+		for i := 0; i < width; i++ {
+			mean := 0.0
+			for _, input := range d.Inputs {
+				mean += float64(input[i])
+			}
+			mean /= float64(len(d.Inputs))
+			variance := 0.0
+			for _, input := range d.Inputs {
+				diff := float64(input[i]) - mean
+				variance += diff * diff
+			}
+			variance /= float64(len(d.Inputs))
+			s.Offset[i], s.Scale[i] = float32(mean), float32(math.Sqrt(variance))
+		}
+	default:
+		return s, fmt.Errorf("unknown normalization %s", method)
+	}
+	for i, scale := range s.Scale {
+		if scale == 0 {
+			s.Scale[i] = 1
+		}
+	}
+	return s, nil
+}
+
+// Apply normalizes an input
+func (s Scaler) Apply(input []float32) []float32 {
+	output := make([]float32, len(input))
+	switch s.Method {
+	case MinMax, ZScore:
+		for i, value := range input {
+			output[i] = (value - s.Offset[i]) / s.Scale[i]
+		}
+	case Unit:
+		sum := float32(0.0)
+		for _, value := range input {
+			sum += value * value
+		}
+		length := float32(math.Sqrt(float64(sum)))
+		if length == 0 {
+			length = 1
+		}
+		for i, value := range input {
+			output[i] = value / length
+		}
+	default:
+		copy(output, input)
+	}
+	return output
+}
+
+// Normalize normalizes the inputs of a dataset
+func (s Scaler) Normalize(d Dataset) Dataset {
+	normalized := d
+	normalized.Inputs = make([][]float32, len(d.Inputs))
+	for i, input := range d.Inputs {
+		normalized.Inputs[i] = s.Apply(input)
+	}
+	return normalized
+}
+
+// Standardizer standardizes regression targets to zero mean and unit variance
+type Standardizer struct {
+	Mean   float32
+	Stddev float32
+}
+
+// NewStandardizer computes the mean and standard deviation of the targets
+func NewStandardizer(targets []float32) Standardizer {
+	s := Standardizer{
+		Stddev: 1,
+	}
+	if len(targets) == 0 {
+		return s
+	}
+	mean := 0.0
+	for _, target := range targets {
+		mean += float64(target)
+	}
+	mean /= float64(len(targets))
+	variance := 0.0
+	for _, target := range targets {
+		diff := float64(target) - mean
+		variance += diff * diff
+	}
+	variance /= float64(len(targets))
+	s.Mean = float32(mean)
+	if variance > 0 {
+		s.Stddev = float32(math.Sqrt(variance))
+	}
+	return s
+}
+
+// Standardize standardizes the targets of a dataset
+func (s Standardizer) Standardize(d Dataset) Dataset {
+	standardized := d
+	standardized.Targets = make([]float32, len(d.Targets))
+	for i, target := range d.Targets {
+		standardized.Targets[i] = (target - s.Mean) / s.Stddev
+	}
+	return standardized
+}
+
+// Restore converts a standardized value back to the scale of the targets
+func (s Standardizer) Restore(value float32) float32 {
+	return value*s.Stddev + s.Mean
+}
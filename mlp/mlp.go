@@ -0,0 +1,615 @@
+// Copyright 2023 The RNN Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package mlp implements a multi-layer perceptron with configurable layers
+// and activations that is learned with the distribution search
+package mlp
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+
+	. "github.com/pointlander/rnn/matrix/f32"
+	"github.com/pointlander/rnn/metrics"
+	"github.com/pointlander/rnn/train"
+)
+
+// Activation is the activation function of a layer
+type Activation string
+
+const (
+	// ActivationStep is the step function
+	ActivationStep Activation = "step"
+	// ActivationSigmoid is the sigmoid function
+	ActivationSigmoid Activation = "sigmoid"
+	// ActivationEverett is the everett activation, it doubles the width of the layer
+	ActivationEverett Activation = "everett"
+	// ActivationSoftmax is the taylor softmax
+	ActivationSoftmax Activation = "softmax"
+	// ActivationLinear is the identity, for regression outputs
+	ActivationLinear Activation = "linear"
+)
+
+// Apply applies the activation function
+func (a Activation) Apply(m Matrix) Matrix {
+	switch a {
+	case ActivationStep:
+		return Step(m)
+	case ActivationSigmoid:
+		return Sigmoid(m)
+	case ActivationEverett:
+		return EverettActivation(m)
+	case ActivationSoftmax:
+		return TaylorSoftmax(m)
+	case ActivationLinear:
+		return m
+	}
+	panic(fmt.Errorf("unknown activation %s", a))
+}
+
+// Width is the width of the output of the activation for a layer of width units
+func (a Activation) Width(width int) int {
+	if a == ActivationEverett {
+		return 2 * width
+	}
+	return width
+}
+
+// ParseActivations parses a comma separated list of activations
+func ParseActivations(list string) ([]Activation, error) {
+	var activations []Activation
+	for _, name := range strings.Split(list, ",") {
+		a := Activation(strings.TrimSpace(name))
+		switch a {
+		case ActivationStep, ActivationSigmoid, ActivationEverett, ActivationSoftmax, ActivationLinear:
+		default:
+			return nil, fmt.Errorf("unknown activation %s", name)
+		}
+		activations = append(activations, a)
+	}
+	return activations, nil
+}
+
+// Cost is the cost function of the regression error
+type Cost string
+
+const (
+	// CostMSE is the squared error
+	CostMSE Cost = "mse"
+	// CostMAE is the absolute error
+	CostMAE Cost = "mae"
+	// CostHuber is the huber loss with a delta of 1, quadratic for small errors and linear for large ones
+	CostHuber Cost = "huber"
+)
+
+// Of computes the cost of an error
+func (c Cost) Of(diff float64) float64 {
+	switch c {
+	case CostMAE:
+		return math.Abs(diff)
+	case CostHuber:
+		if a := math.Abs(diff); a > 1 {
+			return a - .5
+		}
+		return diff * diff / 2
+	case CostMSE, "":
+		return diff * diff
+	}
+	panic(fmt.Errorf("unknown cost %s", c))
+}
+
+// ParseCost parses the name of a cost function
+func ParseCost(name string) (Cost, error) {
+	switch c := Cost(name); c {
+	case CostMSE, CostMAE, CostHuber:
+		return c, nil
+	}
+	return "", fmt.Errorf("unknown cost %s", name)
+}
+
+// Config is the configuration of the network and the search
+type Config struct {
+	// Hidden is the width of each hidden layer
+	Hidden []int
+	// Activations is the activation of each hidden layer followed by the output activation
+	Activations []Activation
+	// Population is the number of networks sampled per generation
+	Population int
+	// Generations is the number of generations
+	Generations int
+	// Window is the number of networks the distribution is estimated from
+	Window int
+	// Batch is the number of rows each network is scored on per generation, 0 is all of them
+	Batch int
+	// Seed is the random seed
+	Seed int64
+	// Workers is the number of networks scored in parallel, 0 is the number of CPUs
+	Workers int
+	// Cost is the cost function for regression, the squared error by default
+	Cost Cost
+	// Log is the path of the per-generation log, CSV if it ends in .csv and JSON lines otherwise
+	Log string
+}
+
+// Random is a random variable
+type Random struct {
+	Mean   float64
+	Stddev float64
+}
+
+// Layer is a layer of a network
+type Layer struct {
+	Weights Matrix
+	Bias    Matrix
+}
+
+// LayerDistribution is the distribution of a layer
+type LayerDistribution struct {
+	Cols    int
+	Rows    int
+	Weights []Random
+	Bias    []Random
+}
+
+// Distribution is a distribution of a neural network
+type Distribution struct {
+	Activations []Activation
+	Layers      []LayerDistribution
+}
+
+// Network is a neural network
+type Network struct {
+	Activations []Activation
+	Layers      []Layer
+	Loss        float64
+}
+
+// NewDistribution creates a new distribution for inputs features and outputs classes or targets
+func NewDistribution(rng *rand.Rand, inputs, outputs int, config Config) Distribution {
+	if len(config.Activations) != len(config.Hidden)+1 {
+		panic(fmt.Errorf("%d activations for %d layers", len(config.Activations), len(config.Hidden)+1))
+	}
+	d := Distribution{
+		Activations: config.Activations,
+	}
+	widths := append(append([]int{}, config.Hidden...), outputs)
+	cols := inputs
+	for i, rows := range widths {
+		layer := LayerDistribution{
+			Cols: cols,
+			Rows: rows,
+		}
+		factor := math.Sqrt(2.0 / float64(cols))
+		for j := 0; j < cols*rows; j++ {
+			layer.Weights = append(layer.Weights, Random{
+				Mean:   0,
+				Stddev: factor,
+			})
+		}
+		for j := 0; j < rows; j++ {
+			layer.Bias = append(layer.Bias, Random{
+				Mean:   0,
+				Stddev: .1,
+			})
+		}
+		d.Layers = append(d.Layers, layer)
+		cols = config.Activations[i].Width(rows)
+	}
+	return d
+}
+
+// Stddev returns the mean standard deviation of the random variables of the distribution
+func (d Distribution) Stddev() float64 {
+	sum, count := 0.0, 0
+	for _, layer := range d.Layers {
+		for _, randoms := range [][]Random{layer.Weights, layer.Bias} {
+			for _, r := range randoms {
+				sum += r.Stddev
+			}
+			count += len(randoms)
+		}
+	}
+	if count == 0 {
+		return 0
+	}
+	return sum / float64(count)
+}
+
+// Sample samples a network from the distribution
+func (d Distribution) Sample(rng *rand.Rand) Network {
+	n := Network{
+		Activations: d.Activations,
+		Layers:      make([]Layer, len(d.Layers)),
+	}
+	for i, layer := range d.Layers {
+		n.Layers[i].Weights = NewMatrix(0, layer.Cols, layer.Rows)
+		for _, r := range layer.Weights {
+			n.Layers[i].Weights.Data = append(n.Layers[i].Weights.Data, float32(rng.NormFloat64()*r.Stddev+r.Mean))
+		}
+		n.Layers[i].Bias = NewMatrix(0, 1, layer.Rows)
+		for _, r := range layer.Bias {
+			n.Layers[i].Bias.Data = append(n.Layers[i].Bias.Data, float32(rng.NormFloat64()*r.Stddev+r.Mean))
+		}
+	}
+	return n
+}
+
+// Forward computes the output of the network for an input
+func (n Network) Forward(input Matrix) Matrix {
+	output := input
+	for i, layer := range n.Layers {
+		output = n.Activations[i].Apply(Add(MulT(layer.Weights, output), layer.Bias))
+	}
+	return output
+}
+
+// Predict returns the most probable class of an input
+func (n Network) Predict(input []float32) (int, float32) {
+	in := NewMatrix(0, len(input), 1)
+	in.Data = append(in.Data, input...)
+	output := n.Forward(in)
+	max, index := float32(math.Inf(-1)), 0
+	for i, value := range output.Data {
+		if value > max {
+			max, index = value, i
+		}
+	}
+	return index, max
+}
+
+// Probabilities returns the class probabilities of an input
+func (n Network) Probabilities(input []float32) []float32 {
+	in := NewMatrix(0, len(input), 1)
+	in.Data = append(in.Data, input...)
+	return n.Forward(in).Data
+}
+
+// Value returns the regression output of an input
+func (n Network) Value(input []float32) float32 {
+	in := NewMatrix(0, len(input), 1)
+	in.Data = append(in.Data, input...)
+	return n.Forward(in).Data[0]
+}
+
+// Inference computes the loss of the network on rows of the dataset, the cost
+// of the error for regression targets or the squared error for class labels
+func (n *Network) Inference(d Dataset, rows []int, cost Cost) {
+	if d.Targets != nil {
+		loss := 0.0
+		for _, row := range rows {
+			loss += cost.Of(float64(n.Value(d.Inputs[row]) - d.Targets[row]))
+		}
+		n.Loss = loss
+		return
+	}
+	classes := len(d.Classes)
+	expected := make([]float32, classes)
+	loss := 0.0
+	for _, row := range rows {
+		input := NewMatrix(0, len(d.Inputs[row]), 1)
+		input.Data = append(input.Data, d.Inputs[row]...)
+		output := n.Forward(input)
+		for i := range expected {
+			expected[i] = 0
+		}
+		expected[d.Labels[row]] = 1
+		for i, value := range output.Data {
+			diff := float64(value - expected[i])
+			loss += diff * diff
+		}
+	}
+	n.Loss = loss
+}
+
+// Accuracy is the fraction of the dataset the network classifies correctly
+func (n Network) Accuracy(d Dataset) float64 {
+	if len(d.Inputs) == 0 {
+		return 0
+	}
+	correct := 0
+	for i, input := range d.Inputs {
+		if index, _ := n.Predict(input); index == d.Labels[i] {
+			correct++
+		}
+	}
+	return float64(correct) / float64(len(d.Inputs))
+}
+
+// Trainer learns a network for the class labels or the regression targets of a dataset
+type Trainer struct {
+	Config   Config
+	Observer train.Observer
+}
+
+// Learn learns a network for the class labels or the regression targets of the dataset
+func Learn(d Dataset, config Config) (Network, Distribution) {
+	network, distribution, err := learn(context.Background(), d, config)
+	if err != nil {
+		panic(err)
+	}
+	return network, distribution
+}
+
+// learn learns a network for the dataset printing the improvements and writing the log
+func learn(ctx context.Context, d Dataset, config Config) (Network, Distribution, error) {
+	log, err := train.CreateLog(config.Log)
+	if err != nil {
+		return Network{}, Distribution{}, err
+	}
+	defer log.Close()
+	trainer := Trainer{
+		Config: config,
+		Observer: func(s train.Stats) {
+			log.Observe(s)
+			if s.Improved {
+				fmt.Println(s.Generation, s.Stddev, s.Elite, s.Loss)
+			}
+		},
+	}
+	return trainer.Train(ctx, d)
+}
+
+// Train learns a network for the dataset, it returns the best network and the distribution so far
+// with the error of the context if the context is done
+func (t Trainer) Train(ctx context.Context, d Dataset) (Network, Distribution, error) {
+	config := t.Config
+	if config.Population <= 0 || config.Window <= 0 || config.Window > config.Population {
+		return Network{}, Distribution{}, fmt.Errorf("window %d is not in [1, %d]", config.Window, config.Population)
+	}
+	if len(config.Activations) != len(config.Hidden)+1 {
+		return Network{}, Distribution{}, fmt.Errorf("%d activations for %d layers", len(config.Activations), len(config.Hidden)+1)
+	}
+	rng := rand.New(rand.NewSource(config.Seed))
+	outputs := len(d.Classes)
+	if d.Targets != nil {
+		outputs = 1
+	}
+	distribution := NewDistribution(rng, len(d.Columns), outputs, config)
+	networks := make([]Network, config.Population)
+	window := config.Window
+	best := Network{}
+	minLoss := math.MaxFloat64
+	pool := train.NewPool[struct{}](config.Workers, nil)
+	defer pool.Close()
+	rows := make([]int, len(d.Inputs))
+	for i := range rows {
+		rows[i] = i
+	}
+	batch := rows
+	inference := func(rng *rand.Rand, j int) {
+		networks[j] = distribution.Sample(rng)
+		networks[j].Inference(d, batch, config.Cost)
+	}
+	for i := 0; i < config.Generations; i++ {
+		if err := ctx.Err(); err != nil {
+			return best, distribution, err
+		}
+		if config.Batch > 0 && config.Batch < len(rows) {
+			rng.Shuffle(len(rows), func(i, j int) {
+				rows[i], rows[j] = rows[j], rows[i]
+			})
+			batch = rows[:config.Batch]
+			// the best network is rescored on the new batch so that it is comparable
+			if best.Layers != nil {
+				best.Inference(d, batch, config.Cost)
+				minLoss = best.Loss
+			}
+		}
+		err := pool.Run(ctx, len(networks), func(w *train.Worker[struct{}], j int) error {
+			inference(w.Seed(train.Seed(config.Seed, i, j)), j)
+			return nil
+		})
+		if err != nil {
+			return best, distribution, err
+		}
+		sort.Slice(networks, func(i, j int) bool {
+			return networks[i].Loss < networks[j].Loss
+		})
+		min, index := math.MaxFloat64, 0
+		for j := 0; j < len(networks)-window; j++ {
+			mean := 0.0
+			for k := 0; k < window; k++ {
+				mean += networks[j+k].Loss
+			}
+			mean /= float64(window)
+			stddev := 0.0
+			for k := 0; k < window; k++ {
+				diff := mean - networks[j+k].Loss
+				stddev += diff * diff
+			}
+			stddev /= float64(window)
+			stddev = math.Sqrt(stddev)
+			if stddev < min {
+				min, index = stddev, j
+			}
+		}
+		losses := make([]float64, len(networks))
+		for j := range networks {
+			losses[j] = networks[j].Loss
+		}
+		stats := train.NewStats(i, losses)
+		stats.Spread = distribution.Stddev()
+		stats.Elite, stats.Stddev = index, min
+		if networks[index].Loss < minLoss {
+			best = networks[index]
+			minLoss = networks[index].Loss
+			stats.Improved = true
+		}
+		stats.Loss = minLoss
+		t.Observer.Observe(stats)
+		if !stats.Improved {
+			continue
+		}
+		next := Distribution{
+			Activations: distribution.Activations,
+			Layers:      make([]LayerDistribution, len(distribution.Layers)),
+		}
+		for l, layer := range distribution.Layers {
+			next.Layers[l] = LayerDistribution{
+				Cols:    layer.Cols,
+				Rows:    layer.Rows,
+				Weights: make([]Random, len(layer.Weights)),
+				Bias:    make([]Random, len(layer.Bias)),
+			}
+		}
+		for j := 0; j < window; j++ {
+			for l, layer := range networks[index+j].Layers {
+				for k, value := range layer.Weights.Data {
+					next.Layers[l].Weights[k].Mean += float64(value)
+				}
+				for k, value := range layer.Bias.Data {
+					next.Layers[l].Bias[k].Mean += float64(value)
+				}
+			}
+		}
+		for _, layer := range next.Layers {
+			for j := range layer.Weights {
+				layer.Weights[j].Mean /= float64(window)
+			}
+			for j := range layer.Bias {
+				layer.Bias[j].Mean /= float64(window)
+			}
+		}
+		for j := 0; j < window; j++ {
+			for l, layer := range networks[index+j].Layers {
+				for k, value := range layer.Weights.Data {
+					diff := next.Layers[l].Weights[k].Mean - float64(value)
+					next.Layers[l].Weights[k].Stddev += diff * diff
+				}
+				for k, value := range layer.Bias.Data {
+					diff := next.Layers[l].Bias[k].Mean - float64(value)
+					next.Layers[l].Bias[k].Stddev += diff * diff
+				}
+			}
+		}
+		for _, layer := range next.Layers {
+			for j := range layer.Weights {
+				layer.Weights[j].Stddev /= float64(window)
+				layer.Weights[j].Stddev = math.Sqrt(layer.Weights[j].Stddev)
+			}
+			for j := range layer.Bias {
+				layer.Bias[j].Stddev /= float64(window)
+				layer.Bias[j].Stddev = math.Sqrt(layer.Bias[j].Stddev)
+			}
+		}
+		distribution = next
+	}
+	return best, distribution, nil
+}
+
+// LearnCSV learns a classifier for a CSV file and saves it to the output file,
+// if the context is done the best classifier so far is saved
+func LearnCSV(ctx context.Context, name, label string, header bool, method Normalization, config Config, output string) {
+	data, err := LoadCSV(name, label, header)
+	if err != nil {
+		panic(err)
+	}
+	scaler, err := NewScaler(method, data)
+	if err != nil {
+		panic(err)
+	}
+	normalized := scaler.Normalize(data)
+	network, _, err := learn(ctx, normalized, config)
+	if err != nil && !train.Interrupted(err) {
+		panic(err)
+	}
+	if network.Layers == nil {
+		return
+	}
+	fmt.Println("accuracy", network.Accuracy(normalized))
+	c := Classifier{
+		Network: network,
+		Scaler:  scaler,
+		Columns: data.Columns,
+		Classes: data.Classes,
+	}
+	err = c.Save(output)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// InferCSV classifies the rows of a CSV file and reports the accuracy
+func InferCSV(c Classifier, name, label string, header bool) {
+	data, err := LoadCSV(name, label, header)
+	if err != nil {
+		panic(err)
+	}
+	if len(data.Columns) != len(c.Columns) {
+		panic(fmt.Errorf("%s has %d columns but the classifier has %d", name, len(data.Columns), len(c.Columns)))
+	}
+	err = data.SetClasses(c.Classes, data.Names())
+	if err != nil {
+		panic(err)
+	}
+	normalized := c.Scaler.Normalize(data)
+	for i, input := range normalized.Inputs {
+		index, max := c.Network.Predict(input)
+		fmt.Println(c.Classes[index], max, c.Classes[normalized.Labels[i]])
+	}
+	fmt.Println("accuracy", c.Network.Accuracy(normalized))
+}
+
+// LearnRegressionCSV learns a regressor for the target column of a CSV file and saves it to the output file,
+// if the context is done the best regressor so far is saved
+func LearnRegressionCSV(ctx context.Context, name, target string, header bool, method Normalization, config Config, output string) {
+	data, err := LoadRegressionCSV(name, target, header)
+	if err != nil {
+		panic(err)
+	}
+	scaler, err := NewScaler(method, data)
+	if err != nil {
+		panic(err)
+	}
+	standardizer := NewStandardizer(data.Targets)
+	normalized := standardizer.Standardize(scaler.Normalize(data))
+	network, _, err := learn(ctx, normalized, config)
+	if err != nil && !train.Interrupted(err) {
+		panic(err)
+	}
+	if network.Layers == nil {
+		return
+	}
+	r := Regressor{
+		Network: network,
+		Scaler:  scaler,
+		Columns: data.Columns,
+		Target:  standardizer,
+		Cost:    config.Cost,
+	}
+	fmt.Print(r.Evaluate(scaler.Normalize(data)))
+	err = r.Save(output)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// Evaluate computes the regression error on a dataset with normalized inputs
+func (r Regressor) Evaluate(d Dataset) metrics.Regression {
+	actual, predicted := make([]float64, len(d.Targets)), make([]float64, len(d.Targets))
+	for i, input := range d.Inputs {
+		actual[i], predicted[i] = float64(d.Targets[i]), float64(r.Predict(input))
+	}
+	return metrics.NewRegression(actual, predicted)
+}
+
+// InferRegressionCSV predicts the target column of a CSV file and reports the error
+func InferRegressionCSV(r Regressor, name, target string, header bool) {
+	data, err := LoadRegressionCSV(name, target, header)
+	if err != nil {
+		panic(err)
+	}
+	if len(data.Columns) != len(r.Columns) {
+		panic(fmt.Errorf("%s has %d columns but the regressor has %d", name, len(data.Columns), len(r.Columns)))
+	}
+	normalized := r.Scaler.Normalize(data)
+	for i, input := range normalized.Inputs {
+		fmt.Println(r.Predict(input), normalized.Targets[i])
+	}
+	fmt.Print(r.Evaluate(normalized))
+}
@@ -0,0 +1,199 @@
+// Copyright 2023 The RNN Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mlp
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/pointlander/rnn/model"
+	"github.com/pointlander/rnn/train"
+)
+
+func TestParseCSV(t *testing.T) {
+	data := "x,label,y\n1,b,2\n3,a,4\n5,b,6\n"
+	d, err := ParseCSV([]byte(data), "label", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(d.Columns) != 2 || d.Columns[1] != "y" || len(d.Classes) != 2 || d.Classes[0] != "a" {
+		t.Fatalf("columns %v classes %v", d.Columns, d.Classes)
+	}
+	if d.Labels[0] != 1 || d.Labels[1] != 0 || d.Inputs[2][1] != 6 {
+		t.Fatalf("labels %v inputs %v", d.Labels, d.Inputs)
+	}
+	if _, err := ParseCSV([]byte(data), "1", true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseCSV([]byte(data), "missing", true); err == nil {
+		t.Fatal("expected an error for a missing label column")
+	}
+
+	scaler, err := NewScaler(ZScore, d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	normalized := scaler.Normalize(d)
+	if normalized.Inputs[1][0] != 0 || d.Inputs[1][0] != 3 {
+		t.Fatalf("normalized %v original %v", normalized.Inputs, d.Inputs)
+	}
+}
+
+func TestLearn(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	var data bytes.Buffer
+	for i := 0; i < 64; i++ {
+		x, y := rng.Float64(), rng.Float64()
+		label := "low"
+		if x+y > 1 {
+			label = "high"
+		}
+		fmt.Fprintf(&data, "%f,%f,%s\n", x, y, label)
+	}
+	d, err := ParseCSV(data.Bytes(), "-1", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scaler, err := NewScaler(MinMax, d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d = scaler.Normalize(d)
+	network, _ := Learn(d, Config{
+		Hidden:      []int{8},
+		Activations: []Activation{ActivationSigmoid, ActivationSoftmax},
+		Population:  64,
+		Generations: 256,
+		Window:      8,
+		Seed:        1,
+	})
+	accuracy := network.Accuracy(d)
+	if accuracy < .8 {
+		t.Fatalf("accuracy is %f", accuracy)
+	}
+
+	var buffer bytes.Buffer
+	c := Classifier{Network: network, Scaler: scaler, Columns: d.Columns, Classes: d.Classes}
+	if err := model.Write(&buffer, c.Model()); err != nil {
+		t.Fatal(err)
+	}
+	m, err := model.Read(&buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := NewClassifier(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Network.Accuracy(d) != accuracy || loaded.Scaler.Scale[1] != scaler.Scale[1] {
+		t.Fatal("loaded classifier differs")
+	}
+}
+
+func TestRegression(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	var data bytes.Buffer
+	fmt.Fprintln(&data, "x,y")
+	for i := 0; i < 64; i++ {
+		x := rng.Float64()
+		fmt.Fprintf(&data, "%f,%f\n", x, 10*x+5)
+	}
+	d, err := ParseRegressionCSV(data.Bytes(), "y", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scaler, err := NewScaler(ZScore, d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d = scaler.Normalize(d)
+	standardizer := NewStandardizer(d.Targets)
+	network, _ := Learn(standardizer.Standardize(d), Config{
+		Hidden:      []int{4},
+		Activations: []Activation{ActivationEverett, ActivationLinear},
+		Population:  64,
+		Generations: 128,
+		Window:      8,
+		Seed:        1,
+		Cost:        CostHuber,
+	})
+	r := Regressor{Network: network, Scaler: scaler, Columns: d.Columns, Target: standardizer}
+	if e := r.Evaluate(d); e.R2 < .9 {
+		t.Fatalf("regression %+v", e)
+	}
+}
+
+func TestTrainer(t *testing.T) {
+	d, err := ParseCSV([]byte("1,0,a\n0,1,b\n1,1,a\n0,0,b\n"), "-1", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	generations := 0
+	trainer := Trainer{
+		Config: Config{
+			Hidden:      []int{4},
+			Activations: []Activation{ActivationSigmoid, ActivationSoftmax},
+			Population:  16,
+			Generations: 100,
+			Window:      4,
+			Seed:        1,
+		},
+		Observer: func(s train.Stats) {
+			if s.Generation != generations || s.Best > s.Median || s.Median > s.Worst {
+				t.Errorf("stats %+v", s)
+			}
+			generations++
+			if generations == 3 {
+				cancel()
+			}
+		},
+	}
+	_, _, err = trainer.Train(ctx, d)
+	if err != context.Canceled || generations != 3 {
+		t.Fatalf("error %v after %d generations", err, generations)
+	}
+	trainer.Config.Window = 17
+	if _, _, err := trainer.Train(context.Background(), d); err == nil {
+		t.Fatal("expected an error for a window larger than the population")
+	}
+}
+
+func TestDeterministic(t *testing.T) {
+	d, err := ParseCSV([]byte("1,0,a\n0,1,b\n1,1,a\n0,0,b\n1,.5,a\n.5,0,b\n"), "-1", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var losses [2][]float64
+	for i, workers := range []int{1, 7} {
+		trainer := Trainer{
+			Config: Config{
+				Hidden:      []int{4},
+				Activations: []Activation{ActivationSigmoid, ActivationSoftmax},
+				Population:  32,
+				Generations: 8,
+				Window:      4,
+				Batch:       4,
+				Seed:        1,
+				Workers:     workers,
+			},
+			Observer: func(s train.Stats) {
+				losses[i] = append(losses[i], s.Best, s.Median, s.Worst, s.Spread)
+			},
+		}
+		if _, _, err := trainer.Train(context.Background(), d); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := range losses[0] {
+		if losses[0][i] != losses[1][i] {
+			t.Fatalf("the losses depend on the number of workers %v %v", losses[0], losses[1])
+		}
+	}
+}
@@ -0,0 +1,224 @@
+// Copyright 2023 The RNN Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mlp
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pointlander/rnn/model"
+)
+
+const (
+	// Architecture is the architecture name of the classifier files
+	Architecture = "mlp"
+	// RegressionArchitecture is the architecture name of the regressor files
+	RegressionArchitecture = Architecture + ".regression"
+)
+
+// Classifier is a network with the normalization, columns and classes of its training data
+type Classifier struct {
+	Network Network
+	Scaler  Scaler
+	Columns []string
+	Classes []string
+}
+
+// Model converts the classifier into a model
+func (c Classifier) Model() *model.Model {
+	m := model.New(Architecture)
+	addNetwork(m, c.Network, c.Scaler, c.Columns)
+	classes, _ := json.Marshal(c.Classes)
+	m.Metadata["classes"] = string(classes)
+	return m
+}
+
+// NewClassifier creates a classifier from a model
+func NewClassifier(m *model.Model) (Classifier, error) {
+	var c Classifier
+	err := m.Check(Architecture, nil)
+	if err != nil {
+		return c, err
+	}
+	if err := json.Unmarshal([]byte(m.Metadata["classes"]), &c.Classes); err != nil {
+		return c, fmt.Errorf("invalid classes: %w", err)
+	}
+	if c.Network, c.Scaler, c.Columns, err = getNetwork(m); err != nil {
+		return c, err
+	}
+	if layers := len(c.Network.Layers); layers > 0 && c.Network.Layers[layers-1].Weights.Rows != len(c.Classes) {
+		return c, fmt.Errorf("model has %d outputs but %d classes", c.Network.Layers[layers-1].Weights.Rows, len(c.Classes))
+	}
+	return c, nil
+}
+
+// addNetwork adds the network, the normalization and the columns to a model
+func addNetwork(m *model.Model, n Network, scaler Scaler, columns []string) {
+	m.Config["inputs"] = len(columns)
+	m.Config["layers"] = len(n.Layers)
+	activations := make([]string, len(n.Activations))
+	for i, a := range n.Activations {
+		activations[i] = string(a)
+	}
+	m.Metadata["activations"] = strings.Join(activations, ",")
+	m.Metadata["normalization"] = string(scaler.Method)
+	names, _ := json.Marshal(columns)
+	m.Metadata["columns"] = string(names)
+	m.Metadata["loss"] = strconv.FormatFloat(n.Loss, 'g', -1, 64)
+	for i, layer := range n.Layers {
+		m.AddMatrix(fmt.Sprintf("Layer.%d.Weights", i), layer.Weights)
+		m.AddMatrix(fmt.Sprintf("Layer.%d.Bias", i), layer.Bias)
+	}
+	if scaler.Offset != nil {
+		m.Add(model.NewF32("Scaler.Offset", []int{len(scaler.Offset)}, scaler.Offset))
+		m.Add(model.NewF32("Scaler.Scale", []int{len(scaler.Scale)}, scaler.Scale))
+	}
+}
+
+// getNetwork gets the network, the normalization and the columns added by addNetwork
+func getNetwork(m *model.Model) (Network, Scaler, []string, error) {
+	var n Network
+	var scaler Scaler
+	var columns []string
+	if err := json.Unmarshal([]byte(m.Metadata["columns"]), &columns); err != nil {
+		return n, scaler, nil, fmt.Errorf("invalid columns: %w", err)
+	}
+	if len(columns) != m.Config["inputs"] {
+		return n, scaler, nil, fmt.Errorf("model has %d inputs but %d columns", m.Config["inputs"], len(columns))
+	}
+	var err error
+	if n.Activations, err = ParseActivations(m.Metadata["activations"]); err != nil {
+		return n, scaler, nil, err
+	}
+	layers := m.Config["layers"]
+	if len(n.Activations) != layers {
+		return n, scaler, nil, fmt.Errorf("model has %d layers but %d activations", layers, len(n.Activations))
+	}
+	cols := len(columns)
+	for i := 0; i < layers; i++ {
+		weights, err := m.Tensor(fmt.Sprintf("Layer.%d.Weights", i))
+		if err != nil {
+			return n, scaler, nil, err
+		}
+		if len(weights.Shape) != 2 {
+			return n, scaler, nil, fmt.Errorf("tensor %s has shape %v", weights.Name, weights.Shape)
+		}
+		rows := weights.Shape[0]
+		var layer Layer
+		if layer.Weights, err = m.Matrix(weights.Name, cols, rows); err != nil {
+			return n, scaler, nil, err
+		}
+		if layer.Bias, err = m.Matrix(fmt.Sprintf("Layer.%d.Bias", i), 1, rows); err != nil {
+			return n, scaler, nil, err
+		}
+		n.Layers = append(n.Layers, layer)
+		cols = n.Activations[i].Width(rows)
+	}
+	scaler.Method = Normalization(m.Metadata["normalization"])
+	if scaler.Method == MinMax || scaler.Method == ZScore {
+		for _, values := range []struct {
+			name   string
+			values *[]float32
+		}{{"Scaler.Offset", &scaler.Offset}, {"Scaler.Scale", &scaler.Scale}} {
+			t, err := m.Tensor(values.name)
+			if err != nil {
+				return n, scaler, nil, err
+			}
+			if *values.values, err = t.Float32(); err != nil {
+				return n, scaler, nil, err
+			}
+			if len(*values.values) != len(columns) {
+				return n, scaler, nil, fmt.Errorf("tensor %s has %d values for %d columns", values.name, len(*values.values), len(columns))
+			}
+		}
+	}
+	if loss, ok := m.Metadata["loss"]; ok {
+		n.Loss, _ = strconv.ParseFloat(loss, 64)
+	}
+	return n, scaler, columns, nil
+}
+
+// Save saves the classifier
+func (c Classifier) Save(name string) error {
+	return model.Save(name, c.Model())
+}
+
+// Load loads a classifier
+func Load(name string) (Classifier, error) {
+	m, err := model.Load(name)
+	if err != nil {
+		return Classifier{}, err
+	}
+	return NewClassifier(m)
+}
+
+// Regressor is a network with the normalization and columns of its training data
+// and the standardization of its targets
+type Regressor struct {
+	Network Network
+	Scaler  Scaler
+	Columns []string
+	Target  Standardizer
+	Cost    Cost
+}
+
+// Predict predicts the target of an input that is already normalized
+func (r Regressor) Predict(input []float32) float32 {
+	return r.Target.Restore(r.Network.Value(input))
+}
+
+// Model converts the regressor into a model
+func (r Regressor) Model() *model.Model {
+	m := model.New(RegressionArchitecture)
+	addNetwork(m, r.Network, r.Scaler, r.Columns)
+	m.Metadata["cost"] = string(r.Cost)
+	m.Add(model.NewF32("Target", []int{2}, []float32{r.Target.Mean, r.Target.Stddev}))
+	return m
+}
+
+// NewRegressor creates a regressor from a model
+func NewRegressor(m *model.Model) (Regressor, error) {
+	var r Regressor
+	err := m.Check(RegressionArchitecture, nil)
+	if err != nil {
+		return r, err
+	}
+	if r.Network, r.Scaler, r.Columns, err = getNetwork(m); err != nil {
+		return r, err
+	}
+	if layers := len(r.Network.Layers); layers > 0 && r.Network.Layers[layers-1].Weights.Rows != 1 {
+		return r, fmt.Errorf("model has %d outputs not 1", r.Network.Layers[layers-1].Weights.Rows)
+	}
+	t, err := m.Tensor("Target")
+	if err != nil {
+		return r, err
+	}
+	target, err := t.Float32()
+	if err != nil {
+		return r, err
+	}
+	if len(target) != 2 {
+		return r, fmt.Errorf("tensor Target has %d values not 2", len(target))
+	}
+	r.Target = Standardizer{Mean: target[0], Stddev: target[1]}
+	r.Cost = Cost(m.Metadata["cost"])
+	return r, nil
+}
+
+// Save saves the regressor
+func (r Regressor) Save(name string) error {
+	return model.Save(name, r.Model())
+}
+
+// LoadRegressor loads a regressor
+func LoadRegressor(name string) (Regressor, error) {
+	m, err := model.Load(name)
+	if err != nil {
+		return Regressor{}, err
+	}
+	return NewRegressor(m)
+}
@@ -0,0 +1,475 @@
+// Copyright 2023 The RNN Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package model implements a self describing container for trained models
+//
+// A model file is the magic number, the little endian uint32 schema version,
+// the uint32 length of the JSON header, the uint32 crc32 of the header, the
+// header and then the raw little endian data of each tensor in header order
+package model
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+
+	"github.com/pointlander/rnn/matrix/f32"
+	"github.com/pointlander/rnn/tokenizer"
+)
+
+const (
+	// Magic identifies a model file
+	Magic = "RNNMODEL"
+	// Version is the schema version written by this package
+	Version = 1
+	// MaxHeader is the largest length of a JSON header that is read
+	MaxHeader = 64 << 20
+)
+
+var (
+	// ErrNotModel is returned when a file does not start with the magic number
+	ErrNotModel = errors.New("not a model file")
+)
+
+// DType is the data type of a tensor
+type DType string
+
+const (
+	// F32 is a 32 bit float
+	F32 DType = "f32"
+	// F64 is a 64 bit float
+	F64 DType = "f64"
+)
+
+// Size is the number of bytes of one element
+func (d DType) Size() int {
+	switch d {
+	case F32:
+		return 4
+	case F64:
+		return 8
+	}
+	return 0
+}
+
+// Tensor is a named tensor, shapes are row major with the last dimension varying fastest
+type Tensor struct {
+	Name  string
+	DType DType
+	Shape []int
+	Data  []byte
+}
+
+// check checks that the dimensions of the tensor shape are positive and that its data has
+// length bytes, it does not overflow for the shapes of corrupt files
+func (t Tensor) check(length int64) error {
+	size := int64(t.DType.Size())
+	if size == 0 {
+		return fmt.Errorf("tensor %s has unknown type %s", t.Name, t.DType)
+	}
+	if length < 0 {
+		return fmt.Errorf("tensor %s has a negative length %d", t.Name, length)
+	}
+	elements := int64(1)
+	for _, dimension := range t.Shape {
+		if dimension <= 0 {
+			return fmt.Errorf("tensor %s has a dimension that is not positive in shape %v", t.Name, t.Shape)
+		}
+		if int64(dimension) > length/size/elements {
+			return fmt.Errorf("tensor %s has %d bytes for shape %v", t.Name, length, t.Shape)
+		}
+		elements *= int64(dimension)
+	}
+	if elements*size != length {
+		return fmt.Errorf("tensor %s has %d bytes for shape %v", t.Name, length, t.Shape)
+	}
+	return nil
+}
+
+// Elements is the number of elements of the tensor shape
+func (t Tensor) Elements() int {
+	elements := 1
+	for _, dimension := range t.Shape {
+		elements *= dimension
+	}
+	return elements
+}
+
+// NewF32 creates a float32 tensor
+func NewF32(name string, shape []int, values []float32) Tensor {
+	data := make([]byte, 4*len(values))
+	for i, value := range values {
+		binary.LittleEndian.PutUint32(data[4*i:], math.Float32bits(value))
+	}
+	return Tensor{
+		Name:  name,
+		DType: F32,
+		Shape: shape,
+		Data:  data,
+	}
+}
+
+// NewF64 creates a float64 tensor
+func NewF64(name string, shape []int, values []float64) Tensor {
+	data := make([]byte, 8*len(values))
+	for i, value := range values {
+		binary.LittleEndian.PutUint64(data[8*i:], math.Float64bits(value))
+	}
+	return Tensor{
+		Name:  name,
+		DType: F64,
+		Shape: shape,
+		Data:  data,
+	}
+}
+
+// Float32 returns the values of a float32 tensor
+func (t Tensor) Float32() ([]float32, error) {
+	if t.DType != F32 {
+		return nil, fmt.Errorf("tensor %s has type %s not %s", t.Name, t.DType, F32)
+	}
+	values := make([]float32, len(t.Data)/4)
+	for i := range values {
+		values[i] = math.Float32frombits(binary.LittleEndian.Uint32(t.Data[4*i:]))
+	}
+	return values, nil
+}
+
+// Float64 returns the values of a float64 tensor
+func (t Tensor) Float64() ([]float64, error) {
+	if t.DType != F64 {
+		return nil, fmt.Errorf("tensor %s has type %s not %s", t.Name, t.DType, F64)
+	}
+	values := make([]float64, len(t.Data)/8)
+	for i := range values {
+		values[i] = math.Float64frombits(binary.LittleEndian.Uint64(t.Data[8*i:]))
+	}
+	return values, nil
+}
+
+// Info describes a tensor in the header
+type Info struct {
+	Name     string `json:"name"`
+	DType    DType  `json:"dtype"`
+	Shape    []int  `json:"shape"`
+	Offset   int64  `json:"offset"`
+	Length   int64  `json:"length"`
+	Checksum uint32 `json:"crc32"`
+}
+
+// Header is the JSON header of a model file
+type Header struct {
+	Architecture string                `json:"architecture"`
+	Config       map[string]int        `json:"config"`
+	Tokenizer    *tokenizer.Vocabulary `json:"tokenizer,omitempty"`
+	Metadata     map[string]string     `json:"metadata,omitempty"`
+	Tensors      []Info                `json:"tensors"`
+}
+
+// Model is a trained model
+type Model struct {
+	Architecture string
+	Config       map[string]int
+	Tokenizer    *tokenizer.Vocabulary
+	Metadata     map[string]string
+	Tensors      []Tensor
+}
+
+// New creates a new model for an architecture
+func New(architecture string) *Model {
+	return &Model{
+		Architecture: architecture,
+		Config:       make(map[string]int),
+		Metadata:     make(map[string]string),
+	}
+}
+
+// SetTokenizer stores the vocabulary of a tokenizer in the model
+func (m *Model) SetTokenizer(t tokenizer.Tokenizer) {
+	vocabulary := tokenizer.NewVocabulary(t)
+	m.Tokenizer = &vocabulary
+}
+
+// GetTokenizer returns the tokenizer of the model, models without one use bytes
+func (m *Model) GetTokenizer() (tokenizer.Tokenizer, error) {
+	if m.Tokenizer == nil {
+		return tokenizer.Byte{}, nil
+	}
+	return m.Tokenizer.Tokenizer()
+}
+
+// Add adds a tensor to the model
+func (m *Model) Add(t Tensor) {
+	m.Tensors = append(m.Tensors, t)
+}
+
+// AddMatrix adds a matrix as a rows by cols float32 tensor
+func (m *Model) AddMatrix(name string, a f32.Matrix) {
+	m.Add(NewF32(name, []int{a.Rows, a.Cols}, a.Data))
+}
+
+// Tensor finds a tensor by name
+func (m *Model) Tensor(name string) (Tensor, error) {
+	for _, t := range m.Tensors {
+		if t.Name == name {
+			return t, nil
+		}
+	}
+	return Tensor{}, fmt.Errorf("model has no tensor %s", name)
+}
+
+// Matrix returns the named tensor as a matrix, checking that it has the expected shape
+func (m *Model) Matrix(name string, cols, rows int) (f32.Matrix, error) {
+	t, err := m.Tensor(name)
+	if err != nil {
+		return f32.Matrix{}, err
+	}
+	if len(t.Shape) != 2 || t.Shape[0] != rows || t.Shape[1] != cols {
+		return f32.Matrix{}, fmt.Errorf("tensor %s has shape %v not [%d %d]", name, t.Shape, rows, cols)
+	}
+	values, err := t.Float32()
+	if err != nil {
+		return f32.Matrix{}, err
+	}
+	a := f32.NewMatrix(0, cols, rows)
+	a.Data = append(a.Data, values...)
+	return a, nil
+}
+
+// Float64 returns the named float64 tensor, checking that it has the expected shape
+func (m *Model) Float64(name string, shape ...int) ([]float64, error) {
+	t, err := m.Tensor(name)
+	if err != nil {
+		return nil, err
+	}
+	if len(t.Shape) != len(shape) {
+		return nil, fmt.Errorf("tensor %s has shape %v not %v", name, t.Shape, shape)
+	}
+	for i, dimension := range shape {
+		if t.Shape[i] != dimension {
+			return nil, fmt.Errorf("tensor %s has shape %v not %v", name, t.Shape, shape)
+		}
+	}
+	return t.Float64()
+}
+
+// Random is a random variable of a distribution
+type Random struct {
+	Mean   float64
+	Stddev float64
+}
+
+// AddRandom adds the means and standard deviations of random variables as rows by cols float64 tensors
+// named name.Mean and name.Stddev
+func (m *Model) AddRandom(name string, rows, cols int, random []Random) {
+	mean, stddev := make([]float64, len(random)), make([]float64, len(random))
+	for i, r := range random {
+		mean[i], stddev[i] = r.Mean, r.Stddev
+	}
+	m.Add(NewF64(name+".Mean", []int{rows, cols}, mean))
+	m.Add(NewF64(name+".Stddev", []int{rows, cols}, stddev))
+}
+
+// GetRandom gets the random variables stored by AddRandom, checking that they have the expected shape
+func (m *Model) GetRandom(name string, rows, cols int) ([]Random, error) {
+	mean, err := m.Float64(name+".Mean", rows, cols)
+	if err != nil {
+		return nil, err
+	}
+	stddev, err := m.Float64(name+".Stddev", rows, cols)
+	if err != nil {
+		return nil, err
+	}
+	random := make([]Random, len(mean))
+	for i := range random {
+		random[i] = Random{
+			Mean:   mean[i],
+			Stddev: stddev[i],
+		}
+	}
+	return random, nil
+}
+
+// Check checks the architecture and the expected configuration of the model
+func (m *Model) Check(architecture string, config map[string]int) error {
+	if m.Architecture != architecture {
+		return fmt.Errorf("model architecture is %s not %s", m.Architecture, architecture)
+	}
+	for key, value := range config {
+		if m.Config[key] != value {
+			return fmt.Errorf("model %s is %d not %d", key, m.Config[key], value)
+		}
+	}
+	return nil
+}
+
+// Write writes the model
+func Write(w io.Writer, m *Model) error {
+	header := Header{
+		Architecture: m.Architecture,
+		Config:       m.Config,
+		Tokenizer:    m.Tokenizer,
+		Metadata:     m.Metadata,
+	}
+	offset := int64(0)
+	for _, t := range m.Tensors {
+		if err := t.check(int64(len(t.Data))); err != nil {
+			return err
+		}
+		header.Tensors = append(header.Tensors, Info{
+			Name:     t.Name,
+			DType:    t.DType,
+			Shape:    t.Shape,
+			Offset:   offset,
+			Length:   int64(len(t.Data)),
+			Checksum: crc32.ChecksumIEEE(t.Data),
+		})
+		offset += int64(len(t.Data))
+	}
+	encoded, err := json.Marshal(header)
+	if err != nil {
+		return err
+	}
+
+	output := bufio.NewWriter(w)
+	prefix := make([]byte, len(Magic)+12)
+	copy(prefix, Magic)
+	binary.LittleEndian.PutUint32(prefix[len(Magic):], Version)
+	binary.LittleEndian.PutUint32(prefix[len(Magic)+4:], uint32(len(encoded)))
+	binary.LittleEndian.PutUint32(prefix[len(Magic)+8:], crc32.ChecksumIEEE(encoded))
+	for _, data := range [][]byte{prefix, encoded} {
+		if _, err := output.Write(data); err != nil {
+			return err
+		}
+	}
+	for _, t := range m.Tensors {
+		if _, err := output.Write(t.Data); err != nil {
+			return err
+		}
+	}
+	return output.Flush()
+}
+
+// Read reads a model, returning ErrNotModel if the input is not a model file and an error if it is corrupt
+func Read(r io.Reader) (*Model, error) {
+	input := bufio.NewReader(r)
+	prefix := make([]byte, len(Magic)+12)
+	if _, err := io.ReadFull(input, prefix); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, ErrNotModel
+		}
+		return nil, err
+	}
+	if !bytes.Equal(prefix[:len(Magic)], []byte(Magic)) {
+		return nil, ErrNotModel
+	}
+	version := binary.LittleEndian.Uint32(prefix[len(Magic):])
+	if version > Version {
+		return nil, fmt.Errorf("model schema version %d is newer than %d", version, Version)
+	}
+	length := binary.LittleEndian.Uint32(prefix[len(Magic)+4:])
+	if length > MaxHeader {
+		return nil, fmt.Errorf("model header length %d is more than %d", length, MaxHeader)
+	}
+	encoded := make([]byte, length)
+	if _, err := io.ReadFull(input, encoded); err != nil {
+		return nil, err
+	}
+	if crc32.ChecksumIEEE(encoded) != binary.LittleEndian.Uint32(prefix[len(Magic)+8:]) {
+		return nil, errors.New("model header checksum mismatch")
+	}
+	var header Header
+	if err := json.Unmarshal(encoded, &header); err != nil {
+		return nil, err
+	}
+
+	m := &Model{
+		Architecture: header.Architecture,
+		Config:       header.Config,
+		Tokenizer:    header.Tokenizer,
+		Metadata:     header.Metadata,
+	}
+	if m.Config == nil {
+		m.Config = make(map[string]int)
+	}
+	if m.Metadata == nil {
+		m.Metadata = make(map[string]string)
+	}
+	offset := int64(0)
+	for _, info := range header.Tensors {
+		t := Tensor{
+			Name:  info.Name,
+			DType: info.DType,
+			Shape: info.Shape,
+		}
+		if info.Offset != offset {
+			return nil, fmt.Errorf("tensor %s has an invalid layout", info.Name)
+		}
+		if err := t.check(info.Length); err != nil {
+			return nil, err
+		}
+		// the data is read as it arrives so that a truncated file does not allocate the length it claims
+		var data bytes.Buffer
+		if _, err := io.CopyN(&data, input, info.Length); err != nil {
+			return nil, fmt.Errorf("tensor %s: %w", info.Name, err)
+		}
+		t.Data = data.Bytes()
+		if crc32.ChecksumIEEE(t.Data) != info.Checksum {
+			return nil, fmt.Errorf("tensor %s checksum mismatch", info.Name)
+		}
+		m.Tensors = append(m.Tensors, t)
+		offset += info.Length
+	}
+	return m, nil
+}
+
+// writeFile writes a file with write, the file is written to a temporary file in the same directory
+// that is renamed into place so that readers never see a partly written file
+func writeFile(name string, write func(w io.Writer) error) error {
+	output, err := os.CreateTemp(filepath.Dir(name), filepath.Base(name)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	err = write(output)
+	if err == nil {
+		err = output.Chmod(0644)
+	}
+	if err == nil {
+		err = output.Close()
+	} else {
+		output.Close()
+	}
+	if err == nil {
+		err = os.Rename(output.Name(), name)
+	}
+	if err != nil {
+		os.Remove(output.Name())
+	}
+	return err
+}
+
+// Save writes the model to a file
+func Save(name string, m *Model) error {
+	return writeFile(name, func(w io.Writer) error {
+		return Write(w, m)
+	})
+}
+
+// Load reads a model from a file
+func Load(name string) (*Model, error) {
+	input, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer input.Close()
+	return Read(input)
+}
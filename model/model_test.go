@@ -0,0 +1,248 @@
+// Copyright 2023 The RNN Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package model
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pointlander/rnn/matrix/f32"
+	"github.com/pointlander/rnn/tokenizer"
+)
+
+func TestRoundTrip(t *testing.T) {
+	m := New("test")
+	m.Config["width"] = 3
+	m.Metadata["loss"] = "1.5"
+	m.SetTokenizer(tokenizer.NewBPE([]byte("aaaa bbbb aaaa bbbb"), 260))
+	a := f32.NewMatrix(0, 3, 2)
+	a.Data = append(a.Data, 1, 2, 3, 4, 5, 6)
+	m.AddMatrix("A", a)
+	m.Add(NewF64("B", []int{2}, []float64{.5, -.25}))
+	random := []Random{{Mean: 1, Stddev: .5}, {Mean: -1, Stddev: 2}}
+	m.AddRandom("C", 2, 1, random)
+
+	buffer := bytes.Buffer{}
+	if err := Write(&buffer, m); err != nil {
+		t.Fatal(err)
+	}
+	encoded := buffer.Bytes()
+	loaded, err := Read(bytes.NewReader(encoded))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := loaded.Check("test", map[string]int{"width": 3}); err != nil {
+		t.Fatal(err)
+	}
+	if err := loaded.Check("test", map[string]int{"width": 4}); err == nil {
+		t.Fatal("config mismatch was not detected")
+	}
+	if err := loaded.Check("other", nil); err == nil {
+		t.Fatal("architecture mismatch was not detected")
+	}
+	if loaded.Metadata["loss"] != "1.5" {
+		t.Fatalf("metadata %v", loaded.Metadata)
+	}
+	tok, err := loaded.GetTokenizer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tok.Size() != 260 {
+		t.Fatalf("tokenizer size %d", tok.Size())
+	}
+	b, err := loaded.Matrix("A", 3, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range a.Data {
+		if a.Data[i] != b.Data[i] {
+			t.Fatalf("%v != %v", a.Data, b.Data)
+		}
+	}
+	if _, err := loaded.Matrix("A", 2, 3); err == nil {
+		t.Fatal("shape mismatch was not detected")
+	}
+	if _, err := loaded.Matrix("B", 1, 2); err == nil {
+		t.Fatal("type mismatch was not detected")
+	}
+	c, err := loaded.GetRandom("C", 2, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c) != len(random) || c[0] != random[0] || c[1] != random[1] {
+		t.Fatalf("%v != %v", c, random)
+	}
+	if _, err := loaded.GetRandom("C", 1, 2); err == nil {
+		t.Fatal("random shape mismatch was not detected")
+	}
+
+	corrupt := append([]byte{}, encoded...)
+	corrupt[len(corrupt)-1] ^= 1
+	if _, err := Read(bytes.NewReader(corrupt)); err == nil {
+		t.Fatal("checksum mismatch was not detected")
+	}
+	if _, err := Read(bytes.NewReader([]byte("gob data"))); !errors.Is(err, ErrNotModel) {
+		t.Fatalf("expected ErrNotModel got %v", err)
+	}
+}
+
+func TestExchangeFormats(t *testing.T) {
+	m := New("test")
+	m.Config["width"] = 3
+	m.Metadata["loss"] = "1.5"
+	m.SetTokenizer(tokenizer.Byte{})
+	a := f32.NewMatrix(0, 3, 2)
+	a.Data = append(a.Data, 1, 2, 3, 4, 5, 6)
+	m.AddMatrix("A", a)
+	m.Add(NewF64("A.Mean", []int{2, 3}, []float64{.5, -.25, 1, 2, 3, 4}))
+
+	check := func(format string, loaded *Model) {
+		if loaded.Architecture != "test" || loaded.Config["width"] != 3 || loaded.Metadata["loss"] != "1.5" {
+			t.Fatalf("%s: header %s %v %v", format, loaded.Architecture, loaded.Config, loaded.Metadata)
+		}
+		if loaded.Tokenizer == nil || loaded.Tokenizer.Name != "byte" {
+			t.Fatalf("%s: tokenizer %v", format, loaded.Tokenizer)
+		}
+		if len(loaded.Tensors) != len(m.Tensors) {
+			t.Fatalf("%s: %d tensors", format, len(loaded.Tensors))
+		}
+		for _, expected := range m.Tensors {
+			tensor, err := loaded.Tensor(expected.Name)
+			if err != nil {
+				t.Fatalf("%s: %v", format, err)
+			}
+			if tensor.DType != expected.DType || !bytes.Equal(tensor.Data, expected.Data) ||
+				len(tensor.Shape) != len(expected.Shape) {
+				t.Fatalf("%s: tensor %s differs", format, expected.Name)
+			}
+		}
+	}
+
+	buffer := bytes.Buffer{}
+	if err := WriteSafetensors(&buffer, m); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := ReadSafetensors(&buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("safetensors", loaded)
+
+	buffer.Reset()
+	if err := WriteNPZ(&buffer, m); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err = ReadNPZ(bytes.NewReader(buffer.Bytes()), int64(buffer.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("npz", loaded)
+
+	buffer.Reset()
+	if err := WriteMatrix(&buffer, a); err != nil {
+		t.Fatal(err)
+	}
+	if (buffer.Len()-4*len(a.Data))%64 != 0 {
+		t.Fatalf("npy data is not aligned %d", buffer.Len())
+	}
+	b, err := ReadMatrix(&buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Cols != a.Cols || b.Rows != a.Rows {
+		t.Fatalf("shape %dx%d", b.Cols, b.Rows)
+	}
+	for i := range a.Data {
+		if a.Data[i] != b.Data[i] {
+			t.Fatalf("%v != %v", a.Data, b.Data)
+		}
+	}
+}
+
+func TestCorrupt(t *testing.T) {
+	encode := func(length uint32, header Header, data []byte) []byte {
+		encoded, err := json.Marshal(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if length == 0 {
+			length = uint32(len(encoded))
+		}
+		prefix := make([]byte, len(Magic)+12)
+		copy(prefix, Magic)
+		binary.LittleEndian.PutUint32(prefix[len(Magic):], Version)
+		binary.LittleEndian.PutUint32(prefix[len(Magic)+4:], length)
+		binary.LittleEndian.PutUint32(prefix[len(Magic)+8:], crc32.ChecksumIEEE(encoded))
+		return append(append(prefix, encoded...), data...)
+	}
+	tensor := func(shape []int, length int64) Header {
+		return Header{
+			Architecture: "test",
+			Tensors: []Info{{
+				Name:   "A",
+				DType:  F32,
+				Shape:  shape,
+				Length: length,
+			}},
+		}
+	}
+	files := map[string][]byte{
+		"header length":   encode(1<<32-1, Header{Architecture: "test"}, nil),
+		"negative shape":  encode(0, tensor([]int{-1, 4}, -16), nil),
+		"zero dimension":  encode(0, tensor([]int{0, 4}, 0), nil),
+		"overflow":        encode(0, tensor([]int{1 << 62, 1 << 62}, 16), make([]byte, 16)),
+		"negative length": encode(0, tensor([]int{4}, -16), nil),
+		"truncated":       encode(0, tensor([]int{1 << 20, 1 << 20}, 4<<40), make([]byte, 16)),
+	}
+	for name, file := range files {
+		if _, err := Read(bytes.NewReader(file)); err == nil || errors.Is(err, ErrNotModel) {
+			t.Fatalf("%s: %v", name, err)
+		}
+	}
+
+	var npy bytes.Buffer
+	if err := writeNPY(&npy, "<f4", []int{-2, -2}, make([]byte, 16)); err != nil {
+		t.Fatal(err)
+	}
+	if m, err := ReadMatrix(&npy); err == nil {
+		t.Fatalf("npy matrix with shape %dx%d", m.Cols, m.Rows)
+	}
+}
+
+func TestSave(t *testing.T) {
+	directory := t.TempDir()
+	name := filepath.Join(directory, "test.model")
+	m := New("test")
+	m.Add(NewF64("A", []int{2}, []float64{1, 2}))
+	if err := Save(name, m); err != nil {
+		t.Fatal(err)
+	}
+	// a model that fails to write leaves the saved model in place
+	corrupt := New("corrupt")
+	corrupt.Add(Tensor{Name: "B", DType: F32, Shape: []int{3}, Data: make([]byte, 4)})
+	if err := Save(name, corrupt); err == nil {
+		t.Fatal("expected an error for a corrupt tensor")
+	}
+	loaded, err := Load(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Architecture != "test" {
+		t.Fatalf("architecture is %s", loaded.Architecture)
+	}
+	entries, err := os.ReadDir(directory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("the temporary files were not removed %v", entries)
+	}
+}
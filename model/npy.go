@@ -0,0 +1,312 @@
+// Copyright 2023 The RNN Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package model
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pointlander/rnn/matrix/f32"
+)
+
+// https://numpy.org/doc/stable/reference/generated/numpy.lib.format.html
+
+const (
+	// npyMagic is the magic number of the npy format
+	npyMagic = "\x93NUMPY"
+	// npzHeader is the name of the npz member holding the JSON encoded model header
+	npzHeader = "__header__"
+)
+
+var (
+	npyDescr   = regexp.MustCompile(`'descr':\s*'([^']*)'`)
+	npyFortran = regexp.MustCompile(`'fortran_order':\s*(True|False)`)
+	npyShape   = regexp.MustCompile(`'shape':\s*\(([^)]*)\)`)
+)
+
+// writeNPY writes raw little endian data with a numpy type descriptor and shape
+func writeNPY(w io.Writer, descr string, shape []int, data []byte) error {
+	dimensions := make([]string, len(shape))
+	for i, dimension := range shape {
+		dimensions[i] = strconv.Itoa(dimension)
+	}
+	tuple := strings.Join(dimensions, ", ")
+	if len(shape) == 1 {
+		tuple += ","
+	}
+	header := fmt.Sprintf("{'descr': '%s', 'fortran_order': False, 'shape': (%s), }", descr, tuple)
+	// the header is padded with spaces and a newline so the data is 64 byte aligned
+	total := len(npyMagic) + 4 + len(header) + 1
+	if padding := total % 64; padding != 0 {
+		header += strings.Repeat(" ", 64-padding)
+	}
+	header += "\n"
+
+	prefix := make([]byte, len(npyMagic)+4)
+	copy(prefix, npyMagic)
+	prefix[len(npyMagic)] = 1
+	prefix[len(npyMagic)+1] = 0
+	binary.LittleEndian.PutUint16(prefix[len(npyMagic)+2:], uint16(len(header)))
+	for _, part := range [][]byte{prefix, []byte(header), data} {
+		if _, err := w.Write(part); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readNPY reads the type descriptor, shape and raw data of a npy file
+func readNPY(r io.Reader) (string, []int, []byte, error) {
+	prefix := make([]byte, len(npyMagic)+2)
+	if _, err := io.ReadFull(r, prefix); err != nil {
+		return "", nil, nil, err
+	}
+	if string(prefix[:len(npyMagic)]) != npyMagic {
+		return "", nil, nil, errors.New("not a npy file")
+	}
+	var length int
+	switch major := prefix[len(npyMagic)]; major {
+	case 1:
+		size := make([]byte, 2)
+		if _, err := io.ReadFull(r, size); err != nil {
+			return "", nil, nil, err
+		}
+		length = int(binary.LittleEndian.Uint16(size))
+	case 2, 3:
+		size := make([]byte, 4)
+		if _, err := io.ReadFull(r, size); err != nil {
+			return "", nil, nil, err
+		}
+		length = int(binary.LittleEndian.Uint32(size))
+	default:
+		return "", nil, nil, fmt.Errorf("unsupported npy version %d", major)
+	}
+	if length > MaxHeader {
+		return "", nil, nil, fmt.Errorf("npy header length %d is more than %d", length, MaxHeader)
+	}
+	header := make([]byte, length)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return "", nil, nil, err
+	}
+
+	descr := npyDescr.FindSubmatch(header)
+	fortran := npyFortran.FindSubmatch(header)
+	tuple := npyShape.FindSubmatch(header)
+	if descr == nil || fortran == nil || tuple == nil {
+		return "", nil, nil, fmt.Errorf("invalid npy header %q", header)
+	}
+	if string(fortran[1]) == "True" {
+		return "", nil, nil, errors.New("fortran order npy files are not supported")
+	}
+	shape := []int{}
+	for _, dimension := range strings.Split(string(tuple[1]), ",") {
+		dimension = strings.TrimSpace(dimension)
+		if dimension == "" {
+			continue
+		}
+		value, err := strconv.Atoi(dimension)
+		if err != nil {
+			return "", nil, nil, err
+		}
+		shape = append(shape, value)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	return string(descr[1]), shape, data, nil
+}
+
+// WriteNPY writes a tensor in the npy format
+func WriteNPY(w io.Writer, t Tensor) error {
+	var descr string
+	switch t.DType {
+	case F32:
+		descr = "<f4"
+	case F64:
+		descr = "<f8"
+	default:
+		return fmt.Errorf("type %s is not supported by npy", t.DType)
+	}
+	if len(t.Data) != t.Elements()*t.DType.Size() {
+		return fmt.Errorf("tensor %s has %d bytes for shape %v", t.Name, len(t.Data), t.Shape)
+	}
+	return writeNPY(w, descr, t.Shape, t.Data)
+}
+
+// ReadNPY reads a tensor in the npy format
+func ReadNPY(r io.Reader, name string) (Tensor, error) {
+	descr, shape, data, err := readNPY(r)
+	if err != nil {
+		return Tensor{}, err
+	}
+	t := Tensor{
+		Name:  name,
+		Shape: shape,
+	}
+	switch descr {
+	case "<f4":
+		t.DType = F32
+	case "<f8":
+		t.DType = F64
+	default:
+		return Tensor{}, fmt.Errorf("npy type %s is not supported", descr)
+	}
+	if err := t.check(int64(len(data))); err != nil {
+		return Tensor{}, err
+	}
+	t.Data = data
+	return t, nil
+}
+
+// WriteMatrix writes a matrix as a rows by cols npy array
+func WriteMatrix(w io.Writer, m f32.Matrix) error {
+	return WriteNPY(w, NewF32("", []int{m.Rows, m.Cols}, m.Data))
+}
+
+// ReadMatrix reads a two dimensional float32 npy array as a matrix
+func ReadMatrix(r io.Reader) (f32.Matrix, error) {
+	t, err := ReadNPY(r, "")
+	if err != nil {
+		return f32.Matrix{}, err
+	}
+	if len(t.Shape) != 2 {
+		return f32.Matrix{}, fmt.Errorf("npy array has shape %v not two dimensions", t.Shape)
+	}
+	values, err := t.Float32()
+	if err != nil {
+		return f32.Matrix{}, err
+	}
+	m := f32.NewMatrix(0, t.Shape[1], t.Shape[0])
+	m.Data = append(m.Data, values...)
+	return m, nil
+}
+
+// WriteNPZ writes the model as a npz archive with one npy file per tensor
+// The architecture, config, tokenizer and metadata are stored as the JSON bytes
+// of the uint8 array __header__
+func WriteNPZ(w io.Writer, m *Model) error {
+	archive := zip.NewWriter(w)
+	header, err := json.Marshal(Header{
+		Architecture: m.Architecture,
+		Config:       m.Config,
+		Tokenizer:    m.Tokenizer,
+		Metadata:     m.Metadata,
+	})
+	if err != nil {
+		return err
+	}
+	file, err := archive.Create(npzHeader + ".npy")
+	if err != nil {
+		return err
+	}
+	if err := writeNPY(file, "|u1", []int{len(header)}, header); err != nil {
+		return err
+	}
+	for _, t := range m.Tensors {
+		if t.Name == npzHeader {
+			return fmt.Errorf("tensor name %s is reserved", t.Name)
+		}
+		file, err := archive.Create(t.Name + ".npy")
+		if err != nil {
+			return err
+		}
+		if err := WriteNPY(file, t); err != nil {
+			return err
+		}
+	}
+	return archive.Close()
+}
+
+// ReadNPZ reads a npz archive, arrays other than __header__ become tensors
+func ReadNPZ(r io.ReaderAt, size int64) (*Model, error) {
+	archive, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+	m := &Model{
+		Config:   make(map[string]int),
+		Metadata: make(map[string]string),
+	}
+	for _, file := range archive.File {
+		if !strings.HasSuffix(file.Name, ".npy") {
+			continue
+		}
+		name := strings.TrimSuffix(file.Name, ".npy")
+		input, err := file.Open()
+		if err != nil {
+			return nil, err
+		}
+		if name == npzHeader {
+			_, _, data, err := readNPY(input)
+			input.Close()
+			if err != nil {
+				return nil, err
+			}
+			var header Header
+			if err := json.Unmarshal(data, &header); err != nil {
+				return nil, err
+			}
+			m.Architecture, m.Tokenizer = header.Architecture, header.Tokenizer
+			for key, value := range header.Config {
+				m.Config[key] = value
+			}
+			for key, value := range header.Metadata {
+				m.Metadata[key] = value
+			}
+			continue
+		}
+		t, err := ReadNPY(input, name)
+		input.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file.Name, err)
+		}
+		m.Tensors = append(m.Tensors, t)
+	}
+	return m, nil
+}
+
+// LoadAny loads a model from a .safetensors, .npz or model file
+func LoadAny(name string) (*Model, error) {
+	switch {
+	case strings.HasSuffix(name, ".safetensors"):
+		data, err := os.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		return ReadSafetensors(bytes.NewReader(data))
+	case strings.HasSuffix(name, ".npz"):
+		data, err := os.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		return ReadNPZ(bytes.NewReader(data), int64(len(data)))
+	}
+	return Load(name)
+}
+
+// SaveAny saves a model to a .safetensors, .npz or model file
+func SaveAny(name string, m *Model) error {
+	switch {
+	case strings.HasSuffix(name, ".safetensors"):
+		return writeFile(name, func(w io.Writer) error {
+			return WriteSafetensors(w, m)
+		})
+	case strings.HasSuffix(name, ".npz"):
+		return writeFile(name, func(w io.Writer) error {
+			return WriteNPZ(w, m)
+		})
+	}
+	return Save(name, m)
+}
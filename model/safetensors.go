@@ -0,0 +1,234 @@
+// Copyright 2023 The RNN Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package model
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/pointlander/rnn/tokenizer"
+)
+
+// https://github.com/huggingface/safetensors
+
+const (
+	// MetadataKey is the safetensors key of the string metadata
+	MetadataKey = "__metadata__"
+	// metadataArchitecture is the metadata key of the architecture
+	metadataArchitecture = "rnn.architecture"
+	// metadataConfig is the metadata key of the JSON encoded config
+	metadataConfig = "rnn.config"
+	// metadataTokenizer is the metadata key of the JSON encoded tokenizer
+	metadataTokenizer = "rnn.tokenizer"
+)
+
+// safetensor is the header entry of a tensor
+type safetensor struct {
+	DType       string   `json:"dtype"`
+	Shape       []int    `json:"shape"`
+	DataOffsets [2]int64 `json:"data_offsets"`
+}
+
+func safetensorsDType(d DType) (string, error) {
+	switch d {
+	case F32:
+		return "F32", nil
+	case F64:
+		return "F64", nil
+	}
+	return "", fmt.Errorf("type %s is not supported by safetensors", d)
+}
+
+// encodeMetadata flattens the architecture, config and tokenizer into string metadata
+func encodeMetadata(m *Model) (map[string]string, error) {
+	metadata := make(map[string]string, len(m.Metadata)+3)
+	for key, value := range m.Metadata {
+		metadata[key] = value
+	}
+	metadata[metadataArchitecture] = m.Architecture
+	config, err := json.Marshal(m.Config)
+	if err != nil {
+		return nil, err
+	}
+	metadata[metadataConfig] = string(config)
+	if m.Tokenizer != nil {
+		vocabulary, err := json.Marshal(m.Tokenizer)
+		if err != nil {
+			return nil, err
+		}
+		metadata[metadataTokenizer] = string(vocabulary)
+	}
+	return metadata, nil
+}
+
+// decodeMetadata restores the architecture, config and tokenizer from string metadata
+func decodeMetadata(m *Model, metadata map[string]string) error {
+	for key, value := range metadata {
+		switch key {
+		case metadataArchitecture:
+			m.Architecture = value
+		case metadataConfig:
+			if err := json.Unmarshal([]byte(value), &m.Config); err != nil {
+				return err
+			}
+		case metadataTokenizer:
+			m.Tokenizer = &tokenizer.Vocabulary{}
+			if err := json.Unmarshal([]byte(value), m.Tokenizer); err != nil {
+				return err
+			}
+		default:
+			m.Metadata[key] = value
+		}
+	}
+	return nil
+}
+
+// WriteSafetensors writes the model in the safetensors format
+func WriteSafetensors(w io.Writer, m *Model) error {
+	header := make(map[string]interface{}, len(m.Tensors)+1)
+	metadata, err := encodeMetadata(m)
+	if err != nil {
+		return err
+	}
+	header[MetadataKey] = metadata
+	offset := int64(0)
+	for _, t := range m.Tensors {
+		if t.Name == MetadataKey {
+			return fmt.Errorf("tensor name %s is reserved", t.Name)
+		}
+		dtype, err := safetensorsDType(t.DType)
+		if err != nil {
+			return err
+		}
+		if len(t.Data) != t.Elements()*t.DType.Size() {
+			return fmt.Errorf("tensor %s has %d bytes for shape %v", t.Name, len(t.Data), t.Shape)
+		}
+		shape := t.Shape
+		if shape == nil {
+			shape = []int{}
+		}
+		header[t.Name] = safetensor{
+			DType:       dtype,
+			Shape:       shape,
+			DataOffsets: [2]int64{offset, offset + int64(len(t.Data))},
+		}
+		offset += int64(len(t.Data))
+	}
+	encoded, err := json.Marshal(header)
+	if err != nil {
+		return err
+	}
+	// pad the header with spaces so the data is 8 byte aligned
+	if padding := len(encoded) % 8; padding != 0 {
+		encoded = append(encoded, []byte(strings.Repeat(" ", 8-padding))...)
+	}
+
+	output := bufio.NewWriter(w)
+	size := make([]byte, 8)
+	binary.LittleEndian.PutUint64(size, uint64(len(encoded)))
+	for _, data := range [][]byte{size, encoded} {
+		if _, err := output.Write(data); err != nil {
+			return err
+		}
+	}
+	for _, t := range m.Tensors {
+		if _, err := output.Write(t.Data); err != nil {
+			return err
+		}
+	}
+	return output.Flush()
+}
+
+// ReadSafetensors reads a model in the safetensors format
+func ReadSafetensors(r io.Reader) (*Model, error) {
+	input := bufio.NewReader(r)
+	size := make([]byte, 8)
+	if _, err := io.ReadFull(input, size); err != nil {
+		return nil, err
+	}
+	length := binary.LittleEndian.Uint64(size)
+	if length > MaxHeader {
+		return nil, errors.New("safetensors header is too large")
+	}
+	encoded := make([]byte, length)
+	if _, err := io.ReadFull(input, encoded); err != nil {
+		return nil, err
+	}
+	var header map[string]json.RawMessage
+	if err := json.Unmarshal(encoded, &header); err != nil {
+		return nil, err
+	}
+
+	m := &Model{
+		Config:   make(map[string]int),
+		Metadata: make(map[string]string),
+	}
+	type entry struct {
+		Name string
+		safetensor
+	}
+	entries := make([]entry, 0, len(header))
+	for name, raw := range header {
+		if name == MetadataKey {
+			var metadata map[string]string
+			if err := json.Unmarshal(raw, &metadata); err != nil {
+				return nil, err
+			}
+			if err := decodeMetadata(m, metadata); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		e := entry{Name: name}
+		if err := json.Unmarshal(raw, &e.safetensor); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].DataOffsets[0] < entries[j].DataOffsets[0]
+	})
+
+	offset := int64(0)
+	for _, e := range entries {
+		t := Tensor{
+			Name:  e.Name,
+			Shape: e.Shape,
+		}
+		switch e.DType {
+		case "F32":
+			t.DType = F32
+		case "F64":
+			t.DType = F64
+		default:
+			return nil, fmt.Errorf("tensor %s has unsupported type %s", e.Name, e.DType)
+		}
+		begin, end := e.DataOffsets[0], e.DataOffsets[1]
+		if begin < offset {
+			return nil, fmt.Errorf("tensor %s has an invalid layout", e.Name)
+		}
+		if err := t.check(end - begin); err != nil {
+			return nil, err
+		}
+		if _, err := io.CopyN(io.Discard, input, begin-offset); err != nil {
+			return nil, err
+		}
+		var data bytes.Buffer
+		if _, err := io.CopyN(&data, input, end-begin); err != nil {
+			return nil, fmt.Errorf("tensor %s: %w", e.Name, err)
+		}
+		t.Data = data.Bytes()
+		m.Tensors = append(m.Tensors, t)
+		offset = end
+	}
+	return m, nil
+}
@@ -7,40 +7,92 @@ package quanta
 import (
 	"fmt"
 	"math/rand"
+	"strings"
 	"time"
 
 	. "github.com/pointlander/rnn/matrix/u64"
 )
 
+// Config are the parameters of the quanta experiments
+type Config struct {
+	Seed       int64
+	Width      int
+	Iterations int
+}
+
+// DefaultConfig is the default configuration of Learn
+var DefaultConfig = Config{
+	Seed:       1,
+	Width:      256,
+	Iterations: 1e6,
+}
+
+// DefaultTestConfig is the default configuration of Test
+var DefaultTestConfig = Config{
+	Seed:       1,
+	Width:      1024,
+	Iterations: 128,
+}
+
+// Result is the result of Learn
+type Result struct {
+	Config   Config
+	Output   []uint64
+	Bits     int
+	Elapsed  time.Duration
+	PerLayer time.Duration
+}
+
 // Learn learns a model
-func Learn() {
-	rng := rand.New(rand.NewSource(1))
-	layer := NewMatrix(256, 256)
-	for i := 0; i < 256*256/64; i++ {
+func Learn(config Config) Result {
+	rng := rand.New(rand.NewSource(config.Seed))
+	width := config.Width
+	layer := NewMatrix(width, width)
+	for i := 0; i < width*width/64; i++ {
 		layer.Data = append(layer.Data, rng.Uint64())
 	}
-	bias := NewMatrix(1, 256)
-	for i := 0; i < 256/64; i++ {
+	bias := NewMatrix(1, width)
+	for i := 0; i < width/64; i++ {
 		bias.Data = append(bias.Data, rng.Uint64())
 	}
-	input := NewMatrix(256, 1)
-	for i := 0; i < 256/64; i++ {
+	input := NewMatrix(width, 1)
+	for i := 0; i < width/64; i++ {
 		input.Data = append(input.Data, rng.Uint64())
 	}
 	output := Layer(layer, input, bias)
-	fmt.Println(output.Data)
-	fmt.Println(len(output.Data) * 64)
+	result := Result{
+		Config: config,
+		Output: output.Data,
+		Bits:   len(output.Data) * 64,
+	}
 	start := time.Now()
-	for i := 0; i < 1e6; i++ {
+	for i := 0; i < config.Iterations; i++ {
 		Layer(layer, input, bias)
 	}
-	fmt.Println("bench", time.Since(start))
+	result.Elapsed = time.Since(start)
+	if config.Iterations > 0 {
+		result.PerLayer = result.Elapsed / time.Duration(config.Iterations)
+	}
+	return result
+}
+
+// String is the report of the result
+func (r Result) String() string {
+	return fmt.Sprintf("%v\n%d\nbench %v %v per layer\n", r.Output, r.Bits, r.Elapsed, r.PerLayer)
+}
+
+// TestResult is the result of Test
+type TestResult struct {
+	Config Config
+	Neuron []int8
+	Sums   []int
+	Count  int
 }
 
 // Test tests an idea
-func Test() {
-	const size = 1024
-	rng := rand.New(rand.NewSource(1))
+func Test(config Config) TestResult {
+	size := config.Width
+	rng := rand.New(rand.NewSource(config.Seed))
 	type Random struct {
 		Mean   float64
 		Stddev float64
@@ -61,8 +113,12 @@ func Test() {
 			neuron[i] = -1
 		}
 	}
-	count := 0
-	for i := 0; i < 128; i++ {
+	result := TestResult{
+		Config: config,
+		Neuron: neuron,
+		Sums:   make([]int, 0, config.Iterations),
+	}
+	for i := 0; i < config.Iterations; i++ {
 		sum := 0
 		for j := range neuron {
 			if rng.Intn(2) == 0 {
@@ -71,12 +127,21 @@ func Test() {
 				sum += int(neuron[j]) * -1
 			}
 		}
-		fmt.Println(sum)
+		result.Sums = append(result.Sums, sum)
 		if sum > 0 {
-			count++
+			result.Count++
 		}
 	}
-	fmt.Println(random)
-	fmt.Println(neuron)
-	fmt.Println("count", count)
+	return result
+}
+
+// String is the report of the result
+func (r TestResult) String() string {
+	var report strings.Builder
+	for _, sum := range r.Sums {
+		fmt.Fprintln(&report, sum)
+	}
+	fmt.Fprintln(&report, r.Neuron)
+	fmt.Fprintln(&report, "count", r.Count)
+	return report.String()
 }
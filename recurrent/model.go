@@ -0,0 +1,216 @@
+// Copyright 2023 The RNN Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package recurrent
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+
+	. "github.com/pointlander/rnn/matrix/f32"
+	"github.com/pointlander/rnn/model"
+	"github.com/pointlander/rnn/tokenizer"
+)
+
+// Architecture is the architecture name of the model files
+const Architecture = "recurrent"
+
+// Model converts the network and its tokenizer into a model
+func (n Network) Model(t tokenizer.Tokenizer) *model.Model {
+	m := model.New(Architecture)
+	m.Config["width"] = Width
+	m.Config["symbols"] = n.DecoderWeights.Rows
+	m.SetTokenizer(t)
+	m.Metadata["loss"] = strconv.FormatFloat(n.Loss, 'g', -1, 64)
+	m.AddMatrix("Embedding", n.Embedding.Matrix)
+	m.AddMatrix("EncoderWeights", n.EncoderWeights)
+	m.AddMatrix("EncoderBias", n.EncoderBias)
+	m.AddMatrix("DecoderWeights", n.DecoderWeights)
+	m.AddMatrix("DecoderBias", n.DecoderBias)
+	return m
+}
+
+// NewNetwork creates a network and its tokenizer from a model
+func NewNetwork(m *model.Model) (Network, tokenizer.Tokenizer, error) {
+	var n Network
+	err := m.Check(Architecture, map[string]int{"width": Width})
+	if err != nil {
+		return n, nil, err
+	}
+	t, err := m.GetTokenizer()
+	if err != nil {
+		return n, nil, err
+	}
+	symbols := m.Config["symbols"]
+	if symbols != t.Size() {
+		return n, nil, fmt.Errorf("model has %d symbols but the tokenizer has %d", symbols, t.Size())
+	}
+	embedding, err := m.Matrix("Embedding", Width, symbols)
+	if err != nil {
+		return n, nil, err
+	}
+	n.Embedding = Embedding{Matrix: embedding}
+	if n.EncoderWeights, err = m.Matrix("EncoderWeights", EncoderCols, EncoderRows); err != nil {
+		return n, nil, err
+	}
+	if n.EncoderBias, err = m.Matrix("EncoderBias", 1, EncoderRows); err != nil {
+		return n, nil, err
+	}
+	if n.DecoderWeights, err = m.Matrix("DecoderWeights", DecoderCols, symbols); err != nil {
+		return n, nil, err
+	}
+	if n.DecoderBias, err = m.Matrix("DecoderBias", 1, symbols); err != nil {
+		return n, nil, err
+	}
+	if loss, ok := m.Metadata["loss"]; ok {
+		n.Loss, _ = strconv.ParseFloat(loss, 64)
+	}
+	return n, t, nil
+}
+
+// Save saves the network and its tokenizer with training metadata
+func (n Network) Save(name string, t tokenizer.Tokenizer, metadata map[string]string) error {
+	m := n.Model(t)
+	for key, value := range metadata {
+		m.Metadata[key] = value
+	}
+	return model.Save(name, m)
+}
+
+// Load loads a network and its tokenizer, migrating networks saved as bare gob
+func Load(name string) (Network, tokenizer.Tokenizer, error) {
+	m, err := model.Load(name)
+	if errors.Is(err, model.ErrNotModel) {
+		return loadGob(name)
+	} else if err != nil {
+		return Network{}, nil, err
+	}
+	return NewNetwork(m)
+}
+
+// loadGob migrates a byte level network saved as gob with a one-hot encoder input
+func loadGob(name string) (Network, tokenizer.Tokenizer, error) {
+	var n Network
+	input, err := os.Open(name)
+	if err != nil {
+		return n, nil, err
+	}
+	defer input.Close()
+	var legacy struct {
+		EncoderWeights Matrix
+		EncoderBias    Matrix
+		DecoderWeights Matrix
+		DecoderBias    Matrix
+		Loss           float64
+	}
+	err = gob.NewDecoder(input).Decode(&legacy)
+	if err != nil {
+		return n, nil, fmt.Errorf("%s is neither a model nor a gob network: %w", name, err)
+	}
+	const symbols = 256
+	if legacy.EncoderWeights.Cols != Width+symbols || legacy.EncoderWeights.Rows != EncoderRows ||
+		legacy.DecoderWeights.Cols != DecoderCols || legacy.DecoderWeights.Rows != symbols ||
+		len(legacy.EncoderBias.Data) != EncoderRows || len(legacy.DecoderBias.Data) != symbols {
+		return n, nil, fmt.Errorf("%s is not a recurrent network", name)
+	}
+
+	// the one-hot input was -1 for every symbol and 1 for the current symbol,
+	// so its contribution is twice the symbol column minus the sum of the columns
+	cols := legacy.EncoderWeights.Cols
+	n.Embedding = NewEmbedding(0, Width, symbols)
+	n.Embedding.Data = n.Embedding.Data[:Width*symbols]
+	n.EncoderWeights = NewMatrix(0, EncoderCols, EncoderRows)
+	for i := 0; i < EncoderRows; i++ {
+		row := legacy.EncoderWeights.Data[i*cols : (i+1)*cols]
+		n.EncoderWeights.Data = append(n.EncoderWeights.Data, row[:Width]...)
+		sum := float32(0.0)
+		for _, value := range row[Width:] {
+			sum += value
+		}
+		for s, value := range row[Width:] {
+			n.Embedding.Data[s*Width+i] = 2*value - sum
+		}
+	}
+	n.EncoderBias = legacy.EncoderBias
+	n.DecoderWeights = legacy.DecoderWeights
+	n.DecoderBias = legacy.DecoderBias
+	n.Loss = legacy.Loss
+	return n, tokenizer.Byte{}, nil
+}
+
+// DistributionArchitecture is the architecture name of the distribution files
+const DistributionArchitecture = Architecture + ".distribution"
+
+// Model converts the distribution and its tokenizer into a model
+func (d Distribution) Model(t tokenizer.Tokenizer) *model.Model {
+	m := model.New(DistributionArchitecture)
+	m.SetTokenizer(t)
+	m.Config["width"] = Width
+	m.Config["symbols"] = d.Symbols
+	m.AddRandom("Embedding", d.Symbols, Width, d.Embedding)
+	m.AddRandom("EncoderWeights", EncoderRows, EncoderCols, d.EncoderWeights)
+	m.AddRandom("EncoderBias", EncoderRows, 1, d.EncoderBias)
+	m.AddRandom("DecoderWeights", d.Symbols, DecoderCols, d.DecoderWeights)
+	m.AddRandom("DecoderBias", d.Symbols, 1, d.DecoderBias)
+	return m
+}
+
+// DistributionFromModel creates a distribution and its tokenizer from a model
+func DistributionFromModel(m *model.Model) (Distribution, tokenizer.Tokenizer, error) {
+	d, err := distributionFromModel(m)
+	if err != nil {
+		return d, nil, err
+	}
+	t, err := m.GetTokenizer()
+	if err != nil {
+		return d, nil, err
+	}
+	if t.Size() != d.Symbols {
+		return d, nil, fmt.Errorf("distribution has %d symbols but the tokenizer has %d", d.Symbols, t.Size())
+	}
+	return d, t, nil
+}
+
+// Save saves the distribution and its tokenizer
+func (d Distribution) Save(name string, t tokenizer.Tokenizer) error {
+	return model.Save(name, d.Model(t))
+}
+
+// LoadDistribution loads a distribution and its tokenizer
+func LoadDistribution(name string) (Distribution, tokenizer.Tokenizer, error) {
+	m, err := model.Load(name)
+	if err != nil {
+		return Distribution{}, nil, err
+	}
+	return DistributionFromModel(m)
+}
+
+func distributionFromModel(m *model.Model) (Distribution, error) {
+	d := Distribution{
+		Symbols: m.Config["symbols"],
+	}
+	err := m.Check(DistributionArchitecture, map[string]int{"width": Width})
+	if err != nil {
+		return d, err
+	}
+	if d.Embedding, err = m.GetRandom("Embedding", d.Symbols, Width); err != nil {
+		return d, err
+	}
+	if d.EncoderWeights, err = m.GetRandom("EncoderWeights", EncoderRows, EncoderCols); err != nil {
+		return d, err
+	}
+	if d.EncoderBias, err = m.GetRandom("EncoderBias", EncoderRows, 1); err != nil {
+		return d, err
+	}
+	if d.DecoderWeights, err = m.GetRandom("DecoderWeights", d.Symbols, DecoderCols); err != nil {
+		return d, err
+	}
+	if d.DecoderBias, err = m.GetRandom("DecoderBias", d.Symbols, 1); err != nil {
+		return d, err
+	}
+	return d, nil
+}
@@ -5,56 +5,60 @@
 package recurrent
 
 import (
-	"compress/gzip"
-	"encoding/gob"
+	"context"
 	"fmt"
-	"io/ioutil"
 	"math"
 	"math/rand"
-	"os"
-	"runtime"
 	"sort"
+	"strconv"
+	"time"
 
+	"github.com/pointlander/rnn/corpus"
+	"github.com/pointlander/rnn/ensemble"
 	. "github.com/pointlander/rnn/matrix/f32"
+	"github.com/pointlander/rnn/model"
+	"github.com/pointlander/rnn/tokenizer"
+	"github.com/pointlander/rnn/train"
 )
 
 const (
 	// Width is the width of the network
 	Width = 256
-	// Offset is the offset
-	Offset = Width
 	// EncoderCols is the number of encoder columns
-	EncoderCols = Width + 256
+	EncoderCols = Width
 	// EncoderRows is the number of encoder rows
 	EncoderRows = Width
 	// EncoderSize is the number of encoder parameters
 	EncoderSize = EncoderCols * EncoderRows
 	// DecoderCols is the number of decoder columns
 	DecoderCols = Width
-	// DecoderRows is the number of decoder rows
-	DecoderRows = 256
-	// DecoderSize is the number of decoder parameters
-	DecoderSize = DecoderCols * DecoderRows
 )
 
 // Random is a random variable
-type Random struct {
-	Mean   float64
-	Stddev float64
-}
+type Random = model.Random
 
 // Distribution is a distribution of a neural network
 type Distribution struct {
+	Symbols        int
+	Embedding      []Random
 	EncoderWeights []Random
 	EncoderBias    []Random
 	DecoderWeights []Random
 	DecoderBias    []Random
 }
 
-// NewDistribution creates a new distribution
-func NewDistribution(rng *rand.Rand) Distribution {
-	var d Distribution
+// NewDistribution creates a new distribution for a vocabulary of symbols
+func NewDistribution(rng *rand.Rand, symbols int) Distribution {
+	d := Distribution{
+		Symbols: symbols,
+	}
 	factor := math.Sqrt(2.0 / float64(EncoderCols))
+	for i := 0; i < symbols*Width; i++ {
+		d.Embedding = append(d.Embedding, Random{
+			Mean:   factor * rng.NormFloat64(),
+			Stddev: factor * rng.NormFloat64(),
+		})
+	}
 	for i := 0; i < EncoderSize; i++ {
 		d.EncoderWeights = append(d.EncoderWeights, Random{
 			Mean:   factor * rng.NormFloat64(),
@@ -68,13 +72,13 @@ func NewDistribution(rng *rand.Rand) Distribution {
 		})
 	}
 	factor = math.Sqrt(2.0 / float64(DecoderCols))
-	for i := 0; i < DecoderSize; i++ {
+	for i := 0; i < DecoderCols*symbols; i++ {
 		d.DecoderWeights = append(d.DecoderWeights, Random{
 			Mean:   factor * rng.NormFloat64(),
 			Stddev: factor * rng.NormFloat64(),
 		})
 	}
-	for i := 0; i < DecoderRows; i++ {
+	for i := 0; i < symbols; i++ {
 		d.DecoderBias = append(d.DecoderBias, Random{
 			Mean:   factor * rng.NormFloat64(),
 			Stddev: factor * rng.NormFloat64(),
@@ -85,6 +89,7 @@ func NewDistribution(rng *rand.Rand) Distribution {
 
 // Network is a neural network
 type Network struct {
+	Embedding      Embedding
 	EncoderWeights Matrix
 	EncoderBias    Matrix
 	DecoderWeights Matrix
@@ -92,9 +97,29 @@ type Network struct {
 	Loss           float64
 }
 
+// Stddev returns the mean standard deviation of the random variables of the distribution
+func (d Distribution) Stddev() float64 {
+	sum, count := 0.0, 0
+	for _, randoms := range [][]Random{d.Embedding, d.EncoderWeights, d.EncoderBias, d.DecoderWeights, d.DecoderBias} {
+		for _, r := range randoms {
+			sum += r.Stddev
+		}
+		count += len(randoms)
+	}
+	if count == 0 {
+		return 0
+	}
+	return sum / float64(count)
+}
+
 // Sample samples a network from the distribution
 func (d Distribution) Sample(rng *rand.Rand) Network {
 	var n Network
+	n.Embedding = NewEmbedding(0, Width, d.Symbols)
+	for i := 0; i < d.Symbols*Width; i++ {
+		r := d.Embedding[i]
+		n.Embedding.Data = append(n.Embedding.Data, float32(rng.NormFloat64()*r.Stddev+r.Mean))
+	}
 	n.EncoderWeights = NewMatrix(0, EncoderCols, EncoderRows)
 	n.EncoderBias = NewMatrix(0, 1, EncoderRows)
 	for i := 0; i < EncoderSize; i++ {
@@ -105,13 +130,13 @@ func (d Distribution) Sample(rng *rand.Rand) Network {
 		r := d.EncoderBias[i]
 		n.EncoderBias.Data = append(n.EncoderBias.Data, float32(rng.NormFloat64()*r.Stddev+r.Mean))
 	}
-	n.DecoderWeights = NewMatrix(0, DecoderCols, DecoderRows)
-	n.DecoderBias = NewMatrix(0, 1, DecoderRows)
-	for i := 0; i < DecoderSize; i++ {
+	n.DecoderWeights = NewMatrix(0, DecoderCols, d.Symbols)
+	n.DecoderBias = NewMatrix(0, 1, d.Symbols)
+	for i := 0; i < DecoderCols*d.Symbols; i++ {
 		r := d.DecoderWeights[i]
 		n.DecoderWeights.Data = append(n.DecoderWeights.Data, float32(rng.NormFloat64()*r.Stddev+r.Mean))
 	}
-	for i := 0; i < DecoderRows; i++ {
+	for i := 0; i < d.Symbols; i++ {
 		r := d.DecoderBias[i]
 		n.DecoderBias.Data = append(n.DecoderBias.Data, float32(rng.NormFloat64()*r.Stddev+r.Mean))
 	}
@@ -119,124 +144,241 @@ func (d Distribution) Sample(rng *rand.Rand) Network {
 	return n
 }
 
+// step feeds a symbol into the state and returns the decoder output
+func (n *Network) step(state Matrix, symbol int) Matrix {
+	output := Step(Add(Add(MulT(n.EncoderWeights, state), n.Embedding.Lookup(symbol)), n.EncoderBias))
+	copy(state.Data, output.Data)
+	return Add(MulT(n.DecoderWeights, output), n.DecoderBias)
+}
+
+// State is the state of a network reading a sequence of symbols
+type State struct {
+	Network *Network
+	Hidden  Matrix
+}
+
+// NewState creates the state of the network at the start of a sequence
+func (n *Network) NewState() *State {
+	hidden := NewMatrix(0, EncoderCols, 1)
+	hidden.Data = hidden.Data[:EncoderCols]
+	return &State{
+		Network: n,
+		Hidden:  hidden,
+	}
+}
+
+// Next feeds a symbol into the state and returns the probabilities of the next symbol
+func (s *State) Next(symbol int) []float32 {
+	return TaylorSoftmax(s.Network.step(s.Hidden, symbol)).Data
+}
+
+// Scratch is the inference buffers of a worker, they are reused across the networks it evaluates
+type Scratch struct {
+	Rand     *rand.Rand
+	State    Matrix
+	Expected []float64
+}
+
+// NewScratch creates the inference buffers of a worker
+func NewScratch() *Scratch {
+	state := NewMatrix(0, EncoderCols, 1)
+	state.Data = state.Data[:EncoderCols]
+	return &Scratch{
+		Rand:  rand.New(rand.NewSource(1)),
+		State: state,
+	}
+}
+
 // Inference run inference on the network
-func (n *Network) Inference(data []byte) {
-	rng := rand.New(rand.NewSource(1))
+func (n *Network) Inference(data []int) {
+	n.Evaluate(data, NewScratch())
+}
+
+// Evaluate runs inference on the network with the buffers of a worker
+func (n *Network) Evaluate(data []int, s *Scratch) {
+	rng := s.Rand
+	rng.Seed(1)
+	symbols := n.DecoderWeights.Rows
+	if len(s.Expected) != symbols {
+		s.Expected = make([]float64, symbols)
+	}
 	loss := 0.0
 	for i := 0; i < 1024; i++ {
 		begin := rng.Intn(len(data) - 1024)
 		end := begin + 1024
 		data := data[begin:end]
-		state := NewMatrix(0, EncoderCols, 1)
-		state.Data = state.Data[:EncoderCols]
+		state := s.State
+		for i := range state.Data {
+			state.Data[i] = 0
+		}
+		expected := s.Expected
 		for i, symbol := range data[:len(data)-1] {
-			for i := 0; i < 256; i++ {
-				state.Data[Offset+i] = -1
+			direct := n.step(state, symbol)
+			for i := range expected {
+				expected[i] = 0
 			}
-			state.Data[Offset+int(symbol)] = 1
-			output := Step(Add(MulT(n.EncoderWeights, state), n.EncoderBias))
-			copy(state.Data[:Offset], output.Data)
-			direct := Add(MulT(n.DecoderWeights, output), n.DecoderBias)
-			expected := make([]float64, 256)
-			expected[int(data[i+1])] = 1
+			expected[data[i+1]] = 1
 			sum := 0.0
-			for i := 0; i < 256; i++ {
+			for i := 0; i < symbols; i++ {
 				diff := expected[i] - float64(direct.Data[i])
 				sum += diff * diff
 			}
-			loss += sum / 256
+			loss += sum / float64(symbols)
 		}
 	}
 	n.Loss = loss
 }
 
-// Learn learns the mode
-func Learn() {
-	rng := rand.New(rand.NewSource(1))
-	input, err := os.Open("pg10.txt.gz")
+// Defaults are the default options of Learn
+var Defaults = train.Options{
+	Seed:        1,
+	Population:  128,
+	Generations: 32,
+	Window:      8,
+	Elite:       64,
+	Data:        "pg10.txt.gz",
+	Output:      "recurrent.model",
+}
+
+// Trainer learns a network from text with a tokenizer
+type Trainer struct {
+	Tokenizer tokenizer.Tokenizer
+	Options   train.Options
+	Observer  train.Observer
+}
+
+// Learn learns the mode using the tokenizer t, if the context is done the best network
+// and the distribution so far are saved
+func Learn(ctx context.Context, t tokenizer.Tokenizer, options train.Options) {
+	options = options.Merge(Defaults)
+	text, err := corpus.Load(options.Data)
 	if err != nil {
 		panic(err)
 	}
-	defer input.Close()
-	reader, err := gzip.NewReader(input)
+	progress, err := train.NewProgress(options)
 	if err != nil {
 		panic(err)
 	}
-	defer reader.Close()
-	data, err := ioutil.ReadAll(reader)
+	defer progress.Close()
+	trainer := Trainer{
+		Tokenizer: t,
+		Options:   options,
+		Observer:  progress.Observe,
+	}
+	best, distribution, err := trainer.Train(ctx, text)
+	if err != nil && !train.Interrupted(err) {
+		panic(err)
+	}
+	if progress.Improved {
+		err = best.Save(options.Output, t, map[string]string{
+			"corpus":      options.Data,
+			"seed":        strconv.FormatInt(options.Seed, 10),
+			"population":  strconv.Itoa(options.Population),
+			"generations": strconv.Itoa(options.Generations),
+			"window":      strconv.Itoa(options.Window),
+			"created":     time.Now().UTC().Format(time.RFC3339),
+		})
+		if err != nil {
+			panic(err)
+		}
+	}
+	err = distribution.Save(options.Distribution(), t)
 	if err != nil {
 		panic(err)
 	}
+}
+
+// Train learns a network from the text, it returns the best network and the distribution
+// so far with the error of the context if the context is done
+func (t Trainer) Train(ctx context.Context, text []byte) (Network, Distribution, error) {
+	options := t.Options.Merge(Defaults)
+	if err := options.Validate(); err != nil {
+		return Network{}, Distribution{}, err
+	}
+	if t.Tokenizer == nil {
+		return Network{}, Distribution{}, fmt.Errorf("no tokenizer")
+	}
+	rng := rand.New(rand.NewSource(options.Seed))
+	data := t.Tokenizer.Encode(text)
 
-	distribution := NewDistribution(rng)
-	networks := make([]Network, 128)
+	distribution := NewDistribution(rng, t.Tokenizer.Size())
+	networks := make([]Network, options.Population)
 	best := Network{}
 	minLoss := math.MaxFloat64
-	done := make(chan bool, 8)
-	cpus := runtime.NumCPU()
-	inference := func(j int) {
-		networks[j].Inference(data)
-		done <- true
+	pool := train.NewPool(options.Workers, NewScratch)
+	defer pool.Close()
+	window := options.Window
+	inference := func(rng *rand.Rand, s *Scratch, j int) {
+		networks[j] = distribution.Sample(rng)
+		networks[j].Evaluate(data, s)
 	}
-	for i := 0; i < 32; i++ {
-		for j := range networks {
-			networks[j] = distribution.Sample(rng)
+	for i := 0; i < options.Generations; i++ {
+		if err := ctx.Err(); err != nil {
+			return best, distribution, err
 		}
-		k, flight := 0, 0
-		for j := 0; j < cpus && k < len(networks); j++ {
-			go inference(k)
-			flight++
-			k++
-		}
-		for k < len(networks) {
-			<-done
-			fmt.Printf(".")
-			flight--
-			go inference(k)
-			flight++
-			k++
+		if options.Snapshot > 0 && i%options.Snapshot == 0 {
+			err := distribution.Save(options.SnapshotName(i), t.Tokenizer)
+			if err != nil {
+				return best, distribution, err
+			}
 		}
-		for flight > 0 {
-			<-done
-			fmt.Printf(".")
-			flight--
+		err := pool.Run(ctx, len(networks), func(w *train.Worker[*Scratch], j int) error {
+			inference(w.Seed(train.Seed(options.Seed, i, j)), w.Scratch, j)
+			return nil
+		})
+		if err != nil {
+			return best, distribution, err
 		}
-		fmt.Printf("\n")
 		sort.Slice(networks, func(i, j int) bool {
 			return networks[i].Loss < networks[j].Loss
 		})
 		min, index := math.MaxFloat64, 0
-		for j := 0; j < 64-8; j++ {
+		for j := 0; j < options.Elite-window; j++ {
 			mean := 0.0
-			for k := 0; k < 8; k++ {
+			for k := 0; k < window; k++ {
 				mean += networks[j+k].Loss
 			}
-			mean /= 8
+			mean /= float64(window)
 			stddev := 0.0
-			for k := 0; k < 8; k++ {
+			for k := 0; k < window; k++ {
 				diff := mean - networks[j+k].Loss
 				stddev += diff * diff
 			}
-			stddev /= 8
+			stddev /= float64(window)
 			stddev = math.Sqrt(stddev)
 			if stddev < min {
 				min, index = stddev, j
 			}
 		}
+		losses := make([]float64, len(networks))
+		for j := range networks {
+			losses[j] = networks[j].Loss
+		}
+		stats := train.NewStats(i, losses)
+		stats.Spread = distribution.Stddev()
+		stats.Elite, stats.Stddev = index, min
 		if networks[index].Loss < minLoss {
 			best = networks[index]
 			minLoss = networks[index].Loss
-		} else {
+			stats.Improved = true
+		}
+		stats.Loss = minLoss
+		t.Observer.Observe(stats)
+		if !stats.Improved {
 			continue
 		}
-		fmt.Println(min, index, networks[index].Loss)
 		next := Distribution{
+			Symbols:        distribution.Symbols,
+			Embedding:      make([]Random, len(distribution.Embedding)),
 			EncoderWeights: make([]Random, len(distribution.EncoderWeights)),
 			EncoderBias:    make([]Random, len(distribution.EncoderBias)),
 			DecoderWeights: make([]Random, len(distribution.DecoderWeights)),
 			DecoderBias:    make([]Random, len(distribution.DecoderBias)),
 		}
-		for j := 0; j < 8; j++ {
+		for j := 0; j < window; j++ {
+			for k, value := range networks[index+j].Embedding.Data {
+				next.Embedding[k].Mean += float64(value)
+			}
 			for k, value := range networks[index+j].EncoderWeights.Data {
 				next.EncoderWeights[k].Mean += float64(value)
 			}
@@ -250,19 +392,26 @@ func Learn() {
 				next.DecoderBias[k].Mean += float64(value)
 			}
 		}
+		for j := range next.Embedding {
+			next.Embedding[j].Mean /= float64(window)
+		}
 		for j := range next.EncoderWeights {
-			next.EncoderWeights[j].Mean /= 8
+			next.EncoderWeights[j].Mean /= float64(window)
 		}
 		for j := range next.EncoderBias {
-			next.EncoderBias[j].Mean /= 8
+			next.EncoderBias[j].Mean /= float64(window)
 		}
 		for j := range next.DecoderWeights {
-			next.DecoderWeights[j].Mean /= 8
+			next.DecoderWeights[j].Mean /= float64(window)
 		}
 		for j := range next.DecoderBias {
-			next.DecoderBias[j].Mean /= 8
+			next.DecoderBias[j].Mean /= float64(window)
 		}
-		for j := 0; j < 8; j++ {
+		for j := 0; j < window; j++ {
+			for k, value := range networks[index+j].Embedding.Data {
+				diff := next.Embedding[k].Mean - float64(value)
+				next.Embedding[k].Stddev += diff * diff
+			}
 			for k, value := range networks[index+j].EncoderWeights.Data {
 				diff := next.EncoderWeights[k].Mean - float64(value)
 				next.EncoderWeights[k].Stddev += diff * diff
@@ -280,67 +429,67 @@ func Learn() {
 				next.DecoderBias[k].Stddev += diff * diff
 			}
 		}
+		for j := range next.Embedding {
+			next.Embedding[j].Stddev /= float64(window)
+			next.Embedding[j].Stddev = math.Sqrt(next.Embedding[j].Stddev)
+		}
 		for j := range next.EncoderWeights {
-			next.EncoderWeights[j].Stddev /= 8
+			next.EncoderWeights[j].Stddev /= float64(window)
 			next.EncoderWeights[j].Stddev = math.Sqrt(next.EncoderWeights[j].Stddev)
 		}
 		for j := range next.EncoderBias {
-			next.EncoderBias[j].Stddev /= 8
+			next.EncoderBias[j].Stddev /= float64(window)
 			next.EncoderBias[j].Stddev = math.Sqrt(next.EncoderBias[j].Stddev)
 		}
 		for j := range next.DecoderWeights {
-			next.DecoderWeights[j].Stddev /= 8
+			next.DecoderWeights[j].Stddev /= float64(window)
 			next.DecoderWeights[j].Stddev = math.Sqrt(next.DecoderWeights[j].Stddev)
 		}
 		for j := range next.DecoderBias {
-			next.DecoderBias[j].Stddev /= 8
+			next.DecoderBias[j].Stddev /= float64(window)
 			next.DecoderBias[j].Stddev = math.Sqrt(next.DecoderBias[j].Stddev)
 		}
 		distribution = next
 	}
-	output, err := os.Create("recurrent.gob")
-	if err != nil {
-		panic(err)
-	}
-	defer output.Close()
-	encoder := gob.NewEncoder(output)
-	err = encoder.Encode(best)
-	if err != nil {
-		panic(err)
-	}
+	return best, distribution, nil
 }
 
 // Infer inference mode
-func Infer() {
-	in, err := os.Open("recurrent.gob")
-	if err != nil {
-		panic(err)
-	}
-	defer in.Close()
-	decoder := gob.NewDecoder(in)
-	n := Network{}
-	err = decoder.Decode(&n)
-	if err != nil {
-		panic(err)
-	}
-
-	data := []byte{'G', 'o', 'd'}
+func Infer(n Network, t tokenizer.Tokenizer) {
+	data := t.Encode([]byte("God"))
 	state := NewMatrix(0, EncoderCols, 1)
 	state.Data = state.Data[:EncoderCols]
 	for _, symbol := range data {
-		for i := 0; i < 256; i++ {
-			state.Data[Offset+i] = -1
-		}
-		state.Data[Offset+int(symbol)] = 1
-		output := Step(Add(MulT(n.EncoderWeights, state), n.EncoderBias))
-		copy(state.Data[:Offset], output.Data)
-		direct := Add(MulT(n.DecoderWeights, output), n.DecoderBias)
+		direct := n.step(state, symbol)
 		max, index := 0.0, 0
 		for key, value := range direct.Data {
 			if float64(value) > max {
 				max, index = float64(value), key
 			}
 		}
-		fmt.Printf("'%c' %d\n", index, index)
+		fmt.Printf("%q %d\n", t.Decode([]int{index}), index)
+	}
+}
+
+// Ensemble runs inference with k networks sampled from the distribution, averaging
+// the next symbol probabilities and reporting their uncertainty
+func Ensemble(d Distribution, t tokenizer.Tokenizer, rng *rand.Rand, k int) {
+	data := t.Encode([]byte("God"))
+	networks := make([]Network, k)
+	states := make([]Matrix, k)
+	for i := range networks {
+		networks[i] = d.Sample(rng)
+		states[i] = NewMatrix(0, EncoderCols, 1)
+		states[i].Data = states[i].Data[:EncoderCols]
+	}
+	outputs := make([][]float32, k)
+	for _, symbol := range data {
+		for i := range networks {
+			outputs[i] = TaylorSoftmax(networks[i].step(states[i], symbol)).Data
+		}
+		prediction := ensemble.Predict(outputs)
+		index, max := prediction.Max()
+		fmt.Printf("%q %d %f entropy=%f variance=%f disagreement=%f\n", t.Decode([]int{index}), index,
+			max, prediction.Entropy, prediction.Variance[index], prediction.Disagreement())
 	}
 }
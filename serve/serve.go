@@ -0,0 +1,337 @@
+// Copyright 2023 The RNN Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package serve serves the language models and the classifiers over HTTP
+package serve
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pointlander/rnn/feedforward"
+	"github.com/pointlander/rnn/lm"
+	"github.com/pointlander/rnn/mlp"
+	"github.com/pointlander/rnn/model"
+)
+
+// MaxLength is the maximum number of symbols of a completion
+const MaxLength = 4096
+
+// Classifier is a classifier of features
+type Classifier struct {
+	Architecture string
+	Classes      []string
+	// Probabilities returns the class probabilities of the raw features
+	Probabilities func(features []float64) ([]float32, error)
+}
+
+// NewClassifier creates a classifier from a feedforward or mlp model
+func NewClassifier(m *model.Model) (Classifier, error) {
+	switch m.Architecture {
+	case feedforward.Architecture:
+		s, err := feedforward.SampleFromModel(m)
+		if err != nil {
+			return Classifier{}, err
+		}
+		return Classifier{
+			Architecture: m.Architecture,
+			Classes:      feedforward.Classes(),
+			Probabilities: func(features []float64) ([]float32, error) {
+				if len(features) != 4 {
+					return nil, fmt.Errorf("%d features not 4", len(features))
+				}
+				return s.Probabilities(features), nil
+			},
+		}, nil
+	case mlp.Architecture:
+		c, err := mlp.NewClassifier(m)
+		if err != nil {
+			return Classifier{}, err
+		}
+		return Classifier{
+			Architecture: m.Architecture,
+			Classes:      c.Classes,
+			Probabilities: func(features []float64) ([]float32, error) {
+				if len(features) != len(c.Columns) {
+					return nil, fmt.Errorf("%d features not %d", len(features), len(c.Columns))
+				}
+				input := make([]float32, len(features))
+				for i, feature := range features {
+					input[i] = float32(feature)
+				}
+				return c.Network.Probabilities(c.Scaler.Apply(input)), nil
+			},
+		}, nil
+	}
+	return Classifier{}, fmt.Errorf("%s is not a classifier", m.Architecture)
+}
+
+// File is a model file that is loaded again when it changes on disk
+type File struct {
+	Name     string
+	mutex    sync.Mutex
+	modified time.Time
+	value    interface{}
+	load     func(m *model.Model) (interface{}, error)
+}
+
+// NewFile creates a model file that is loaded with load
+func NewFile(name string, load func(m *model.Model) (interface{}, error)) *File {
+	return &File{
+		Name: name,
+		load: load,
+	}
+}
+
+// Get returns the loaded model, it keeps the last good model until the file changes again
+// if the file can not be loaded
+func (f *File) Get() (interface{}, error) {
+	if f == nil || f.Name == "" {
+		return nil, fmt.Errorf("no model")
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	info, err := os.Stat(f.Name)
+	if err == nil && info.ModTime().Equal(f.modified) && f.value != nil {
+		return f.value, nil
+	}
+	if err == nil {
+		var m *model.Model
+		if m, err = model.Load(f.Name); err == nil {
+			var value interface{}
+			if value, err = f.load(m); err == nil {
+				if f.value != nil {
+					log.Printf("reloaded %s", f.Name)
+				}
+				f.value, f.modified = value, info.ModTime()
+				return value, nil
+			}
+		}
+	}
+	if f.value != nil {
+		log.Printf("keeping the loaded %s: %v", f.Name, err)
+		if info != nil {
+			f.modified = info.ModTime()
+		}
+		return f.value, nil
+	}
+	return nil, err
+}
+
+// Server serves a language model and a classifier
+type Server struct {
+	Language   *File
+	Classifier *File
+	// Seed seeds the sampling of the requests without a seed, 0 seeds with the time
+	Seed  int64
+	mutex sync.Mutex
+	seed  int64
+}
+
+// NewServer creates a server for the language model and classifier files, either may be empty
+func NewServer(language, classifier string, seed int64) *Server {
+	return &Server{
+		Language: NewFile(language, func(m *model.Model) (interface{}, error) {
+			return lm.NewModel(m)
+		}),
+		Classifier: NewFile(classifier, func(m *model.Model) (interface{}, error) {
+			return NewClassifier(m)
+		}),
+		Seed: seed,
+		seed: seed,
+	}
+}
+
+// Handler returns the handler of the endpoints
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/complete", s.Complete)
+	mux.HandleFunc("/next", s.Next)
+	mux.HandleFunc("/classify", s.Classify)
+	return mux
+}
+
+// rand returns the random number generator of a request
+func (s *Server) rand(seed int64) *rand.Rand {
+	if seed != 0 {
+		return rand.New(rand.NewSource(seed))
+	}
+	if s.Seed == 0 {
+		return rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.seed++
+	return rand.New(rand.NewSource(s.seed))
+}
+
+// language returns the loaded language model
+func (s *Server) language() (lm.Model, error) {
+	value, err := s.Language.Get()
+	if err != nil {
+		return lm.Model{}, err
+	}
+	return value.(lm.Model), nil
+}
+
+// CompleteRequest is a request for a completion of a prompt
+type CompleteRequest struct {
+	Prompt string `json:"prompt"`
+	// Length is the number of symbols of the completion
+	Length int `json:"length"`
+	lm.Sampler
+	// Seed seeds the sampling, 0 for the seed of the server
+	Seed int64 `json:"seed"`
+}
+
+// CompleteResponse is the completion of a prompt
+type CompleteResponse struct {
+	Architecture string `json:"architecture"`
+	Completion   string `json:"completion"`
+}
+
+// Complete completes a prompt
+func (s *Server) Complete(w http.ResponseWriter, r *http.Request) {
+	var request CompleteRequest
+	if !decode(w, r, &request) {
+		return
+	}
+	if request.Length < 0 || request.Length > MaxLength {
+		fail(w, http.StatusBadRequest, fmt.Errorf("length %d is not in [0, %d]", request.Length, MaxLength))
+		return
+	}
+	m, err := s.language()
+	if err != nil {
+		fail(w, http.StatusServiceUnavailable, err)
+		return
+	}
+	completion, err := m.Complete(s.rand(request.Seed), []byte(request.Prompt), request.Length, request.Sampler)
+	if err != nil {
+		fail(w, http.StatusBadRequest, err)
+		return
+	}
+	encode(w, CompleteResponse{
+		Architecture: m.Architecture,
+		Completion:   string(completion),
+	})
+}
+
+// NextRequest is a request for the probabilities of the next symbol after a prompt
+type NextRequest struct {
+	Prompt string `json:"prompt"`
+	// TopK is the number of symbols returned, 0 for all of them
+	TopK int `json:"top_k"`
+}
+
+// NextResponse is the probabilities of the next symbol
+type NextResponse struct {
+	Architecture string      `json:"architecture"`
+	Symbols      []lm.Symbol `json:"symbols"`
+}
+
+// Next returns the probabilities of the next symbol after a prompt
+func (s *Server) Next(w http.ResponseWriter, r *http.Request) {
+	var request NextRequest
+	if !decode(w, r, &request) {
+		return
+	}
+	m, err := s.language()
+	if err != nil {
+		fail(w, http.StatusServiceUnavailable, err)
+		return
+	}
+	symbols := m.Tokenizer.Encode([]byte(request.Prompt))
+	if len(symbols) == 0 {
+		fail(w, http.StatusBadRequest, fmt.Errorf("the prompt is empty"))
+		return
+	}
+	probabilities := lm.Read(m.New(), symbols)
+	encode(w, NextResponse{
+		Architecture: m.Architecture,
+		Symbols:      m.Top(probabilities, request.TopK),
+	})
+}
+
+// ClassifyRequest is a request for the classification of features
+type ClassifyRequest struct {
+	Features []float64 `json:"features"`
+}
+
+// ClassifyResponse is the classification of features
+type ClassifyResponse struct {
+	Architecture  string             `json:"architecture"`
+	Class         string             `json:"class"`
+	Probabilities map[string]float64 `json:"probabilities"`
+}
+
+// Classify classifies features
+func (s *Server) Classify(w http.ResponseWriter, r *http.Request) {
+	var request ClassifyRequest
+	if !decode(w, r, &request) {
+		return
+	}
+	value, err := s.Classifier.Get()
+	if err != nil {
+		fail(w, http.StatusServiceUnavailable, err)
+		return
+	}
+	c := value.(Classifier)
+	output, err := c.Probabilities(request.Features)
+	if err != nil {
+		fail(w, http.StatusBadRequest, err)
+		return
+	}
+	response := ClassifyResponse{
+		Architecture:  c.Architecture,
+		Probabilities: make(map[string]float64, len(c.Classes)),
+	}
+	max := float32(-1)
+	for i, p := range output {
+		if i >= len(c.Classes) {
+			break
+		}
+		response.Probabilities[c.Classes[i]] = float64(p)
+		if p > max {
+			max, response.Class = p, c.Classes[i]
+		}
+	}
+	encode(w, response)
+}
+
+// decode decodes the JSON request of a POST, it writes the error and returns false if it fails
+func decode(w http.ResponseWriter, r *http.Request, request interface{}) bool {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		fail(w, http.StatusMethodNotAllowed, fmt.Errorf("%s is not allowed", r.Method))
+		return false
+	}
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(request); err != nil {
+		fail(w, http.StatusBadRequest, err)
+		return false
+	}
+	return true
+}
+
+// encode writes a JSON response
+func encode(w http.ResponseWriter, response interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Println(err)
+	}
+}
+
+// fail writes a JSON error
+func fail(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,99 @@
+// Copyright 2023 The RNN Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package serve
+
+import (
+	"bytes"
+	"encoding/json"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pointlander/rnn/feedforward"
+	"github.com/pointlander/rnn/recurrent"
+	"github.com/pointlander/rnn/tokenizer"
+	"github.com/pointlander/rnn/trnn"
+)
+
+func post(t *testing.T, url string, request, response interface{}) int {
+	data, err := json.Marshal(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := http.Post(url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Body.Close()
+	if r.StatusCode == http.StatusOK {
+		if err := json.NewDecoder(r.Body).Decode(response); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return r.StatusCode
+}
+
+func TestServer(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	directory := t.TempDir()
+	language := filepath.Join(directory, "language.model")
+	classifier := filepath.Join(directory, "classifier.model")
+	err := recurrent.NewDistribution(rng, 256).Sample(rng).Save(language, tokenizer.Byte{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = feedforward.NewDistribution(rng, feedforward.Defaults.Stddev).Sample(rng).Save(classifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(NewServer(language, classifier, 1).Handler())
+	defer server.Close()
+
+	request := CompleteRequest{Prompt: "God", Length: 16, Seed: 7}
+	request.Temperature, request.TopK = 1, 8
+	var a, b CompleteResponse
+	if status := post(t, server.URL+"/complete", request, &a); status != http.StatusOK {
+		t.Fatalf("complete status %d", status)
+	}
+	post(t, server.URL+"/complete", request, &b)
+	if a.Architecture != recurrent.Architecture || a.Completion == "" || a.Completion != b.Completion {
+		t.Fatalf("completions %+v %+v", a, b)
+	}
+	if status := post(t, server.URL+"/complete", CompleteRequest{Length: 4}, &a); status != http.StatusBadRequest {
+		t.Fatalf("empty prompt status %d", status)
+	}
+
+	var next NextResponse
+	post(t, server.URL+"/next", NextRequest{Prompt: "God", TopK: 3}, &next)
+	if len(next.Symbols) != 3 || next.Symbols[0].Probability < next.Symbols[2].Probability {
+		t.Fatalf("next %+v", next)
+	}
+
+	var classified ClassifyResponse
+	post(t, server.URL+"/classify", ClassifyRequest{Features: []float64{5.1, 3.5, 1.4, .2}}, &classified)
+	if len(classified.Probabilities) != 3 || classified.Class == "" {
+		t.Fatalf("classified %+v", classified)
+	}
+	if status := post(t, server.URL+"/classify", ClassifyRequest{Features: []float64{1}}, &classified); status != http.StatusBadRequest {
+		t.Fatalf("classify status %d", status)
+	}
+
+	err = trnn.NewDistribution(rng, 256, trnn.Defaults.Stddev).Sample(rng).Save(language, tokenizer.Byte{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(language, later, later); err != nil {
+		t.Fatal(err)
+	}
+	post(t, server.URL+"/next", NextRequest{Prompt: "G", TopK: 1}, &next)
+	if next.Architecture != trnn.Architecture {
+		t.Fatalf("the model was not reloaded %+v", next)
+	}
+}
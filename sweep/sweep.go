@@ -0,0 +1,373 @@
+// Copyright 2023 The RNN Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package sweep runs hyperparameter searches of the models as train commands and ranks the trials
+package sweep
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/pointlander/rnn/train"
+)
+
+const (
+	// Grid searches every combination of the parameter values
+	Grid = "grid"
+	// Random searches random combinations of the parameter values and ranges
+	Random = "random"
+)
+
+// Parameter is the values of a flag of the train command, either a list of values or a range
+type Parameter struct {
+	// Values are the values of the flag
+	Values []interface{} `json:"values" yaml:"values"`
+	// Min and Max are the range of the flag for a random search
+	Min float64 `json:"min" yaml:"min"`
+	Max float64 `json:"max" yaml:"max"`
+	// Int rounds the values of the range to integers
+	Int bool `json:"int" yaml:"int"`
+	// Log samples the range uniformly on a log scale
+	Log bool `json:"log" yaml:"log"`
+}
+
+// Spec is the specification of a search
+type Spec struct {
+	// Model is the model of the train command
+	Model string `json:"model" yaml:"model"`
+	// Search is grid or random
+	Search string `json:"search" yaml:"search"`
+	// Trials is the number of trials of a random search
+	Trials int `json:"trials" yaml:"trials"`
+	// Seed is the seed of a random search
+	Seed int64 `json:"seed" yaml:"seed"`
+	// Parallel is the number of trials run at the same time
+	Parallel int `json:"parallel" yaml:"parallel"`
+	// Flags are the flags of every trial
+	Flags map[string]interface{} `json:"flags" yaml:"flags"`
+	// Parameters are the flags searched over
+	Parameters map[string]Parameter `json:"parameters" yaml:"parameters"`
+}
+
+// LoadSpec loads a YAML spec if the name ends in .yaml or .yml and a JSON spec otherwise
+func LoadSpec(name string) (Spec, error) {
+	var spec Spec
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return spec, err
+	}
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &spec)
+	default:
+		err = json.Unmarshal(data, &spec)
+	}
+	if err != nil {
+		return spec, fmt.Errorf("%s: %w", name, err)
+	}
+	return spec, spec.Validate()
+}
+
+// Validate checks the spec and sets the defaults
+func (s *Spec) Validate() error {
+	if s.Model == "" {
+		return fmt.Errorf("the spec has no model")
+	}
+	if s.Search == "" {
+		s.Search = Grid
+	}
+	if s.Parallel <= 0 {
+		s.Parallel = 1
+	}
+	if s.Seed == 0 {
+		s.Seed = 1
+	}
+	for name, p := range s.Parameters {
+		switch {
+		case len(p.Values) > 0:
+		case s.Search == Grid:
+			return fmt.Errorf("parameter %s of a grid search has no values", name)
+		case p.Min > p.Max || (p.Log && p.Min <= 0):
+			return fmt.Errorf("parameter %s has an invalid range [%g, %g]", name, p.Min, p.Max)
+		}
+	}
+	switch s.Search {
+	case Grid:
+	case Random:
+		if s.Trials <= 0 {
+			return fmt.Errorf("a random search needs trials")
+		}
+	default:
+		return fmt.Errorf("unknown search %s", s.Search)
+	}
+	return nil
+}
+
+// format formats the value of a flag
+func format(value interface{}) string {
+	switch v := value.(type) {
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', -1, 32)
+	}
+	return fmt.Sprint(value)
+}
+
+// names returns the sorted names of the parameters
+func (s Spec) names() []string {
+	names := make([]string, 0, len(s.Parameters))
+	for name := range s.Parameters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Expand returns the parameters of the trials of the search
+func (s Spec) Expand() []map[string]string {
+	names := s.names()
+	if s.Search == Random {
+		rng := rand.New(rand.NewSource(s.Seed))
+		trials := make([]map[string]string, s.Trials)
+		for i := range trials {
+			trials[i] = make(map[string]string, len(names))
+			for _, name := range names {
+				trials[i][name] = s.Parameters[name].Sample(rng)
+			}
+		}
+		return trials
+	}
+	trials := []map[string]string{{}}
+	for _, name := range names {
+		next := make([]map[string]string, 0, len(trials)*len(s.Parameters[name].Values))
+		for _, trial := range trials {
+			for _, value := range s.Parameters[name].Values {
+				t := make(map[string]string, len(trial)+1)
+				for k, v := range trial {
+					t[k] = v
+				}
+				t[name] = format(value)
+				next = append(next, t)
+			}
+		}
+		trials = next
+	}
+	return trials
+}
+
+// Sample samples a value of the parameter
+func (p Parameter) Sample(rng *rand.Rand) string {
+	if len(p.Values) > 0 {
+		return format(p.Values[rng.Intn(len(p.Values))])
+	}
+	value := p.Min + rng.Float64()*(p.Max-p.Min)
+	if p.Log {
+		value = math.Exp(math.Log(p.Min) + rng.Float64()*(math.Log(p.Max)-math.Log(p.Min)))
+	}
+	if p.Int {
+		return strconv.Itoa(int(math.Round(value)))
+	}
+	return strconv.FormatFloat(value, 'g', 6, 64)
+}
+
+// Trial is the result of a trial
+type Trial struct {
+	Index      int               `json:"index"`
+	Parameters map[string]string `json:"parameters"`
+	// Loss is the lowest elite loss of the log, the loss of the model the trial saved, 0 if the trial failed
+	Loss        float64 `json:"loss"`
+	Generations int     `json:"generations"`
+	// Elapsed is the number of seconds the trial ran
+	Elapsed   float64 `json:"elapsed"`
+	Directory string  `json:"directory"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// Runner runs the trials of a search as train commands
+type Runner struct {
+	Spec Spec
+	// Directory is the results directory with a directory per trial
+	Directory string
+	// Command creates the train command of a trial, os.Args[0] by default
+	Command func(ctx context.Context, args []string) *exec.Cmd
+	// Progress receives the trials as they finish
+	Progress io.Writer
+}
+
+// Args returns the arguments of the train command of a trial
+func (r Runner) Args(parameters map[string]string, directory string) []string {
+	args := []string{"train", r.Spec.Model}
+	flags := make([]string, 0, len(r.Spec.Flags))
+	for name := range r.Spec.Flags {
+		if _, ok := parameters[name]; !ok {
+			flags = append(flags, name)
+		}
+	}
+	sort.Strings(flags)
+	for _, name := range flags {
+		args = append(args, fmt.Sprintf("-%s=%s", name, format(r.Spec.Flags[name])))
+	}
+	for _, name := range r.Spec.names() {
+		args = append(args, fmt.Sprintf("-%s=%s", name, parameters[name]))
+	}
+	return append(args,
+		"-output="+filepath.Join(directory, r.Spec.Model+".model"),
+		"-log="+filepath.Join(directory, "log.jsonl"))
+}
+
+// Run runs the trials and writes the ranked results to results.json in the results directory
+func (r Runner) Run(ctx context.Context) ([]Trial, error) {
+	command := r.Command
+	if command == nil {
+		executable, err := os.Executable()
+		if err != nil {
+			return nil, err
+		}
+		command = func(ctx context.Context, args []string) *exec.Cmd {
+			return exec.CommandContext(ctx, executable, args...)
+		}
+	}
+	if err := os.MkdirAll(r.Directory, 0755); err != nil {
+		return nil, err
+	}
+	parameters := r.Spec.Expand()
+	trials := make([]Trial, len(parameters))
+	var mutex sync.Mutex
+	var wait sync.WaitGroup
+	slots := make(chan struct{}, r.Spec.Parallel)
+	for i := range parameters {
+		wait.Add(1)
+		slots <- struct{}{}
+		go func(i int) {
+			defer func() {
+				<-slots
+				wait.Done()
+			}()
+			trials[i] = r.trial(ctx, command, i, parameters[i])
+			mutex.Lock()
+			defer mutex.Unlock()
+			if r.Progress != nil {
+				if trials[i].Error != "" {
+					fmt.Fprintf(r.Progress, "trial %d %v failed: %s\n", i, trials[i].Parameters, trials[i].Error)
+				} else {
+					fmt.Fprintf(r.Progress, "trial %d %v loss %g\n", i, trials[i].Parameters, trials[i].Loss)
+				}
+			}
+		}(i)
+	}
+	wait.Wait()
+	Rank(trials)
+	data, err := json.MarshalIndent(trials, "", "  ")
+	if err != nil {
+		return trials, err
+	}
+	err = os.WriteFile(filepath.Join(r.Directory, "results.json"), data, 0644)
+	if err != nil {
+		return trials, err
+	}
+	return trials, ctx.Err()
+}
+
+// trial runs a trial in its directory
+func (r Runner) trial(ctx context.Context, command func(ctx context.Context, args []string) *exec.Cmd,
+	index int, parameters map[string]string) Trial {
+	t := Trial{
+		Index:      index,
+		Parameters: parameters,
+		Directory:  filepath.Join(r.Directory, fmt.Sprintf("trial-%03d", index)),
+	}
+	fail := func(err error) Trial {
+		t.Error = err.Error()
+		return t
+	}
+	if err := ctx.Err(); err != nil {
+		return fail(err)
+	}
+	err := os.MkdirAll(t.Directory, 0755)
+	if err != nil {
+		return fail(err)
+	}
+	output, err := os.Create(filepath.Join(t.Directory, "output.txt"))
+	if err != nil {
+		return fail(err)
+	}
+	defer output.Close()
+	cmd := command(ctx, r.Args(parameters, t.Directory))
+	cmd.Stdout, cmd.Stderr = output, output
+	start := time.Now()
+	err = cmd.Run()
+	t.Elapsed = time.Since(start).Seconds()
+	if err != nil {
+		return fail(fmt.Errorf("%v, see %s", err, output.Name()))
+	}
+	records, err := train.ReadLog(filepath.Join(t.Directory, "log.jsonl"))
+	if err != nil {
+		return fail(err)
+	}
+	if len(records) == 0 {
+		return fail(fmt.Errorf("the log has no generations"))
+	}
+	t.Loss = records[0].Loss
+	for _, record := range records {
+		if record.Loss < t.Loss {
+			t.Loss = record.Loss
+		}
+	}
+	t.Generations = len(records)
+	data, err := json.MarshalIndent(t, "", "  ")
+	if err != nil {
+		return fail(err)
+	}
+	if err := os.WriteFile(filepath.Join(t.Directory, "trial.json"), data, 0644); err != nil {
+		return fail(err)
+	}
+	return t
+}
+
+// Rank sorts the trials by loss with the failed trials last
+func Rank(trials []Trial) {
+	sort.SliceStable(trials, func(i, j int) bool {
+		a, b := trials[i], trials[j]
+		if (a.Error == "") != (b.Error == "") {
+			return a.Error == ""
+		}
+		return a.Loss < b.Loss
+	})
+}
+
+// Table prints the ranked trials as a table
+func Table(w io.Writer, spec Spec, trials []Trial) error {
+	names := spec.names()
+	table := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(table, "rank\ttrial\t%s\tloss\tgenerations\tseconds\n", strings.Join(names, "\t"))
+	for i, t := range trials {
+		values := make([]string, len(names))
+		for j, name := range names {
+			values[j] = t.Parameters[name]
+		}
+		loss := strconv.FormatFloat(t.Loss, 'g', 6, 64)
+		if t.Error != "" {
+			loss = "failed"
+		}
+		fmt.Fprintf(table, "%d\t%d\t%s\t%s\t%d\t%.1f\n", i+1, t.Index, strings.Join(values, "\t"),
+			loss, t.Generations, t.Elapsed)
+	}
+	return table.Flush()
+}
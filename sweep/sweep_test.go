@@ -0,0 +1,134 @@
+// Copyright 2023 The RNN Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sweep
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pointlander/rnn/train"
+)
+
+// TestTrain is the train command of the sweep test, the elite loss is 1/population and a population of 32 fails
+func TestTrain(t *testing.T) {
+	if os.Getenv("SWEEP_TEST_TRAIN") == "" {
+		t.Skip("run by TestSweep")
+	}
+	args := flag.Args()
+	set := flag.NewFlagSet("train", flag.ContinueOnError)
+	population := set.Int("population", 0, "")
+	set.Int("window", 0, "")
+	set.Int("generations", 0, "")
+	set.String("output", "", "")
+	name := set.String("log", "", "")
+	if err := set.Parse(args[2:]); err != nil {
+		t.Fatal(err)
+	}
+	if *population == 32 {
+		t.Fatal("population 32 fails")
+	}
+	log, err := train.CreateLog(*name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		// the best network of the population is not the elite window the model is saved from
+		log.Observe(train.Stats{Generation: i, Best: float64(*population) / 1024, Loss: 1 / float64(*population) / float64(i+1)})
+	}
+	if err := log.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSweep(t *testing.T) {
+	directory := t.TempDir()
+	name := filepath.Join(directory, "spec.yaml")
+	spec := `model: feedforward
+search: grid
+parallel: 2
+flags:
+  generations: 3
+parameters:
+  population:
+    values: [16, 64, 32]
+  window:
+    values: [4, 8]
+`
+	if err := os.WriteFile(name, []byte(spec), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s, err := LoadSpec(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if trials := s.Expand(); len(trials) != 6 || trials[1]["population"] != "16" || trials[1]["window"] != "8" {
+		t.Fatalf("grid trials %v", trials)
+	}
+
+	random := Spec{
+		Model:  "feedforward",
+		Search: Random,
+		Trials: 8,
+		Parameters: map[string]Parameter{
+			"window": {Min: 2, Max: 16, Int: true, Log: true},
+		},
+	}
+	if err := random.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	a, b := random.Expand(), random.Expand()
+	for i := range a {
+		if a[i]["window"] != b[i]["window"] {
+			t.Fatalf("random trials are not reproducible %v %v", a, b)
+		}
+	}
+
+	runner := Runner{
+		Spec:      s,
+		Directory: filepath.Join(directory, "results"),
+		Command: func(ctx context.Context, args []string) *exec.Cmd {
+			cmd := exec.CommandContext(ctx, os.Args[0], append([]string{"-test.run=^TestTrain$", "--"}, args...)...)
+			cmd.Env = append(os.Environ(), "SWEEP_TEST_TRAIN=1")
+			return cmd
+		},
+	}
+	trials, err := runner.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, trial := range trials {
+		if failed := trial.Parameters["population"] == "32"; failed != (trial.Error != "") || failed != (i >= 4) {
+			t.Fatalf("trial %d error %q", trial.Index, trial.Error)
+		}
+		if i > 0 && i < 4 && trials[i-1].Loss > trial.Loss {
+			t.Fatalf("the trials are not ranked %v", trials)
+		}
+	}
+	if trials[0].Parameters["population"] != "64" || trials[0].Generations != 3 {
+		t.Fatalf("the best trial is %v", trials[0])
+	}
+	data, err := os.ReadFile(filepath.Join(runner.Directory, "results.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var results []Trial
+	if err := json.Unmarshal(data, &results); err != nil || len(results) != 6 || results[5].Error == "" {
+		t.Fatalf("results %v %v", results, err)
+	}
+	var table bytes.Buffer
+	if err := Table(&table, s, trials); err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSpace(table.String()), "\n"); len(lines) != 7 {
+		t.Fatalf("table %s", table.String())
+	}
+}
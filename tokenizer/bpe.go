@@ -0,0 +1,181 @@
+// Copyright 2023 The RNN Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tokenizer
+
+// BPE is a byte level byte pair encoding tokenizer
+// Symbols below 256 are bytes, symbol 256+i is the merge of the pair Merges[i]
+type BPE struct {
+	Merges [][2]int
+	ranks  map[[2]int]int
+	vocab  [][]byte
+}
+
+const (
+	classLetter = iota
+	classSpace
+	classOther
+)
+
+func class(symbol byte) int {
+	switch {
+	case symbol == ' ' || symbol == '\t' || symbol == '\n' || symbol == '\r':
+		return classSpace
+	case symbol >= 'a' && symbol <= 'z', symbol >= 'A' && symbol <= 'Z',
+		symbol >= '0' && symbol <= '9', symbol >= 0x80:
+		return classLetter
+	}
+	return classOther
+}
+
+// chunks splits data into words that merges do not cross
+// leading white space is attached to the following word
+func chunks(data []byte) [][]byte {
+	var words [][]byte
+	begin := 0
+	for i := 1; i < len(data); i++ {
+		previous, current := class(data[i-1]), class(data[i])
+		if previous == current && current != classOther {
+			continue
+		}
+		if previous == classSpace && current == classLetter {
+			continue
+		}
+		words = append(words, data[begin:i])
+		begin = i
+	}
+	if begin < len(data) {
+		words = append(words, data[begin:])
+	}
+	return words
+}
+
+// NewBPE trains a byte pair encoding tokenizer with size symbols on data
+func NewBPE(data []byte, size int) *BPE {
+	counts := make(map[string]int)
+	for _, word := range chunks(data) {
+		counts[string(word)]++
+	}
+	type Word struct {
+		Symbols []int
+		Count   int
+	}
+	words := make([]Word, 0, len(counts))
+	for word, count := range counts {
+		symbols := make([]int, len(word))
+		for i := 0; i < len(word); i++ {
+			symbols[i] = int(word[i])
+		}
+		words = append(words, Word{
+			Symbols: symbols,
+			Count:   count,
+		})
+	}
+
+	var merges [][2]int
+	for 256+len(merges) < size {
+		pairs := make(map[[2]int]int)
+		for _, word := range words {
+			for i := 0; i < len(word.Symbols)-1; i++ {
+				pairs[[2]int{word.Symbols[i], word.Symbols[i+1]}] += word.Count
+			}
+		}
+		max, best := 1, [2]int{}
+		for pair, count := range pairs {
+			if count > max || (count == max && max > 1 &&
+				(pair[0] < best[0] || (pair[0] == best[0] && pair[1] < best[1]))) {
+				max, best = count, pair
+			}
+		}
+		if max < 2 {
+			break
+		}
+		symbol := 256 + len(merges)
+		merges = append(merges, best)
+		for i := range words {
+			words[i].Symbols = merge(words[i].Symbols, best, symbol)
+		}
+	}
+	return newBPE(merges)
+}
+
+func newBPE(merges [][2]int) *BPE {
+	ranks := make(map[[2]int]int, len(merges))
+	vocab := make([][]byte, 256, 256+len(merges))
+	for i := range vocab {
+		vocab[i] = []byte{byte(i)}
+	}
+	for i, pair := range merges {
+		ranks[pair] = i
+		symbol := append(append([]byte{}, vocab[pair[0]]...), vocab[pair[1]]...)
+		vocab = append(vocab, symbol)
+	}
+	return &BPE{
+		Merges: merges,
+		ranks:  ranks,
+		vocab:  vocab,
+	}
+}
+
+// merge replaces every occurrence of pair in symbols with symbol
+func merge(symbols []int, pair [2]int, symbol int) []int {
+	j := 0
+	for i := 0; i < len(symbols); i++ {
+		if i < len(symbols)-1 && symbols[i] == pair[0] && symbols[i+1] == pair[1] {
+			symbols[j] = symbol
+			i++
+		} else {
+			symbols[j] = symbols[i]
+		}
+		j++
+	}
+	return symbols[:j]
+}
+
+// Name is the name of the tokenizer
+func (b *BPE) Name() string {
+	return "bpe"
+}
+
+// Size is the number of symbols in the vocabulary
+func (b *BPE) Size() int {
+	return 256 + len(b.Merges)
+}
+
+// Encode converts bytes to symbols
+func (b *BPE) Encode(data []byte) []int {
+	output := make([]int, 0, len(data))
+	for _, word := range chunks(data) {
+		symbols := make([]int, len(word))
+		for i, symbol := range word {
+			symbols[i] = int(symbol)
+		}
+		for len(symbols) > 1 {
+			rank, found := len(b.Merges), false
+			for i := 0; i < len(symbols)-1; i++ {
+				if r, ok := b.ranks[[2]int{symbols[i], symbols[i+1]}]; ok && r < rank {
+					rank, found = r, true
+				}
+			}
+			if !found {
+				break
+			}
+			symbols = merge(symbols, b.Merges[rank], 256+rank)
+		}
+		output = append(output, symbols...)
+	}
+	return output
+}
+
+// Decode converts symbols to bytes, the symbols that are not in the vocabulary are skipped
+func (b *BPE) Decode(symbols []int) []byte {
+	data := make([]byte, 0, len(symbols))
+	for _, symbol := range symbols {
+		if symbol < 0 || symbol >= len(b.vocab) {
+			continue
+		}
+		data = append(data, b.vocab[symbol]...)
+	}
+	return data
+}
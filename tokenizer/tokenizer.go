@@ -0,0 +1,230 @@
+// Copyright 2023 The RNN Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tokenizer
+
+import (
+	"encoding/gob"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"unicode/utf8"
+)
+
+// Tokenizer converts between bytes and symbols
+type Tokenizer interface {
+	// Name is the name of the tokenizer
+	Name() string
+	// Size is the number of symbols in the vocabulary
+	Size() int
+	// Encode converts bytes to symbols
+	Encode(data []byte) []int
+	// Decode converts symbols to bytes
+	Decode(symbols []int) []byte
+}
+
+// Vocabulary is the serialized form of a tokenizer
+type Vocabulary struct {
+	Name   string
+	Runes  []rune
+	Merges [][2]int
+}
+
+// New creates a tokenizer by name, training it on data if required
+func New(name string, data []byte, size int) (Tokenizer, error) {
+	switch name {
+	case "byte":
+		return Byte{}, nil
+	case "rune":
+		return NewRune(data), nil
+	case "bpe":
+		return NewBPE(data, size), nil
+	}
+	return nil, fmt.Errorf("unknown tokenizer %s", name)
+}
+
+// NewVocabulary creates the vocabulary of a tokenizer
+func NewVocabulary(t Tokenizer) Vocabulary {
+	v := Vocabulary{
+		Name: t.Name(),
+	}
+	switch t := t.(type) {
+	case *Rune:
+		v.Runes = t.Runes
+	case *BPE:
+		v.Merges = t.Merges
+	}
+	return v
+}
+
+// Save writes the vocabulary of a tokenizer
+func Save(w io.Writer, t Tokenizer) error {
+	return gob.NewEncoder(w).Encode(NewVocabulary(t))
+}
+
+// Load reads the vocabulary of a tokenizer
+func Load(r io.Reader) (Tokenizer, error) {
+	var v Vocabulary
+	err := gob.NewDecoder(r).Decode(&v)
+	if err != nil {
+		return nil, err
+	}
+	return v.Tokenizer()
+}
+
+// SaveFile writes the vocabulary of a tokenizer to a file
+func SaveFile(name string, t Tokenizer) error {
+	output, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	defer output.Close()
+	return Save(output, t)
+}
+
+// LoadFile reads the vocabulary of a tokenizer from a file
+func LoadFile(name string) (Tokenizer, error) {
+	input, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer input.Close()
+	return Load(input)
+}
+
+// Tokenizer builds the tokenizer described by the vocabulary
+func (v Vocabulary) Tokenizer() (Tokenizer, error) {
+	switch v.Name {
+	case "byte":
+		return Byte{}, nil
+	case "rune":
+		return newRune(v.Runes), nil
+	case "bpe":
+		for i, pair := range v.Merges {
+			for _, symbol := range pair {
+				if symbol < 0 || symbol >= 256+i {
+					return nil, fmt.Errorf("merge %d of symbol %d is not in the vocabulary of %d symbols", i, symbol, 256+i)
+				}
+			}
+		}
+		return newBPE(v.Merges), nil
+	}
+	return nil, fmt.Errorf("unknown tokenizer %s", v.Name)
+}
+
+// Byte is the identity tokenizer with one symbol per byte
+type Byte struct{}
+
+// Name is the name of the tokenizer
+func (Byte) Name() string {
+	return "byte"
+}
+
+// Size is the number of symbols in the vocabulary
+func (Byte) Size() int {
+	return 256
+}
+
+// Encode converts bytes to symbols
+func (Byte) Encode(data []byte) []int {
+	symbols := make([]int, len(data))
+	for i, symbol := range data {
+		symbols[i] = int(symbol)
+	}
+	return symbols
+}
+
+// Decode converts symbols to bytes, the symbols that are not in the vocabulary are skipped
+func (Byte) Decode(symbols []int) []byte {
+	data := make([]byte, 0, len(symbols))
+	for _, symbol := range symbols {
+		if symbol < 0 || symbol >= 256 {
+			continue
+		}
+		data = append(data, byte(symbol))
+	}
+	return data
+}
+
+// Rune is a UTF-8 rune tokenizer
+// Symbols below 256 are bytes, the remaining symbols are the multi-byte runes
+// of the vocabulary, so invalid or unknown sequences fall back to bytes
+type Rune struct {
+	Runes   []rune
+	indexes map[rune]int
+}
+
+// NewRune creates a rune tokenizer from the multi-byte runes found in data
+func NewRune(data []byte) *Rune {
+	seen := make(map[rune]bool)
+	for len(data) > 0 {
+		r, size := utf8.DecodeRune(data)
+		if r != utf8.RuneError && size > 1 {
+			seen[r] = true
+		}
+		data = data[size:]
+	}
+	runes := make([]rune, 0, len(seen))
+	for r := range seen {
+		runes = append(runes, r)
+	}
+	sort.Slice(runes, func(i, j int) bool {
+		return runes[i] < runes[j]
+	})
+	return newRune(runes)
+}
+
+func newRune(runes []rune) *Rune {
+	indexes := make(map[rune]int, len(runes))
+	for i, r := range runes {
+		indexes[r] = 256 + i
+	}
+	return &Rune{
+		Runes:   runes,
+		indexes: indexes,
+	}
+}
+
+// Name is the name of the tokenizer
+func (r *Rune) Name() string {
+	return "rune"
+}
+
+// Size is the number of symbols in the vocabulary
+func (r *Rune) Size() int {
+	return 256 + len(r.Runes)
+}
+
+// Encode converts bytes to symbols
+func (r *Rune) Encode(data []byte) []int {
+	symbols := make([]int, 0, len(data))
+	for len(data) > 0 {
+		value, size := utf8.DecodeRune(data)
+		if index, ok := r.indexes[value]; ok && size > 1 {
+			symbols = append(symbols, index)
+		} else {
+			for _, symbol := range data[:size] {
+				symbols = append(symbols, int(symbol))
+			}
+		}
+		data = data[size:]
+	}
+	return symbols
+}
+
+// Decode converts symbols to bytes, the symbols that are not in the vocabulary are skipped
+func (r *Rune) Decode(symbols []int) []byte {
+	data := make([]byte, 0, len(symbols))
+	for _, symbol := range symbols {
+		if symbol < 0 || symbol >= r.Size() {
+			continue
+		} else if symbol < 256 {
+			data = append(data, byte(symbol))
+			continue
+		}
+		data = utf8.AppendRune(data, r.Runes[symbol-256])
+	}
+	return data
+}
@@ -0,0 +1,83 @@
+// Copyright 2023 The RNN Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tokenizer
+
+import (
+	"bytes"
+	"testing"
+)
+
+const text = "In the beginning God created the heaven and the earth. Ünïcödé — \xff\xfe"
+
+func TestRoundTrip(t *testing.T) {
+	data := []byte(text)
+	for _, name := range []string{"byte", "rune", "bpe"} {
+		tok, err := New(name, data, 280)
+		if err != nil {
+			t.Fatal(err)
+		}
+		symbols := tok.Encode(data)
+		for _, symbol := range symbols {
+			if symbol < 0 || symbol >= tok.Size() {
+				t.Fatalf("%s: symbol %d out of range %d", name, symbol, tok.Size())
+			}
+		}
+		if decoded := tok.Decode(symbols); !bytes.Equal(decoded, data) {
+			t.Fatalf("%s: %q != %q", name, decoded, data)
+		}
+		if decoded := tok.Decode([]int{-1, 'a', tok.Size()}); string(decoded) != "a" {
+			t.Fatalf("%s: symbols out of the vocabulary decode to %q", name, decoded)
+		}
+
+		buffer := bytes.Buffer{}
+		err = Save(&buffer, tok)
+		if err != nil {
+			t.Fatal(err)
+		}
+		loaded, err := Load(&buffer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if loaded.Size() != tok.Size() {
+			t.Fatalf("%s: size %d != %d", name, loaded.Size(), tok.Size())
+		}
+		reencoded := loaded.Encode(data)
+		if len(reencoded) != len(symbols) {
+			t.Fatalf("%s: %d symbols != %d symbols", name, len(reencoded), len(symbols))
+		}
+		for i := range symbols {
+			if reencoded[i] != symbols[i] {
+				t.Fatalf("%s: symbol %d %d != %d", name, i, reencoded[i], symbols[i])
+			}
+		}
+	}
+}
+
+func TestBPECompresses(t *testing.T) {
+	data := bytes.Repeat([]byte("the heaven and the earth "), 16)
+	tok := NewBPE(data, 300)
+	if tok.Size() <= 256 {
+		t.Fatal("no merges were learned")
+	}
+	if symbols := tok.Encode(data); len(symbols) >= len(data)/2 {
+		t.Fatalf("%d symbols for %d bytes", len(symbols), len(data))
+	}
+}
+
+func TestVocabulary(t *testing.T) {
+	valid := Vocabulary{Name: "bpe", Merges: [][2]int{{'a', 'b'}, {256, 'c'}}}
+	tok, err := valid.Tokenizer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded := tok.Decode([]int{257}); string(decoded) != "abc" {
+		t.Fatalf("decoded %q", decoded)
+	}
+	for _, merges := range [][][2]int{{{'a', 256}}, {{'a', 'b'}, {258, 'c'}}, {{-1, 'a'}}} {
+		if _, err := (Vocabulary{Name: "bpe", Merges: merges}).Tokenizer(); err == nil {
+			t.Fatalf("merges %v are not in the vocabulary", merges)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+// Copyright 2023 The RNN Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package train
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Record is a line of the training log
+type Record struct {
+	// Time is when the generation finished
+	Time time.Time `json:"time"`
+	// Generation is the index of the generation
+	Generation int `json:"generation"`
+	// Best, Median and Worst are the losses of the population
+	Best   float64 `json:"best"`
+	Median float64 `json:"median"`
+	Worst  float64 `json:"worst"`
+	// Stddev is the standard deviation of the losses in the elite window
+	Stddev float64 `json:"elite_stddev"`
+	// Spread is the mean standard deviation of the distribution
+	Spread float64 `json:"distribution_stddev"`
+	// Loss is the lowest elite loss so far, the loss of the model that is saved
+	Loss float64 `json:"loss"`
+	// Wall is the number of seconds since the log was created
+	Wall float64 `json:"wall"`
+}
+
+// Header is the header of the CSV logs
+var Header = []string{"time", "generation", "best", "median", "worst", "elite_stddev", "distribution_stddev", "loss", "wall"}
+
+// Strings returns the fields of the record in the order of the CSV header
+func (r Record) Strings() []string {
+	format := func(value float64) string {
+		return strconv.FormatFloat(value, 'g', -1, 64)
+	}
+	return []string{
+		r.Time.Format(time.RFC3339Nano),
+		strconv.Itoa(r.Generation),
+		format(r.Best),
+		format(r.Median),
+		format(r.Worst),
+		format(r.Stddev),
+		format(r.Spread),
+		format(r.Loss),
+		format(r.Wall),
+	}
+}
+
+// ParseRecord parses the fields of a CSV log line
+func ParseRecord(fields []string) (Record, error) {
+	var r Record
+	if len(fields) != len(Header) {
+		return r, fmt.Errorf("%d fields not %d", len(fields), len(Header))
+	}
+	var err error
+	if r.Time, err = time.Parse(time.RFC3339Nano, fields[0]); err != nil {
+		return r, err
+	}
+	if r.Generation, err = strconv.Atoi(fields[1]); err != nil {
+		return r, err
+	}
+	values := []*float64{&r.Best, &r.Median, &r.Worst, &r.Stddev, &r.Spread, &r.Loss, &r.Wall}
+	for i, value := range values {
+		if *value, err = strconv.ParseFloat(fields[i+2], 64); err != nil {
+			return r, fmt.Errorf("%s: %w", Header[i+2], err)
+		}
+	}
+	return r, nil
+}
+
+// IsCSV returns true if the log file is a CSV file, otherwise it is JSON lines
+func IsCSV(name string) bool {
+	return strings.EqualFold(filepath.Ext(name), ".csv")
+}
+
+// Log writes a record for each generation to a JSON lines or CSV file
+type Log struct {
+	file    *os.File
+	csv     *csv.Writer
+	encoder *json.Encoder
+	start   time.Time
+	err     error
+}
+
+// CreateLog creates a log file, CSV if the name ends in .csv and JSON lines otherwise,
+// the log is nil if the name is empty
+func CreateLog(name string) (*Log, error) {
+	if name == "" {
+		return nil, nil
+	}
+	file, err := os.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	l := &Log{
+		file:  file,
+		start: time.Now(),
+	}
+	if IsCSV(name) {
+		l.csv = csv.NewWriter(file)
+		l.write(Header)
+	} else {
+		l.encoder = json.NewEncoder(file)
+	}
+	if l.err != nil {
+		file.Close()
+		return nil, l.err
+	}
+	return l, nil
+}
+
+// write writes a CSV line
+func (l *Log) write(fields []string) {
+	if l.err != nil {
+		return
+	}
+	if l.err = l.csv.Write(fields); l.err != nil {
+		return
+	}
+	l.csv.Flush()
+	l.err = l.csv.Error()
+}
+
+// Observe writes the record of a generation, the first error is returned by Close
+func (l *Log) Observe(s Stats) {
+	if l == nil || l.err != nil {
+		return
+	}
+	now := time.Now()
+	r := Record{
+		Time:       now,
+		Generation: s.Generation,
+		Best:       s.Best,
+		Median:     s.Median,
+		Worst:      s.Worst,
+		Stddev:     s.Stddev,
+		Spread:     s.Spread,
+		Loss:       s.Loss,
+		Wall:       now.Sub(l.start).Seconds(),
+	}
+	if l.csv != nil {
+		l.write(r.Strings())
+		return
+	}
+	l.err = l.encoder.Encode(r)
+}
+
+// Close closes the log file
+func (l *Log) Close() error {
+	if l == nil {
+		return nil
+	}
+	err := l.file.Close()
+	if l.err != nil {
+		return l.err
+	}
+	return err
+}
+
+// ReadLog reads the records of a JSON lines or CSV log file
+func ReadLog(name string) ([]Record, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	if IsCSV(name) {
+		return readCSV(file)
+	}
+	var records []Record
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var r Record
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			return records, fmt.Errorf("%s line %d: %w", name, line, err)
+		}
+		records = append(records, r)
+	}
+	return records, scanner.Err()
+}
+
+// readCSV reads the records of a CSV log
+func readCSV(reader io.Reader) ([]Record, error) {
+	lines, err := csv.NewReader(reader).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(lines) > 0 && len(lines[0]) > 0 && lines[0][0] == Header[0] {
+		lines = lines[1:]
+	}
+	records := make([]Record, 0, len(lines))
+	for i, fields := range lines {
+		r, err := ParseRecord(fields)
+		if err != nil {
+			return records, fmt.Errorf("record %d: %w", i+1, err)
+		}
+		records = append(records, r)
+	}
+	return records, nil
+}
@@ -0,0 +1,52 @@
+// Copyright 2023 The RNN Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package train
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"gonum.org/v1/plot"
+	"gonum.org/v1/plot/plotter"
+	"gonum.org/v1/plot/plotutil"
+	"gonum.org/v1/plot/vg"
+)
+
+// Plot renders the best, median and worst loss curves of the logs to a PNG or SVG file,
+// the curves are labeled with the names of the logs if there is more than one
+func Plot(output string, names []string, logs [][]Record) error {
+	if len(names) != len(logs) {
+		return fmt.Errorf("%d names for %d logs", len(names), len(logs))
+	}
+	p := plot.New()
+	p.Title.Text = "generations vs loss"
+	p.X.Label.Text = "generation"
+	p.Y.Label.Text = "loss"
+	p.Legend.Top = true
+
+	var lines []interface{}
+	for i, records := range logs {
+		prefix := ""
+		if len(logs) > 1 {
+			prefix = strings.TrimSuffix(filepath.Base(names[i]), filepath.Ext(names[i])) + " "
+		}
+		best := make(plotter.XYs, len(records))
+		median := make(plotter.XYs, len(records))
+		worst := make(plotter.XYs, len(records))
+		for j, r := range records {
+			x := float64(r.Generation)
+			best[j] = plotter.XY{X: x, Y: r.Best}
+			median[j] = plotter.XY{X: x, Y: r.Median}
+			worst[j] = plotter.XY{X: x, Y: r.Worst}
+		}
+		lines = append(lines, prefix+"best", best, prefix+"median", median, prefix+"worst", worst)
+	}
+	err := plotutil.AddLines(p, lines...)
+	if err != nil {
+		return err
+	}
+	return p.Save(8*vg.Inch, 6*vg.Inch, output)
+}
@@ -0,0 +1,122 @@
+// Copyright 2023 The RNN Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package train
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"runtime"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+)
+
+// Worker is a worker of a pool, its random number generator and scratch buffers are reused
+// across the candidates and the generations it evaluates
+type Worker[S any] struct {
+	// Index is the index of the worker in the pool
+	Index int
+	// Rand is the random number generator of the worker, reseeded by Seed
+	Rand *rand.Rand
+	// Scratch is the scratch state of the worker created by the pool
+	Scratch S
+}
+
+// Seed reseeds the random number generator of the worker, it then generates the same sequence
+// as rand.New(rand.NewSource(seed)) without allocating a source
+func (w *Worker[S]) Seed(seed int64) *rand.Rand {
+	w.Rand.Seed(seed)
+	return w.Rand
+}
+
+// Pool evaluates the candidates of the generations with a bounded number of long lived workers
+type Pool[S any] struct {
+	workers []*Worker[S]
+	jobs    chan func(w *Worker[S])
+	wait    sync.WaitGroup
+}
+
+// NewPool starts a pool of workers, the number of CPUs if workers is not positive, the scratch state
+// of each worker is created by scratch if it is not nil
+func NewPool[S any](workers int, scratch func() S) *Pool[S] {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	p := &Pool[S]{
+		jobs: make(chan func(w *Worker[S])),
+	}
+	for i := 0; i < workers; i++ {
+		w := &Worker[S]{
+			Index: i,
+			Rand:  rand.New(rand.NewSource(1)),
+		}
+		if scratch != nil {
+			w.Scratch = scratch()
+		}
+		p.workers = append(p.workers, w)
+		p.wait.Add(1)
+		go func() {
+			defer p.wait.Done()
+			for job := range p.jobs {
+				job(w)
+			}
+		}()
+	}
+	return p
+}
+
+// Workers returns the number of workers of the pool
+func (p *Pool[S]) Workers() int {
+	return len(p.workers)
+}
+
+// Run evaluates the candidates [0, n) on the workers and waits for them, a panic of an evaluation is
+// recovered as its error. The candidates are dispatched in order until the context is done or an evaluation
+// fails, it then returns the error of the context or the error of the failed candidate with the lowest index
+func (p *Pool[S]) Run(ctx context.Context, n int, evaluate func(w *Worker[S], candidate int) error) error {
+	errs := make([]error, n)
+	var failed atomic.Bool
+	var done sync.WaitGroup
+	call := func(w *Worker[S], candidate int) (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("candidate %d panicked: %v\n%s", candidate, r, debug.Stack())
+			}
+		}()
+		return evaluate(w, candidate)
+	}
+	for j := 0; j < n && ctx.Err() == nil && !failed.Load(); j++ {
+		j := j
+		done.Add(1)
+		job := func(w *Worker[S]) {
+			defer done.Done()
+			if errs[j] = call(w, j); errs[j] != nil {
+				failed.Store(true)
+			}
+		}
+		select {
+		case p.jobs <- job:
+		case <-ctx.Done():
+			done.Done()
+		}
+	}
+	done.Wait()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close stops the workers
+func (p *Pool[S]) Close() {
+	close(p.jobs)
+	p.wait.Wait()
+}
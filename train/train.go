@@ -0,0 +1,259 @@
+// Copyright 2023 The RNN Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package train implements the options shared by the learners
+package train
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// Options are the hyperparameters and files of a learner
+type Options struct {
+	// Seed is the seed of the random number generator
+	Seed int64
+	// Population is the number of networks sampled per generation
+	Population int
+	// Generations is the number of generations
+	Generations int
+	// Window is the number of elite networks the distribution is estimated from
+	Window int
+	// Elite is the number of the best networks the elite window is searched in
+	Elite int
+	// Workers is the number of networks evaluated in parallel
+	Workers int
+	// Data is the path of the training data
+	Data string
+	// Output is the path of the model file
+	Output string
+	// Log is the path of the per-generation log, CSV if it ends in .csv and JSON lines otherwise
+	Log string
+	// Snapshot saves the distribution every Snapshot generations next to the model file, 0 never
+	Snapshot int
+	// Stddev is the initial standard deviation of the weights of the distribution
+	Stddev float64
+}
+
+// Merge returns the options with the zero fields set from the defaults,
+// the number of workers defaults to the number of CPUs and the elite to the population
+// if the default does not fit in it
+func (o Options) Merge(defaults Options) Options {
+	if o.Seed == 0 {
+		o.Seed = defaults.Seed
+	}
+	if o.Population == 0 {
+		o.Population = defaults.Population
+	}
+	if o.Generations == 0 {
+		o.Generations = defaults.Generations
+	}
+	if o.Window == 0 {
+		o.Window = defaults.Window
+	}
+	if o.Elite == 0 {
+		o.Elite = defaults.Elite
+		if o.Elite == 0 || o.Elite > o.Population {
+			o.Elite = o.Population
+		}
+	}
+	if o.Workers == 0 {
+		o.Workers = defaults.Workers
+	}
+	if o.Workers == 0 {
+		o.Workers = runtime.NumCPU()
+	}
+	if o.Data == "" {
+		o.Data = defaults.Data
+	}
+	if o.Output == "" {
+		o.Output = defaults.Output
+	}
+	if o.Log == "" {
+		o.Log = defaults.Log
+	}
+	if o.Snapshot == 0 {
+		o.Snapshot = defaults.Snapshot
+	}
+	if o.Stddev == 0 {
+		o.Stddev = defaults.Stddev
+	}
+	return o
+}
+
+// Validate checks that the elite window fits in the population
+func (o Options) Validate() error {
+	if o.Population <= 0 || o.Generations < 0 || o.Workers < 0 {
+		return fmt.Errorf("invalid population %d, generations %d or workers %d", o.Population, o.Generations, o.Workers)
+	}
+	if o.Window <= 0 || o.Window > o.Population {
+		return fmt.Errorf("window %d is not in [1, %d]", o.Window, o.Population)
+	}
+	if o.Elite < o.Window || o.Elite > o.Population {
+		return fmt.Errorf("elite %d is not in [%d, %d]", o.Elite, o.Window, o.Population)
+	}
+	if o.Stddev < 0 {
+		return fmt.Errorf("invalid stddev %g", o.Stddev)
+	}
+	return nil
+}
+
+// Distribution is the path of the distribution file saved next to the model file
+func (o Options) Distribution() string {
+	return strings.TrimSuffix(o.Output, filepath.Ext(o.Output)) + ".distribution"
+}
+
+// SnapshotName is the path of the distribution snapshot taken at the start of a generation
+func (o Options) SnapshotName(generation int) string {
+	return fmt.Sprintf("%s.%d.distribution", strings.TrimSuffix(o.Output, filepath.Ext(o.Output)), generation)
+}
+
+// Seed derives the seed of a candidate of a generation from the seed of the search with splitmix64,
+// each candidate is sampled and evaluated from its own seed so that the results do not depend on
+// the number of workers or the order they finish in
+func Seed(seed int64, generation, candidate int) int64 {
+	x := uint64(seed)
+	for _, v := range []int{generation, candidate} {
+		x += 0x9e3779b97f4a7c15 + uint64(v)
+		x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+		x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+		x ^= x >> 31
+	}
+	return int64(x >> 1)
+}
+
+// Stats are the statistics of a generation
+type Stats struct {
+	// Generation is the index of the generation
+	Generation int
+	// Best, Median, Worst and Mean are statistics of the losses of the population
+	Best   float64
+	Median float64
+	Worst  float64
+	Mean   float64
+	// Elite is the index of the elite window in the sorted population
+	Elite int
+	// Stddev is the standard deviation of the losses in the elite window
+	Stddev float64
+	// Spread is the mean standard deviation of the distribution the population was sampled from
+	Spread float64
+	// Loss is the lowest elite loss so far
+	Loss float64
+	// Improved is true if the elite improved on the lowest loss and updated the distribution
+	Improved bool
+}
+
+// NewStats computes the statistics of the losses of a generation sorted in ascending order
+func NewStats(generation int, losses []float64) Stats {
+	s := Stats{
+		Generation: generation,
+	}
+	if len(losses) == 0 {
+		return s
+	}
+	s.Best, s.Worst = losses[0], losses[len(losses)-1]
+	if middle := len(losses) / 2; len(losses)%2 == 1 {
+		s.Median = losses[middle]
+	} else {
+		s.Median = (losses[middle-1] + losses[middle]) / 2
+	}
+	for _, loss := range losses {
+		s.Mean += loss
+	}
+	s.Mean /= float64(len(losses))
+	return s
+}
+
+// Observer receives the statistics of each generation
+type Observer func(s Stats)
+
+// Observe calls the observer if it is not nil
+func (o Observer) Observe(s Stats) {
+	if o != nil {
+		o(s)
+	}
+}
+
+// Print prints the elite window stddev, index and loss of the generations that improved
+func Print(s Stats) {
+	if s.Improved {
+		fmt.Println(s.Stddev, s.Elite, s.Loss)
+	}
+}
+
+// Progress prints the generations that improved, records whether any of them did
+// and writes every generation to the log
+type Progress struct {
+	Improved bool
+	Log      *Log
+}
+
+// NewProgress creates the progress of a learner with the log file of the options
+func NewProgress(options Options) (*Progress, error) {
+	log, err := CreateLog(options.Log)
+	if err != nil {
+		return nil, err
+	}
+	return &Progress{
+		Log: log,
+	}, nil
+}
+
+// Observe observes the statistics of a generation
+func (p *Progress) Observe(s Stats) {
+	if s.Improved {
+		p.Improved = true
+	}
+	p.Log.Observe(s)
+	Print(s)
+}
+
+// Close closes the log
+func (p *Progress) Close() error {
+	return p.Log.Close()
+}
+
+// Interrupt returns a context that is canceled by the first SIGINT or SIGTERM so that training
+// stops and saves the best model so far, a second signal exits the process
+func Interrupt(parent context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(parent)
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	stop := make(chan struct{})
+	go func() {
+		select {
+		case <-signals:
+			fmt.Fprintln(os.Stderr, "interrupted, saving the best model so far, interrupt again to exit now")
+			cancel()
+		case <-stop:
+			return
+		}
+		select {
+		case <-signals:
+			os.Exit(1)
+		case <-stop:
+		}
+	}()
+	var once sync.Once
+	return ctx, func() {
+		once.Do(func() {
+			signal.Stop(signals)
+			close(stop)
+		})
+		cancel()
+	}
+}
+
+// Interrupted returns true if the error is from a context that was canceled or timed out
+func Interrupted(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
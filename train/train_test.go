@@ -0,0 +1,171 @@
+// Copyright 2023 The RNN Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package train
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestOptions(t *testing.T) {
+	defaults := Options{
+		Seed:        1,
+		Population:  64,
+		Generations: 32,
+		Window:      8,
+		Elite:       32,
+		Data:        "pg10.txt.gz",
+		Output:      "recurrent.model",
+	}
+	o := Options{Population: 16, Output: "out/model.bin"}.Merge(defaults)
+	if o.Seed != 1 || o.Population != 16 || o.Window != 8 || o.Elite != 16 || o.Data != "pg10.txt.gz" || o.Workers <= 0 {
+		t.Fatalf("merged options %+v", o)
+	}
+	if name := o.Distribution(); name != "out/model.distribution" {
+		t.Fatalf("distribution is %s", name)
+	}
+	if err := o.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if elite := (Options{}).Merge(defaults).Elite; elite != 32 {
+		t.Fatalf("elite is %d", elite)
+	}
+	o.Elite = 4
+	if err := o.Validate(); err == nil {
+		t.Fatal("expected an error for an elite smaller than the window")
+	}
+	o.Window = 17
+	if err := o.Validate(); err == nil {
+		t.Fatal("expected an error for a window larger than the population")
+	}
+}
+
+func TestSeed(t *testing.T) {
+	seeds := make(map[int64]bool)
+	for generation := 0; generation < 16; generation++ {
+		for candidate := 0; candidate < 16; candidate++ {
+			seed := Seed(1, generation, candidate)
+			if seed < 0 || seeds[seed] {
+				t.Fatalf("seed %d of generation %d candidate %d", seed, generation, candidate)
+			}
+			seeds[seed] = true
+		}
+	}
+	if Seed(1, 2, 3) != Seed(1, 2, 3) || Seed(1, 2, 3) == Seed(2, 2, 3) || Seed(1, 2, 3) == Seed(1, 3, 2) {
+		t.Fatal("the seeds are not derived from the seed, the generation and the candidate")
+	}
+}
+
+func TestInterrupt(t *testing.T) {
+	ctx, cancel := Interrupt(context.Background())
+	defer cancel()
+	process, err := os.FindProcess(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := process.Signal(os.Interrupt); err != nil {
+		t.Skip(err)
+	}
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("the context was not canceled by the signal")
+	}
+	if !Interrupted(fmt.Errorf("generation 3: %w", ctx.Err())) || Interrupted(fmt.Errorf("window")) {
+		t.Fatal("interrupted errors are not detected")
+	}
+}
+
+func TestLog(t *testing.T) {
+	for _, name := range []string{"train.jsonl", "train.csv"} {
+		name = filepath.Join(t.TempDir(), name)
+		log, err := CreateLog(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 3; i++ {
+			s := NewStats(i, []float64{1, 2, 3, 4})
+			s.Stddev, s.Spread, s.Loss = .5, .25, 1.5/float64(i+1)
+			log.Observe(s)
+		}
+		if err := log.Close(); err != nil {
+			t.Fatal(err)
+		}
+		records, err := ReadLog(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(records) != 3 || records[2].Generation != 2 || records[1].Median != 2.5 ||
+			records[0].Worst != 4 || records[0].Spread != .25 || records[2].Loss != .5 || records[2].Wall < records[0].Wall {
+			t.Fatalf("%s records %+v", name, records)
+		}
+	}
+}
+
+func TestPool(t *testing.T) {
+	pool := NewPool(3, func() []int { return make([]int, 0, 8) })
+	defer pool.Close()
+	if pool.Workers() != 3 {
+		t.Fatalf("%d workers", pool.Workers())
+	}
+	values := make([]float64, 64)
+	err := pool.Run(context.Background(), len(values), func(w *Worker[[]int], j int) error {
+		w.Scratch = append(w.Scratch[:0], j)
+		values[j] = w.Seed(Seed(1, 0, j)).Float64()
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for j, value := range values {
+		if expected := rand.New(rand.NewSource(Seed(1, 0, j))).Float64(); value != expected {
+			t.Fatalf("candidate %d is %f instead of %f", j, value, expected)
+		}
+	}
+
+	err = pool.Run(context.Background(), 64, func(w *Worker[[]int], j int) error {
+		switch j {
+		case 5:
+			return fmt.Errorf("candidate 5")
+		case 9:
+			panic("candidate 9")
+		}
+		return nil
+	})
+	if err == nil || err.Error() != "candidate 5" {
+		t.Fatalf("error %v", err)
+	}
+	err = pool.Run(context.Background(), 8, func(w *Worker[[]int], j int) error {
+		if j == 3 {
+			var m map[int]int
+			m[j] = j
+		}
+		return nil
+	})
+	if err == nil || !strings.Contains(err.Error(), "candidate 3 panicked") {
+		t.Fatalf("error %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	evaluated := 0
+	var mutex sync.Mutex
+	err = pool.Run(ctx, 1024, func(w *Worker[[]int], j int) error {
+		mutex.Lock()
+		defer mutex.Unlock()
+		evaluated++
+		cancel()
+		return nil
+	})
+	if err != context.Canceled || evaluated >= 1024 {
+		t.Fatalf("error %v after %d candidates", err, evaluated)
+	}
+}
@@ -0,0 +1,235 @@
+// Copyright 2023 The RNN Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package trnn
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+
+	. "github.com/pointlander/rnn/matrix/f32"
+	"github.com/pointlander/rnn/model"
+	"github.com/pointlander/rnn/tokenizer"
+)
+
+// Architecture is the architecture name of the model files
+const Architecture = "trnn"
+
+// Model converts the network and its tokenizer into a model
+func (n Network) Model(t tokenizer.Tokenizer) *model.Model {
+	m := model.New(Architecture)
+	m.Config["width"] = Width
+	m.Config["context"] = Context
+	m.Config["symbols"] = n.DecoderWeights.Rows
+	m.SetTokenizer(t)
+	m.Metadata["loss"] = strconv.FormatFloat(n.Loss, 'g', -1, 64)
+	m.AddMatrix("Embedding", n.Embedding.Matrix)
+	m.AddMatrix("EncoderBias", n.EncoderBias)
+	m.AddMatrix("Q", n.Q)
+	m.AddMatrix("K", n.K)
+	m.AddMatrix("V", n.V)
+	m.AddMatrix("DecoderWeights", n.DecoderWeights)
+	m.AddMatrix("DecoderBias", n.DecoderBias)
+	return m
+}
+
+// NewNetwork creates a network and its tokenizer from a model
+func NewNetwork(m *model.Model) (Network, tokenizer.Tokenizer, error) {
+	var n Network
+	err := m.Check(Architecture, map[string]int{"width": Width, "context": Context})
+	if err != nil {
+		return n, nil, err
+	}
+	t, err := m.GetTokenizer()
+	if err != nil {
+		return n, nil, err
+	}
+	symbols := m.Config["symbols"]
+	if symbols != t.Size() {
+		return n, nil, fmt.Errorf("model has %d symbols but the tokenizer has %d", symbols, t.Size())
+	}
+	embedding, err := m.Matrix("Embedding", Width, symbols)
+	if err != nil {
+		return n, nil, err
+	}
+	n.Embedding = Embedding{Matrix: embedding}
+	if n.EncoderBias, err = m.Matrix("EncoderBias", 1, EncoderRows); err != nil {
+		return n, nil, err
+	}
+	if n.Q, err = m.Matrix("Q", 2*Width, Width); err != nil {
+		return n, nil, err
+	}
+	if n.K, err = m.Matrix("K", 2*Width, Width); err != nil {
+		return n, nil, err
+	}
+	if n.V, err = m.Matrix("V", 2*Width, Width); err != nil {
+		return n, nil, err
+	}
+	if n.DecoderWeights, err = m.Matrix("DecoderWeights", DecoderCols, symbols); err != nil {
+		return n, nil, err
+	}
+	if n.DecoderBias, err = m.Matrix("DecoderBias", 1, symbols); err != nil {
+		return n, nil, err
+	}
+	if loss, ok := m.Metadata["loss"]; ok {
+		n.Loss, _ = strconv.ParseFloat(loss, 64)
+	}
+	return n, t, nil
+}
+
+// Save saves the network and its tokenizer with training metadata
+func (n Network) Save(name string, t tokenizer.Tokenizer, metadata map[string]string) error {
+	m := n.Model(t)
+	for key, value := range metadata {
+		m.Metadata[key] = value
+	}
+	return model.Save(name, m)
+}
+
+// Load loads a network and its tokenizer, migrating networks saved as bare gob
+func Load(name string) (Network, tokenizer.Tokenizer, error) {
+	m, err := model.Load(name)
+	if errors.Is(err, model.ErrNotModel) {
+		return loadGob(name)
+	} else if err != nil {
+		return Network{}, nil, err
+	}
+	return NewNetwork(m)
+}
+
+// loadGob migrates a byte level network saved as gob with a one-hot encoder input
+func loadGob(name string) (Network, tokenizer.Tokenizer, error) {
+	var n Network
+	input, err := os.Open(name)
+	if err != nil {
+		return n, nil, err
+	}
+	defer input.Close()
+	var legacy struct {
+		EncoderWeights Matrix
+		EncoderBias    Matrix
+		Q              Matrix
+		K              Matrix
+		V              Matrix
+		DecoderWeights Matrix
+		DecoderBias    Matrix
+		Loss           float64
+	}
+	err = gob.NewDecoder(input).Decode(&legacy)
+	if err != nil {
+		return n, nil, fmt.Errorf("%s is neither a model nor a gob network: %w", name, err)
+	}
+	const symbols = 256
+	if legacy.EncoderWeights.Cols != symbols || legacy.EncoderWeights.Rows != EncoderRows ||
+		legacy.DecoderWeights.Cols != DecoderCols || legacy.DecoderWeights.Rows != symbols ||
+		len(legacy.EncoderBias.Data) != EncoderRows || len(legacy.DecoderBias.Data) != symbols {
+		return n, nil, fmt.Errorf("%s is not a trnn network", name)
+	}
+	for _, m := range []Matrix{legacy.Q, legacy.K, legacy.V} {
+		if m.Cols != 2*Width || m.Rows != Width {
+			return n, nil, fmt.Errorf("%s is not a trnn network", name)
+		}
+	}
+
+	// the one-hot input selected a column of the encoder, which is a row of the embedding
+	n.Embedding = NewEmbedding(0, Width, symbols)
+	for s := 0; s < symbols; s++ {
+		for i := 0; i < EncoderRows; i++ {
+			n.Embedding.Data = append(n.Embedding.Data, legacy.EncoderWeights.Data[i*symbols+s])
+		}
+	}
+	n.EncoderBias = legacy.EncoderBias
+	n.Q = legacy.Q
+	n.K = legacy.K
+	n.V = legacy.V
+	n.DecoderWeights = legacy.DecoderWeights
+	n.DecoderBias = legacy.DecoderBias
+	n.Loss = legacy.Loss
+	return n, tokenizer.Byte{}, nil
+}
+
+// DistributionArchitecture is the architecture name of the distribution files
+const DistributionArchitecture = Architecture + ".distribution"
+
+// Model converts the distribution and its tokenizer into a model
+func (d Distribution) Model(t tokenizer.Tokenizer) *model.Model {
+	m := model.New(DistributionArchitecture)
+	m.SetTokenizer(t)
+	m.Config["width"] = Width
+	m.Config["context"] = Context
+	m.Config["symbols"] = d.Symbols
+	m.AddRandom("Embedding", d.Symbols, Width, d.Embedding)
+	m.AddRandom("EncoderBias", EncoderRows, 1, d.EncoderBias)
+	m.AddRandom("Q", Width, 2*Width, d.Q)
+	m.AddRandom("K", Width, 2*Width, d.K)
+	m.AddRandom("V", Width, 2*Width, d.V)
+	m.AddRandom("DecoderWeights", d.Symbols, DecoderCols, d.DecoderWeights)
+	m.AddRandom("DecoderBias", d.Symbols, 1, d.DecoderBias)
+	return m
+}
+
+// DistributionFromModel creates a distribution and its tokenizer from a model
+func DistributionFromModel(m *model.Model) (Distribution, tokenizer.Tokenizer, error) {
+	d, err := distributionFromModel(m)
+	if err != nil {
+		return d, nil, err
+	}
+	t, err := m.GetTokenizer()
+	if err != nil {
+		return d, nil, err
+	}
+	if t.Size() != d.Symbols {
+		return d, nil, fmt.Errorf("distribution has %d symbols but the tokenizer has %d", d.Symbols, t.Size())
+	}
+	return d, t, nil
+}
+
+// Save saves the distribution and its tokenizer
+func (d Distribution) Save(name string, t tokenizer.Tokenizer) error {
+	return model.Save(name, d.Model(t))
+}
+
+// LoadDistribution loads a distribution and its tokenizer
+func LoadDistribution(name string) (Distribution, tokenizer.Tokenizer, error) {
+	m, err := model.Load(name)
+	if err != nil {
+		return Distribution{}, nil, err
+	}
+	return DistributionFromModel(m)
+}
+
+func distributionFromModel(m *model.Model) (Distribution, error) {
+	d := Distribution{
+		Symbols: m.Config["symbols"],
+	}
+	err := m.Check(DistributionArchitecture, map[string]int{"width": Width, "context": Context})
+	if err != nil {
+		return d, err
+	}
+	if d.Embedding, err = m.GetRandom("Embedding", d.Symbols, Width); err != nil {
+		return d, err
+	}
+	if d.EncoderBias, err = m.GetRandom("EncoderBias", EncoderRows, 1); err != nil {
+		return d, err
+	}
+	if d.Q, err = m.GetRandom("Q", Width, 2*Width); err != nil {
+		return d, err
+	}
+	if d.K, err = m.GetRandom("K", Width, 2*Width); err != nil {
+		return d, err
+	}
+	if d.V, err = m.GetRandom("V", Width, 2*Width); err != nil {
+		return d, err
+	}
+	if d.DecoderWeights, err = m.GetRandom("DecoderWeights", d.Symbols, DecoderCols); err != nil {
+		return d, err
+	}
+	if d.DecoderBias, err = m.GetRandom("DecoderBias", d.Symbols, 1); err != nil {
+		return d, err
+	}
+	return d, nil
+}
@@ -5,47 +5,41 @@
 package trnn
 
 import (
-	"compress/gzip"
-	"encoding/gob"
+	"context"
 	"fmt"
-	"io/ioutil"
 	"math"
 	"math/rand"
-	"os"
-	"runtime"
 	"sort"
+	"strconv"
+	"time"
 
+	"github.com/pointlander/rnn/corpus"
 	. "github.com/pointlander/rnn/matrix/f32"
+	"github.com/pointlander/rnn/model"
+	"github.com/pointlander/rnn/tokenizer"
+	"github.com/pointlander/rnn/train"
 )
 
 const (
-	// Window is the size of the window
+	// Window is the default size of the window
 	Window = 8
 	// Width is the width of the network
 	Width = 256
-	// EncoderCols is the number of encoder columns
-	EncoderCols = Width
+	// Context is the number of symbols the attention state holds
+	Context = 256
 	// EncoderRows is the number of encoder rows
 	EncoderRows = Width
-	// EncoderSize is the number of encoder parameters
-	EncoderSize = EncoderCols * EncoderRows
 	// DecoderCols is the number of decoder columns
 	DecoderCols = Width
-	// DecoderRows is the number of decoder rows
-	DecoderRows = Width
-	// DecoderSize is the number of decoder parameters
-	DecoderSize = DecoderCols * DecoderRows
 )
 
 // Random is a random variable
-type Random struct {
-	Mean   float64
-	Stddev float64
-}
+type Random = model.Random
 
 // Distribution is a distribution of a neural network
 type Distribution struct {
-	EncoderWeights []Random
+	Symbols        int
+	Embedding      []Random
 	EncoderBias    []Random
 	Q              []Random
 	K              []Random
@@ -54,14 +48,16 @@ type Distribution struct {
 	DecoderBias    []Random
 }
 
-// NewDistribution creates a new distribution
-func NewDistribution(rng *rand.Rand) Distribution {
-	var d Distribution
-	//factor := math.Sqrt(2.0 / float64(EncoderCols))
-	for i := 0; i < EncoderSize; i++ {
-		d.EncoderWeights = append(d.EncoderWeights, Random{
+// NewDistribution creates a new distribution for a vocabulary of symbols with weights of standard deviation stddev
+func NewDistribution(rng *rand.Rand, symbols int, stddev float64) Distribution {
+	d := Distribution{
+		Symbols: symbols,
+	}
+	//factor := math.Sqrt(2.0 / float64(symbols))
+	for i := 0; i < symbols*EncoderRows; i++ {
+		d.Embedding = append(d.Embedding, Random{
 			Mean:   0,
-			Stddev: .01,
+			Stddev: stddev,
 		})
 	}
 	for i := 0; i < EncoderRows; i++ {
@@ -74,29 +70,29 @@ func NewDistribution(rng *rand.Rand) Distribution {
 	for i := 0; i < 2*Width*Width; i++ {
 		d.Q = append(d.Q, Random{
 			Mean:   0,
-			Stddev: .01,
+			Stddev: stddev,
 		})
 	}
 	for i := 0; i < 2*Width*Width; i++ {
 		d.K = append(d.K, Random{
 			Mean:   0,
-			Stddev: .01,
+			Stddev: stddev,
 		})
 	}
 	for i := 0; i < 2*Width*Width; i++ {
 		d.V = append(d.V, Random{
 			Mean:   0,
-			Stddev: .01,
+			Stddev: stddev,
 		})
 	}
 	//factor = math.Sqrt(2.0 / float64(DecoderCols))
-	for i := 0; i < DecoderSize; i++ {
+	for i := 0; i < DecoderCols*symbols; i++ {
 		d.DecoderWeights = append(d.DecoderWeights, Random{
 			Mean:   0,
-			Stddev: .01,
+			Stddev: stddev,
 		})
 	}
-	for i := 0; i < DecoderRows; i++ {
+	for i := 0; i < symbols; i++ {
 		d.DecoderBias = append(d.DecoderBias, Random{
 			Mean:   0,
 			Stddev: .01,
@@ -107,7 +103,7 @@ func NewDistribution(rng *rand.Rand) Distribution {
 
 // Network is a neural network
 type Network struct {
-	EncoderWeights Matrix
+	Embedding      Embedding
 	EncoderBias    Matrix
 	Q              Matrix
 	K              Matrix
@@ -115,16 +111,33 @@ type Network struct {
 	DecoderWeights Matrix
 	DecoderBias    Matrix
 	Loss           float64
+	// candidate is the index of the network in its generation, the network is sampled from the seed of the index
+	candidate int
+}
+
+// Stddev returns the mean standard deviation of the random variables of the distribution
+func (d Distribution) Stddev() float64 {
+	sum, count := 0.0, 0
+	for _, randoms := range [][]Random{d.Embedding, d.EncoderBias, d.Q, d.K, d.V, d.DecoderWeights, d.DecoderBias} {
+		for _, r := range randoms {
+			sum += r.Stddev
+		}
+		count += len(randoms)
+	}
+	if count == 0 {
+		return 0
+	}
+	return sum / float64(count)
 }
 
 // Sample samples a network from the distribution
 func (d Distribution) Sample(rng *rand.Rand) Network {
 	var n Network
-	n.EncoderWeights = NewMatrix(0, EncoderCols, EncoderRows)
+	n.Embedding = NewEmbedding(0, Width, d.Symbols)
 	n.EncoderBias = NewMatrix(0, 1, EncoderRows)
-	for i := 0; i < EncoderSize; i++ {
-		r := d.EncoderWeights[i]
-		n.EncoderWeights.Data = append(n.EncoderWeights.Data, float32(rng.NormFloat64()*r.Stddev+r.Mean))
+	for i := 0; i < d.Symbols*EncoderRows; i++ {
+		r := d.Embedding[i]
+		n.Embedding.Data = append(n.Embedding.Data, float32(rng.NormFloat64()*r.Stddev+r.Mean))
 	}
 	for i := 0; i < EncoderRows; i++ {
 		r := d.EncoderBias[i]
@@ -145,13 +158,13 @@ func (d Distribution) Sample(rng *rand.Rand) Network {
 		r := d.V[i]
 		n.V.Data = append(n.V.Data, float32(rng.NormFloat64()*r.Stddev+r.Mean))
 	}
-	n.DecoderWeights = NewMatrix(0, DecoderCols, DecoderRows)
-	n.DecoderBias = NewMatrix(0, 1, DecoderRows)
-	for i := 0; i < DecoderSize; i++ {
+	n.DecoderWeights = NewMatrix(0, DecoderCols, d.Symbols)
+	n.DecoderBias = NewMatrix(0, 1, d.Symbols)
+	for i := 0; i < DecoderCols*d.Symbols; i++ {
 		r := d.DecoderWeights[i]
 		n.DecoderWeights.Data = append(n.DecoderWeights.Data, float32(rng.NormFloat64()*r.Stddev+r.Mean))
 	}
-	for i := 0; i < DecoderRows; i++ {
+	for i := 0; i < d.Symbols; i++ {
 		r := d.DecoderBias[i]
 		n.DecoderBias.Data = append(n.DecoderBias.Data, float32(rng.NormFloat64()*r.Stddev+r.Mean))
 	}
@@ -159,309 +172,464 @@ func (d Distribution) Sample(rng *rand.Rand) Network {
 	return n
 }
 
+// State is the attention state of a network reading a sequence of symbols
+type State struct {
+	Network *Network
+	Q       Matrix
+	V       Matrix
+	Index   int
+	// Attend records the attention of each symbol over the context slots
+	Attend bool
+	// Attention is the attention of the last symbol over the context slots if Attend is true
+	Attention []float32
+}
+
+// NewState creates the state of the network at the start of a sequence
+func (n *Network) NewState() *State {
+	q := NewMatrix(0, Width, Context)
+	q.Data = q.Data[:cap(q.Data)]
+	v := NewMatrix(0, Width, Context)
+	v.Data = v.Data[:cap(v.Data)]
+	return &State{
+		Network: n,
+		Q:       q,
+		V:       v,
+	}
+}
+
+// Next feeds a symbol into the state and returns the probabilities of the next symbol
+func (s *State) Next(symbol int) []float32 {
+	n := s.Network
+	encoded := EverettActivation(Add(n.Embedding.Lookup(symbol), n.EncoderBias))
+	q := MulT(n.Q, encoded)
+	k := MulT(n.K, encoded)
+	v := MulT(n.V, encoded)
+	copy(s.Q.Data[s.Index*Width:], q.Data)
+	copy(s.V.Data[s.Index*Width:], v.Data)
+	a := SelfAttention(s.Q, k, s.V)
+	if s.Attend {
+		s.Attention = Attention(s.Q, k).Data
+	}
+	decoded := TaylorSoftmax(Add(MulT(n.DecoderWeights, a), n.DecoderBias))
+	s.Index = (s.Index + 1) % Context
+	return decoded.Data
+}
+
 // Inference run inference on the network
-func (n *Network) Inference(data []byte) {
-	rng := rand.New(rand.NewSource(1))
+func (n *Network) Inference(data []int) {
+	n.Evaluate(data, NewScratch())
+}
+
+// Scratch is the inference buffers of a worker, they are reused across the networks it evaluates
+type Scratch struct {
+	// Windows is the number of windows of Length symbols the loss is computed over
+	Windows  int
+	Length   int
+	Rand     *rand.Rand
+	Q        Matrix
+	V        Matrix
+	Expected []float64
+}
+
+// NewScratch creates the inference buffers of a worker
+func NewScratch() *Scratch {
+	q := NewMatrix(0, Width, Context)
+	q.Data = q.Data[:cap(q.Data)]
+	v := NewMatrix(0, Width, Context)
+	v.Data = v.Data[:cap(v.Data)]
+	return &Scratch{
+		Windows: 1024,
+		Length:  1024,
+		Rand:    rand.New(rand.NewSource(1)),
+		Q:       q,
+		V:       v,
+	}
+}
+
+// Evaluate runs inference on the network with the buffers of a worker
+func (n *Network) Evaluate(data []int, s *Scratch) {
+	rng := s.Rand
+	rng.Seed(1)
+	symbols := n.DecoderWeights.Rows
+	if len(s.Expected) != symbols {
+		s.Expected = make([]float64, symbols)
+	}
 	loss := 0.0
-	for i := 0; i < 1024; i++ {
-		begin := rng.Intn(len(data) - 1024)
-		end := begin + 1024
-		input := NewMatrix(0, 256, 1)
-		input.Data = input.Data[:cap(input.Data)]
-		qState := NewMatrix(0, Width, 256)
-		qState.Data = qState.Data[:cap(qState.Data)]
-		vState := NewMatrix(0, Width, 256)
-		vState.Data = vState.Data[:cap(vState.Data)]
-		expected := make([]float64, 256)
+	for i := 0; i < s.Windows; i++ {
+		begin := rng.Intn(len(data) - s.Length)
+		end := begin + s.Length
+		qState, vState := s.Q, s.V
+		for i := range qState.Data {
+			qState.Data[i] = 0
+		}
+		for i := range vState.Data {
+			vState.Data[i] = 0
+		}
+		expected := s.Expected
 		index := 0
 		x := data[begin:end]
-		for s, symbol := range x[:len(x)-1] {
-			for i := 0; i < 256; i++ {
-				input.Data[i] = 0
-			}
-			input.Data[int(symbol)] = 1
-			encoded := EverettActivation(Add(MulT(n.EncoderWeights, input), n.EncoderBias))
-			q := MulT(n.Q, encoded)
-			k := MulT(n.K, encoded)
-			v := MulT(n.V, encoded)
-			for i, v := range q.Data {
-				qState.Data[index*Width+i] = v
-			}
-			for i, v := range v.Data {
-				vState.Data[index*Width+i] = v
+		// the queries, keys and values only depend on the symbols so they are computed for the whole window at once
+		encoded := EverettActivation(Add(n.Embedding.Gather(x[:len(x)-1]), n.EncoderBias))
+		queries, keys, values := MulT(n.Q, encoded), MulT(n.K, encoded), MulT(n.V, encoded)
+		for s := range x[:len(x)-1] {
+			copy(qState.Data[index*Width:], queries.Data[s*Width:(s+1)*Width])
+			copy(vState.Data[index*Width:], values.Data[s*Width:(s+1)*Width])
+			k := Matrix{
+				Cols: Width,
+				Rows: 1,
+				Data: keys.Data[s*Width : (s+1)*Width],
 			}
 			a := SelfAttention(qState, k, vState)
 			decoded := TaylorSoftmax(Add(MulT(n.DecoderWeights, a), n.DecoderBias))
 			for i := range expected {
 				expected[i] = 0
 			}
-			expected[int(x[s+1])] = 1
+			expected[x[s+1]] = 1
 			sum := 0.0
-			for i := 0; i < 256; i++ {
+			for i := 0; i < symbols; i++ {
 				diff := expected[i] - float64(decoded.Data[i])
 				sum += diff * diff
 			}
-			index = (index + 1) % 256
-			loss += sum / 256
+			index = (index + 1) % Context
+			loss += sum / float64(symbols)
 		}
 	}
 	n.Loss = loss
 }
 
-// Learn learns the mode
-func Learn() {
-	rng := rand.New(rand.NewSource(1))
-	input, err := os.Open("pg10.txt.gz")
+// Defaults are the default options of Learn
+var Defaults = train.Options{
+	Seed:        1,
+	Population:  128,
+	Generations: 128,
+	Window:      Window,
+	Elite:       64,
+	Data:        "pg10.txt.gz",
+	Output:      "trnn.model",
+	Stddev:      .01,
+}
+
+// Trainer learns a network from text with a tokenizer
+type Trainer struct {
+	Tokenizer tokenizer.Tokenizer
+	Options   train.Options
+	Observer  train.Observer
+	// Remote evaluates the networks on worker processes if it is not nil
+	Remote *Coordinator
+}
+
+// Learn learns the mode using the tokenizer t, the networks are evaluated on the worker processes
+// at the remote addresses if there are any, if the context is done the best network
+// and the distribution so far are saved
+func Learn(ctx context.Context, t tokenizer.Tokenizer, options train.Options, remote []string) {
+	options = options.Merge(Defaults)
+	text, err := corpus.Load(options.Data)
 	if err != nil {
 		panic(err)
 	}
-	defer input.Close()
-	reader, err := gzip.NewReader(input)
+	progress, err := train.NewProgress(options)
 	if err != nil {
 		panic(err)
 	}
-	defer reader.Close()
-	data, err := ioutil.ReadAll(reader)
+	defer progress.Close()
+	trainer := Trainer{
+		Tokenizer: t,
+		Options:   options,
+		Observer:  progress.Observe,
+	}
+	if len(remote) > 0 {
+		trainer.Remote, err = Dial(remote)
+		if err != nil {
+			panic(err)
+		}
+		defer trainer.Remote.Close()
+	}
+	best, distribution, err := trainer.Train(ctx, text)
+	if err != nil && !train.Interrupted(err) {
+		panic(err)
+	}
+	if progress.Improved {
+		err = best.Save(options.Output, t, map[string]string{
+			"corpus":      options.Data,
+			"seed":        strconv.FormatInt(options.Seed, 10),
+			"population":  strconv.Itoa(options.Population),
+			"generations": strconv.Itoa(options.Generations),
+			"window":      strconv.Itoa(options.Window),
+			"created":     time.Now().UTC().Format(time.RFC3339),
+		})
+		if err != nil {
+			panic(err)
+		}
+	}
+	err = distribution.Save(options.Distribution(), t)
 	if err != nil {
 		panic(err)
 	}
+}
+
+// Train learns a network from the text, it returns the best network and the distribution
+// so far with the error of the context if the context is done
+func (t Trainer) Train(ctx context.Context, text []byte) (Network, Distribution, error) {
+	options := t.Options.Merge(Defaults)
+	if err := options.Validate(); err != nil {
+		return Network{}, Distribution{}, err
+	}
+	if t.Tokenizer == nil {
+		return Network{}, Distribution{}, fmt.Errorf("no tokenizer")
+	}
+	rng := rand.New(rand.NewSource(options.Seed))
+	data := t.Tokenizer.Encode(text)
 
 	//data = data[:1024]
 
-	distribution := NewDistribution(rng)
-	networks := make([]Network, 128)
+	distribution := NewDistribution(rng, t.Tokenizer.Size(), options.Stddev)
+	if t.Remote != nil {
+		if err := t.Remote.Load(ctx, Shard{Data: data}); err != nil {
+			return Network{}, Distribution{}, err
+		}
+		if err := t.Remote.Start(ctx, options.Seed, distribution); err != nil {
+			return Network{}, Distribution{}, err
+		}
+	}
+	networks := make([]Network, options.Population)
 	best := Network{}
 	minLoss := math.MaxFloat64
-	done := make(chan bool, 8)
-	cpus := runtime.NumCPU()
-	inference := func(data []byte, j int) {
-		networks[j].Inference(data)
-		done <- true
+	pool := train.NewPool(options.Workers, NewScratch)
+	defer pool.Close()
+	window := options.Window
+	inference := func(rng *rand.Rand, s *Scratch, data []int, j int) {
+		networks[j] = distribution.Sample(rng)
+		networks[j].candidate = j
+		networks[j].Evaluate(data, s)
 	}
-	for i := 0; i < 128; i++ {
-		for j := range networks {
-			networks[j] = distribution.Sample(rng)
-		}
-		k, flight := 0, 0
-		for j := 0; j < cpus && k < len(networks); j++ {
-			go inference(data, k)
-			flight++
-			k++
-		}
-		for k < len(networks) {
-			<-done
-			fmt.Printf(".")
-			flight--
-			go inference(data, k)
-			flight++
-			k++
-		}
-		for flight > 0 {
-			fmt.Printf(".")
-			<-done
-			flight--
-		}
-		fmt.Println()
+	for i := 0; i < options.Generations; i++ {
+		if err := ctx.Err(); err != nil {
+			return best, distribution, err
+		}
+		if options.Snapshot > 0 && i%options.Snapshot == 0 {
+			err := distribution.Save(options.SnapshotName(i), t.Tokenizer)
+			if err != nil {
+				return best, distribution, err
+			}
+		}
+		var err error
+		if t.Remote != nil {
+			// the workers sample the networks from their seeds and only send back the losses
+			var losses []float64
+			losses, err = t.Remote.Generation(ctx, i, len(networks))
+			for j, loss := range losses {
+				networks[j] = Network{Loss: loss, candidate: j}
+			}
+		} else {
+			err = pool.Run(ctx, len(networks), func(w *train.Worker[*Scratch], j int) error {
+				inference(w.Seed(train.Seed(options.Seed, i, j)), w.Scratch, data, j)
+				return nil
+			})
+		}
+		if err != nil {
+			return best, distribution, err
+		}
 		sort.Slice(networks, func(i, j int) bool {
 			return networks[i].Loss < networks[j].Loss
 		})
 		min, index := math.MaxFloat64, 0
-		for j := 0; j < 64-Window; j++ {
+		for j := 0; j < options.Elite-window; j++ {
 			mean := 0.0
-			for k := 0; k < Window; k++ {
+			for k := 0; k < window; k++ {
 				mean += networks[j+k].Loss
 			}
-			mean /= Window
+			mean /= float64(window)
 			stddev := 0.0
-			for k := 0; k < Window; k++ {
+			for k := 0; k < window; k++ {
 				diff := mean - networks[j+k].Loss
 				stddev += diff * diff
 			}
-			stddev /= Window
+			stddev /= float64(window)
 			stddev = math.Sqrt(stddev)
 			if stddev < min {
 				min, index = stddev, j
 			}
 		}
-		if networks[index].Loss < minLoss {
+		losses := make([]float64, len(networks))
+		for j := range networks {
+			losses[j] = networks[j].Loss
+		}
+		stats := train.NewStats(i, losses)
+		stats.Spread = distribution.Stddev()
+		stats.Elite, stats.Stddev = index, min
+		improved := networks[index].Loss < minLoss
+		elite := make([]int, window)
+		for j := range elite {
+			elite[j] = networks[index+j].candidate
+		}
+		if improved && t.Remote != nil {
+			// only the losses of the networks are known, the elite window is sampled again from its seeds
+			for j, n := range distribution.Elite(options.Seed, i, elite) {
+				n.Loss = networks[index+j].Loss
+				networks[index+j] = n
+			}
+		}
+		if improved {
 			best = networks[index]
 			minLoss = networks[index].Loss
-		} else {
+			stats.Improved = true
+		}
+		stats.Loss = minLoss
+		t.Observer.Observe(stats)
+		if !stats.Improved {
 			continue
 		}
-		fmt.Println(min, index, networks[index].Loss)
-		next := Distribution{
-			EncoderWeights: make([]Random, len(distribution.EncoderWeights)),
-			EncoderBias:    make([]Random, len(distribution.EncoderBias)),
-			Q:              make([]Random, len(distribution.Q)),
-			K:              make([]Random, len(distribution.K)),
-			V:              make([]Random, len(distribution.V)),
-			DecoderWeights: make([]Random, len(distribution.DecoderWeights)),
-			DecoderBias:    make([]Random, len(distribution.DecoderBias)),
-		}
-		for j := 0; j < 8; j++ {
-			for k, value := range networks[index+j].EncoderWeights.Data {
-				next.EncoderWeights[k].Mean += float64(value)
-			}
-			for k, value := range networks[index+j].EncoderBias.Data {
-				next.EncoderBias[k].Mean += float64(value)
-			}
-			for k, value := range networks[index+j].Q.Data {
-				next.Q[k].Mean += float64(value)
-			}
-			for k, value := range networks[index+j].K.Data {
-				next.K[k].Mean += float64(value)
-			}
-			for k, value := range networks[index+j].V.Data {
-				next.V[k].Mean += float64(value)
-			}
-			for k, value := range networks[index+j].DecoderWeights.Data {
-				next.DecoderWeights[k].Mean += float64(value)
-			}
-			for k, value := range networks[index+j].DecoderBias.Data {
-				next.DecoderBias[k].Mean += float64(value)
+		distribution = distribution.Fit(networks[index : index+window])
+		if t.Remote != nil {
+			if err := t.Remote.Update(ctx, i, elite); err != nil {
+				return best, distribution, err
 			}
 		}
-		for j := range next.EncoderWeights {
-			next.EncoderWeights[j].Mean /= Window
+	}
+	return best, distribution, nil
+}
+
+// Elite samples the networks of the elite window of a generation from their seeds
+func (d Distribution) Elite(seed int64, generation int, elite []int) []Network {
+	networks := make([]Network, len(elite))
+	for j, candidate := range elite {
+		networks[j] = d.Sample(rand.New(rand.NewSource(train.Seed(seed, generation, candidate))))
+		networks[j].candidate = candidate
+	}
+	return networks
+}
+
+// Fit estimates the distribution of the elite networks
+func (d Distribution) Fit(elite []Network) Distribution {
+	window := len(elite)
+	next := Distribution{
+		Symbols:        d.Symbols,
+		Embedding:      make([]Random, len(d.Embedding)),
+		EncoderBias:    make([]Random, len(d.EncoderBias)),
+		Q:              make([]Random, len(d.Q)),
+		K:              make([]Random, len(d.K)),
+		V:              make([]Random, len(d.V)),
+		DecoderWeights: make([]Random, len(d.DecoderWeights)),
+		DecoderBias:    make([]Random, len(d.DecoderBias)),
+	}
+	for j := 0; j < window; j++ {
+		for k, value := range elite[j].Embedding.Data {
+			next.Embedding[k].Mean += float64(value)
 		}
-		for j := range next.EncoderBias {
-			next.EncoderBias[j].Mean /= Window
+		for k, value := range elite[j].EncoderBias.Data {
+			next.EncoderBias[k].Mean += float64(value)
 		}
-		for j := range next.Q {
-			next.Q[j].Mean /= Window
+		for k, value := range elite[j].Q.Data {
+			next.Q[k].Mean += float64(value)
 		}
-		for j := range next.K {
-			next.K[j].Mean /= Window
+		for k, value := range elite[j].K.Data {
+			next.K[k].Mean += float64(value)
 		}
-		for j := range next.V {
-			next.V[j].Mean /= Window
+		for k, value := range elite[j].V.Data {
+			next.V[k].Mean += float64(value)
 		}
-		for j := range next.DecoderWeights {
-			next.DecoderWeights[j].Mean /= Window
+		for k, value := range elite[j].DecoderWeights.Data {
+			next.DecoderWeights[k].Mean += float64(value)
 		}
-		for j := range next.DecoderBias {
-			next.DecoderBias[j].Mean /= Window
+		for k, value := range elite[j].DecoderBias.Data {
+			next.DecoderBias[k].Mean += float64(value)
 		}
-		for j := 0; j < Window; j++ {
-			for k, value := range networks[index+j].EncoderWeights.Data {
-				diff := next.EncoderWeights[k].Mean - float64(value)
-				next.EncoderWeights[k].Stddev += diff * diff
-			}
-			for k, value := range networks[index+j].EncoderBias.Data {
-				diff := next.EncoderBias[k].Mean - float64(value)
-				next.EncoderBias[k].Stddev += diff * diff
-			}
-			for k, value := range networks[index+j].Q.Data {
-				diff := next.Q[k].Mean - float64(value)
-				next.Q[k].Stddev += diff * diff
-			}
-			for k, value := range networks[index+j].K.Data {
-				diff := next.K[k].Mean - float64(value)
-				next.K[k].Stddev += diff * diff
-			}
-			for k, value := range networks[index+j].V.Data {
-				diff := next.V[k].Mean - float64(value)
-				next.V[k].Stddev += diff * diff
-			}
-			for k, value := range networks[index+j].DecoderWeights.Data {
-				diff := next.DecoderWeights[k].Mean - float64(value)
-				next.DecoderWeights[k].Stddev += diff * diff
-			}
-			for k, value := range networks[index+j].DecoderBias.Data {
-				diff := next.DecoderBias[k].Mean - float64(value)
-				next.DecoderBias[k].Stddev += diff * diff
-			}
-		}
-		for j := range next.EncoderWeights {
-			next.EncoderWeights[j].Stddev /= Window
-			next.EncoderWeights[j].Stddev = math.Sqrt(next.EncoderWeights[j].Stddev)
+	}
+	for j := range next.Embedding {
+		next.Embedding[j].Mean /= float64(window)
+	}
+	for j := range next.EncoderBias {
+		next.EncoderBias[j].Mean /= float64(window)
+	}
+	for j := range next.Q {
+		next.Q[j].Mean /= float64(window)
+	}
+	for j := range next.K {
+		next.K[j].Mean /= float64(window)
+	}
+	for j := range next.V {
+		next.V[j].Mean /= float64(window)
+	}
+	for j := range next.DecoderWeights {
+		next.DecoderWeights[j].Mean /= float64(window)
+	}
+	for j := range next.DecoderBias {
+		next.DecoderBias[j].Mean /= float64(window)
+	}
+	for j := 0; j < window; j++ {
+		for k, value := range elite[j].Embedding.Data {
+			diff := next.Embedding[k].Mean - float64(value)
+			next.Embedding[k].Stddev += diff * diff
 		}
-		for j := range next.EncoderBias {
-			next.EncoderBias[j].Stddev /= Window
-			next.EncoderBias[j].Stddev = math.Sqrt(next.EncoderBias[j].Stddev)
+		for k, value := range elite[j].EncoderBias.Data {
+			diff := next.EncoderBias[k].Mean - float64(value)
+			next.EncoderBias[k].Stddev += diff * diff
 		}
-		for j := range next.Q {
-			next.Q[j].Stddev /= Window
-			next.Q[j].Stddev = math.Sqrt(next.Q[j].Stddev)
+		for k, value := range elite[j].Q.Data {
+			diff := next.Q[k].Mean - float64(value)
+			next.Q[k].Stddev += diff * diff
 		}
-		for j := range next.K {
-			next.K[j].Stddev /= Window
-			next.K[j].Stddev = math.Sqrt(next.K[j].Stddev)
+		for k, value := range elite[j].K.Data {
+			diff := next.K[k].Mean - float64(value)
+			next.K[k].Stddev += diff * diff
 		}
-		for j := range next.V {
-			next.V[j].Stddev /= Window
-			next.V[j].Stddev = math.Sqrt(next.V[j].Stddev)
+		for k, value := range elite[j].V.Data {
+			diff := next.V[k].Mean - float64(value)
+			next.V[k].Stddev += diff * diff
 		}
-		for j := range next.DecoderWeights {
-			next.DecoderWeights[j].Stddev /= Window
-			next.DecoderWeights[j].Stddev = math.Sqrt(next.DecoderWeights[j].Stddev)
+		for k, value := range elite[j].DecoderWeights.Data {
+			diff := next.DecoderWeights[k].Mean - float64(value)
+			next.DecoderWeights[k].Stddev += diff * diff
 		}
-		for j := range next.DecoderBias {
-			next.DecoderBias[j].Stddev /= Window
-			next.DecoderBias[j].Stddev = math.Sqrt(next.DecoderBias[j].Stddev)
+		for k, value := range elite[j].DecoderBias.Data {
+			diff := next.DecoderBias[k].Mean - float64(value)
+			next.DecoderBias[k].Stddev += diff * diff
 		}
-		distribution = next
 	}
-	output, err := os.Create("network.gob")
-	if err != nil {
-		panic(err)
+	for j := range next.Embedding {
+		next.Embedding[j].Stddev /= float64(window)
+		next.Embedding[j].Stddev = math.Sqrt(next.Embedding[j].Stddev)
 	}
-	defer output.Close()
-	encoder := gob.NewEncoder(output)
-	err = encoder.Encode(best)
-	if err != nil {
-		panic(err)
+	for j := range next.EncoderBias {
+		next.EncoderBias[j].Stddev /= float64(window)
+		next.EncoderBias[j].Stddev = math.Sqrt(next.EncoderBias[j].Stddev)
 	}
-}
-
-// Infer inference mode
-func Infer() {
-	in, err := os.Open("network.gob")
-	if err != nil {
-		panic(err)
+	for j := range next.Q {
+		next.Q[j].Stddev /= float64(window)
+		next.Q[j].Stddev = math.Sqrt(next.Q[j].Stddev)
 	}
-	defer in.Close()
-	decoder := gob.NewDecoder(in)
-	n := Network{}
-	err = decoder.Decode(&n)
-	if err != nil {
-		panic(err)
+	for j := range next.K {
+		next.K[j].Stddev /= float64(window)
+		next.K[j].Stddev = math.Sqrt(next.K[j].Stddev)
+	}
+	for j := range next.V {
+		next.V[j].Stddev /= float64(window)
+		next.V[j].Stddev = math.Sqrt(next.V[j].Stddev)
+	}
+	for j := range next.DecoderWeights {
+		next.DecoderWeights[j].Stddev /= float64(window)
+		next.DecoderWeights[j].Stddev = math.Sqrt(next.DecoderWeights[j].Stddev)
 	}
+	for j := range next.DecoderBias {
+		next.DecoderBias[j].Stddev /= float64(window)
+		next.DecoderBias[j].Stddev = math.Sqrt(next.DecoderBias[j].Stddev)
+	}
+	return next
+}
 
-	input := NewMatrix(0, 256, 1)
-	input.Data = input.Data[:cap(input.Data)]
-	qState := NewMatrix(0, Width, 256)
-	qState.Data = qState.Data[:cap(qState.Data)]
-	vState := NewMatrix(0, Width, 256)
-	vState.Data = vState.Data[:cap(vState.Data)]
-	index := 0
-	data := []byte{'G', 'o'}
+// Infer inference mode
+func Infer(n Network, t tokenizer.Tokenizer) {
+	state := n.NewState()
+	data := t.Encode([]byte("Go"))
 	for _, symbol := range data {
-		for i := 0; i < 256; i++ {
-			input.Data[i] = 0
-		}
-		input.Data[int(symbol)] = 1
-		encoded := EverettActivation(Add(MulT(n.EncoderWeights, input), n.EncoderBias))
-		q := MulT(n.Q, encoded)
-		k := MulT(n.K, encoded)
-		v := MulT(n.V, encoded)
-		for i, v := range q.Data {
-			qState.Data[index*Width+i] = v
-		}
-		for i, v := range v.Data {
-			vState.Data[index*Width+i] = v
-		}
-		a := SelfAttention(qState, k, vState)
-		decoded := TaylorSoftmax(Add(MulT(n.DecoderWeights, a), n.DecoderBias))
+		decoded := state.Next(symbol)
 		max, sym := 0.0, 0
-		for i, s := range decoded.Data {
+		for i, s := range decoded {
 			if float64(s) > max {
 				max, sym = float64(s), i
 			}
 		}
-		fmt.Printf("%c", sym)
-		index = (index + 1) % 256
+		fmt.Printf("%s", t.Decode([]int{sym}))
 	}
 	fmt.Printf("\n")
 }
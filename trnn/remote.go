@@ -0,0 +1,345 @@
+// Copyright 2023 The RNN Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package trnn
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/rpc"
+	"sync"
+
+	"github.com/pointlander/rnn/train"
+)
+
+// Shard is the data a worker process evaluates the networks on, the coordinator loads the same shard
+// on every worker so that the losses of the networks are comparable
+type Shard struct {
+	Data []int
+	// Windows and Length override the number and the length of the windows of the loss if they are set
+	Windows int
+	Length  int
+}
+
+// Batch is a batch of networks sent to a worker process
+type Batch struct {
+	Networks []Network
+}
+
+// Losses are the losses of a batch in the order of its networks
+type Losses struct {
+	Losses []float64
+}
+
+// Start is the distribution the networks of the first generation are sampled from and the seed
+// of the search the seeds of the networks are derived from
+type Start struct {
+	Seed         int64
+	Distribution Distribution
+}
+
+// Generation is the part of the networks of a generation a worker process samples and evaluates
+type Generation struct {
+	Generation int
+	Begin      int
+	End        int
+}
+
+// Update is the update of the distribution after a generation, the worker processes sample the networks
+// of the elite window from their seeds and fit the distribution to them
+type Update struct {
+	Generation int
+	// Elite is the indexes of the networks of the elite window in the generation
+	Elite []int
+}
+
+// Evaluator is the net/rpc service of a worker process, it evaluates the networks of a batch
+// or the networks it samples from the seeds of a generation on a pool of workers
+type Evaluator struct {
+	ctx          context.Context
+	workers      int
+	mutex        sync.RWMutex
+	shard        Shard
+	pool         *train.Pool[*Scratch]
+	seed         int64
+	distribution *Distribution
+}
+
+// NewEvaluator creates the service of a worker process with a pool of workers, the number of CPUs if
+// workers is not positive, the evaluations fail once the context is done
+func NewEvaluator(ctx context.Context, workers int) *Evaluator {
+	return &Evaluator{
+		ctx:     ctx,
+		workers: workers,
+	}
+}
+
+// Load loads the shard the networks are evaluated on, the reply is the number of symbols of the shard
+func (e *Evaluator) Load(shard Shard, reply *int) error {
+	if shard.Windows <= 0 {
+		shard.Windows = 1024
+	}
+	if shard.Length <= 0 {
+		shard.Length = 1024
+	}
+	if len(shard.Data) <= shard.Length {
+		return fmt.Errorf("the shard has %d symbols, more than %d are needed", len(shard.Data), shard.Length)
+	}
+	scratch := func() *Scratch {
+		s := NewScratch()
+		s.Windows, s.Length = shard.Windows, shard.Length
+		return s
+	}
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if e.pool != nil {
+		e.pool.Close()
+	}
+	e.shard, e.pool = shard, train.NewPool(e.workers, scratch)
+	*reply = len(shard.Data)
+	return nil
+}
+
+// Evaluate evaluates the networks of the batch on the shard
+func (e *Evaluator) Evaluate(batch Batch, losses *Losses) error {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+	if e.pool == nil {
+		return errors.New("no shard is loaded")
+	}
+	losses.Losses = make([]float64, len(batch.Networks))
+	return e.pool.Run(e.ctx, len(batch.Networks), func(w *train.Worker[*Scratch], j int) error {
+		batch.Networks[j].Evaluate(e.shard.Data, w.Scratch)
+		losses.Losses[j] = batch.Networks[j].Loss
+		return nil
+	})
+}
+
+// Start sets the distribution and the seed of the search, the reply is the number of symbols of the distribution
+func (e *Evaluator) Start(start Start, reply *int) error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.seed, e.distribution = start.Seed, &start.Distribution
+	*reply = start.Distribution.Symbols
+	return nil
+}
+
+// Generation samples the networks of a part of a generation from their seeds and evaluates them on the shard
+func (e *Evaluator) Generation(g Generation, losses *Losses) error {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+	if e.pool == nil || e.distribution == nil {
+		return errors.New("no shard or distribution is loaded")
+	}
+	if g.Begin < 0 || g.End < g.Begin {
+		return fmt.Errorf("invalid networks [%d, %d)", g.Begin, g.End)
+	}
+	losses.Losses = make([]float64, g.End-g.Begin)
+	return e.pool.Run(e.ctx, g.End-g.Begin, func(w *train.Worker[*Scratch], j int) error {
+		n := e.distribution.Sample(w.Seed(train.Seed(e.seed, g.Generation, g.Begin+j)))
+		n.Evaluate(e.shard.Data, w.Scratch)
+		losses.Losses[j] = n.Loss
+		return nil
+	})
+}
+
+// Update fits the distribution to the elite window of a generation, the reply is the size of the window
+func (e *Evaluator) Update(u Update, reply *int) error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if e.distribution == nil {
+		return errors.New("no distribution is loaded")
+	}
+	next := e.distribution.Fit(e.distribution.Elite(e.seed, u.Generation, u.Elite))
+	e.distribution = &next
+	*reply = len(u.Elite)
+	return nil
+}
+
+// Close stops the pool of the evaluator
+func (e *Evaluator) Close() {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if e.pool != nil {
+		e.pool.Close()
+		e.pool = nil
+	}
+}
+
+// ServeWorker serves an evaluator with a pool of workers on the listener until the context is done,
+// it does not wait for the networks that are still being evaluated
+func ServeWorker(ctx context.Context, listener net.Listener, workers int) error {
+	evaluator := NewEvaluator(ctx, workers)
+	defer func() {
+		go evaluator.Close()
+	}()
+	server := rpc.NewServer()
+	if err := server.RegisterName("Evaluator", evaluator); err != nil {
+		return err
+	}
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		go server.ServeConn(conn)
+	}
+}
+
+// Coordinator evaluates the networks of a generation on worker processes, each worker
+// evaluates a contiguous part of the population
+type Coordinator struct {
+	Addresses []string
+	clients   []*rpc.Client
+}
+
+// Dial connects to the worker processes
+func Dial(addresses []string) (*Coordinator, error) {
+	if len(addresses) == 0 {
+		return nil, errors.New("no worker addresses")
+	}
+	c := &Coordinator{
+		Addresses: addresses,
+	}
+	for _, address := range addresses {
+		client, err := rpc.Dial("tcp", address)
+		if err != nil {
+			c.Close()
+			return nil, fmt.Errorf("worker %s: %w", address, err)
+		}
+		c.clients = append(c.clients, client)
+	}
+	return c, nil
+}
+
+// call calls a method on every worker with the argument and the reply of each worker, it returns the error
+// of the context if it is done first or the error of the first worker that failed
+func (c *Coordinator) call(ctx context.Context, method string, args func(i int) interface{}, replies []interface{}) error {
+	calls := make([]*rpc.Call, len(c.clients))
+	for i, client := range c.clients {
+		calls[i] = client.Go(method, args(i), replies[i], make(chan *rpc.Call, 1))
+	}
+	for i, call := range calls {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-call.Done:
+			if call.Error != nil {
+				return fmt.Errorf("worker %s: %w", c.Addresses[i], call.Error)
+			}
+		}
+	}
+	return nil
+}
+
+// Load loads the shard on every worker
+func (c *Coordinator) Load(ctx context.Context, shard Shard) error {
+	replies := make([]interface{}, len(c.clients))
+	for i := range replies {
+		replies[i] = new(int)
+	}
+	return c.call(ctx, "Evaluator.Load", func(i int) interface{} {
+		return shard
+	}, replies)
+}
+
+// Start sends the distribution and the seed of the search to every worker
+func (c *Coordinator) Start(ctx context.Context, seed int64, d Distribution) error {
+	replies := make([]interface{}, len(c.clients))
+	for i := range replies {
+		replies[i] = new(int)
+	}
+	return c.call(ctx, "Evaluator.Start", func(i int) interface{} {
+		return Start{Seed: seed, Distribution: d}
+	}, replies)
+}
+
+// Generation returns the losses of the networks of a generation, the workers sample
+// the networks from their seeds so only the losses are sent back
+func (c *Coordinator) Generation(ctx context.Context, generation, networks int) ([]float64, error) {
+	losses := make([]interface{}, len(c.clients))
+	for i := range losses {
+		losses[i] = new(Losses)
+	}
+	err := c.call(ctx, "Evaluator.Generation", func(i int) interface{} {
+		begin, end := c.part(i, networks)
+		return Generation{Generation: generation, Begin: begin, End: end}
+	}, losses)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]float64, 0, networks)
+	for i := range c.clients {
+		begin, end := c.part(i, networks)
+		reply := losses[i].(*Losses)
+		if len(reply.Losses) != end-begin {
+			return nil, fmt.Errorf("worker %s returned %d losses for %d networks", c.Addresses[i], len(reply.Losses), end-begin)
+		}
+		result = append(result, reply.Losses...)
+	}
+	return result, nil
+}
+
+// Update broadcasts the indexes of the networks of the elite window of a generation
+// that the workers fit their distribution to
+func (c *Coordinator) Update(ctx context.Context, generation int, elite []int) error {
+	replies := make([]interface{}, len(c.clients))
+	for i := range replies {
+		replies[i] = new(int)
+	}
+	return c.call(ctx, "Evaluator.Update", func(i int) interface{} {
+		return Update{Generation: generation, Elite: elite}
+	}, replies)
+}
+
+// part returns the part of the population evaluated by a worker
+func (c *Coordinator) part(i, n int) (int, int) {
+	return i * n / len(c.clients), (i + 1) * n / len(c.clients)
+}
+
+// Evaluate sets the losses of the networks evaluated on the workers
+func (c *Coordinator) Evaluate(ctx context.Context, networks []Network) error {
+	losses := make([]interface{}, len(c.clients))
+	for i := range losses {
+		losses[i] = new(Losses)
+	}
+	err := c.call(ctx, "Evaluator.Evaluate", func(i int) interface{} {
+		begin, end := c.part(i, len(networks))
+		return Batch{Networks: networks[begin:end]}
+	}, losses)
+	if err != nil {
+		return err
+	}
+	for i := range c.clients {
+		begin, end := c.part(i, len(networks))
+		reply := losses[i].(*Losses)
+		if len(reply.Losses) != end-begin {
+			return fmt.Errorf("worker %s returned %d losses for %d networks", c.Addresses[i], len(reply.Losses), end-begin)
+		}
+		for j, loss := range reply.Losses {
+			networks[begin+j].Loss = loss
+		}
+	}
+	return nil
+}
+
+// Close closes the connections to the workers
+func (c *Coordinator) Close() error {
+	var err error
+	for _, client := range c.clients {
+		if e := client.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
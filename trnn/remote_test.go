@@ -0,0 +1,91 @@
+// Copyright 2023 The RNN Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package trnn
+
+import (
+	"context"
+	"math/rand"
+	"net"
+	"testing"
+
+	"github.com/pointlander/rnn/train"
+)
+
+func TestRemote(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var addresses []string
+	for i := 0; i < 2; i++ {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		addresses = append(addresses, listener.Addr().String())
+		go ServeWorker(ctx, listener, 2)
+	}
+	c, err := Dial(addresses)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	rng := rand.New(rand.NewSource(1))
+	data := make([]int, 64)
+	for i := range data {
+		data[i] = rng.Intn(16)
+	}
+	if err := c.Load(ctx, Shard{Data: data[:8], Windows: 2, Length: 16}); err == nil {
+		t.Fatal("expected an error for a shard shorter than a window")
+	}
+	if err := c.Load(ctx, Shard{Data: data, Windows: 2, Length: 16}); err != nil {
+		t.Fatal(err)
+	}
+	d := NewDistribution(rng, 16, Defaults.Stddev)
+	networks := make([]Network, 5)
+	for i := range networks {
+		networks[i] = d.Sample(rng)
+	}
+	if err := c.Evaluate(ctx, networks); err != nil {
+		t.Fatal(err)
+	}
+	s := NewScratch()
+	s.Windows, s.Length = 2, 16
+	for i := range networks {
+		local := networks[i]
+		local.Evaluate(data, s)
+		if local.Loss != networks[i].Loss || local.Loss == 0 {
+			t.Fatalf("network %d has the remote loss %f and the local loss %f", i, networks[i].Loss, local.Loss)
+		}
+	}
+
+	seed := int64(7)
+	if _, err := c.Generation(ctx, 0, 5); err == nil {
+		t.Fatal("expected an error for a generation without a distribution")
+	}
+	if err := c.Start(ctx, seed, d); err != nil {
+		t.Fatal(err)
+	}
+	elite := []int{3, 1}
+	for generation, size := range []int{5, 3} {
+		losses, err := c.Generation(ctx, generation, size)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(losses) != size {
+			t.Fatalf("%d losses for %d networks", len(losses), size)
+		}
+		for j, loss := range losses {
+			local := d.Sample(rand.New(rand.NewSource(train.Seed(seed, generation, j))))
+			local.Evaluate(data, s)
+			if local.Loss != loss {
+				t.Fatalf("network %d of generation %d has the remote loss %f and the local loss %f", j, generation, loss, local.Loss)
+			}
+		}
+		if err := c.Update(ctx, generation, elite); err != nil {
+			t.Fatal(err)
+		}
+		d = d.Fit(d.Elite(seed, generation, elite))
+	}
+}
@@ -0,0 +1,303 @@
+// Copyright 2023 The RNN Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package visualize renders the weights, distributions and attention of the models
+package visualize
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gonum.org/v1/plot"
+	"gonum.org/v1/plot/palette/moreland"
+	"gonum.org/v1/plot/plotter"
+	"gonum.org/v1/plot/vg"
+	"gonum.org/v1/plot/vg/draw"
+	"gonum.org/v1/plot/vg/vgimg"
+
+	"github.com/pointlander/rnn/model"
+	"github.com/pointlander/rnn/tokenizer"
+	"github.com/pointlander/rnn/trnn"
+)
+
+// MinSize is the minimum width and height of a heatmap in pixels
+const MinSize = 256
+
+// Grid is a matrix of values
+type Grid struct {
+	Cols   int
+	Rows   int
+	Values []float64
+}
+
+// NewGrid creates a grid from a tensor, one dimensional tensors are a single row
+func NewGrid(t model.Tensor) (Grid, error) {
+	values, err := t.Float64()
+	if err != nil {
+		f32, err := t.Float32()
+		if err != nil {
+			return Grid{}, err
+		}
+		values = make([]float64, len(f32))
+		for i, v := range f32 {
+			values[i] = float64(v)
+		}
+	}
+	g := Grid{
+		Values: values,
+	}
+	switch len(t.Shape) {
+	case 1:
+		g.Cols, g.Rows = t.Shape[0], 1
+	case 2:
+		g.Cols, g.Rows = t.Shape[1], t.Shape[0]
+	default:
+		return g, fmt.Errorf("tensor %s has shape %v", t.Name, t.Shape)
+	}
+	return g, nil
+}
+
+// Dims returns the number of columns and rows of the grid
+func (g Grid) Dims() (c, r int) {
+	return g.Cols, g.Rows
+}
+
+// Z returns the value of a cell, the rows are drawn from the top
+func (g Grid) Z(c, r int) float64 {
+	return g.Values[(g.Rows-1-r)*g.Cols+c]
+}
+
+// X returns the coordinate of a column
+func (g Grid) X(c int) float64 {
+	return float64(c)
+}
+
+// Y returns the coordinate of a row
+func (g Grid) Y(r int) float64 {
+	return float64(r)
+}
+
+// Heatmap writes a PNG image of the grid with a pixel block per value, the values are colored
+// from blue through white to red symmetrically around zero
+func Heatmap(name string, g Grid) error {
+	max := 0.0
+	for _, v := range g.Values {
+		if math.Abs(v) > max {
+			max = math.Abs(v)
+		}
+	}
+	if max == 0 {
+		max = 1
+	}
+	colors := moreland.SmoothBlueRed()
+	colors.SetMin(-max)
+	colors.SetMax(max)
+	scale := 1
+	for g.Cols*scale < MinSize && g.Rows*scale < MinSize {
+		scale++
+	}
+	img := image.NewRGBA(image.Rect(0, 0, g.Cols*scale, g.Rows*scale))
+	for r := 0; r < g.Rows; r++ {
+		for c := 0; c < g.Cols; c++ {
+			v := g.Values[r*g.Cols+c]
+			var fill color.Color = color.Black
+			if !math.IsNaN(v) {
+				if fill, _ = colors.At(v); fill == nil {
+					fill = color.Black
+				}
+			}
+			for y := r * scale; y < (r+1)*scale; y++ {
+				for x := c * scale; x < (c+1)*scale; x++ {
+					img.Set(x, y, fill)
+				}
+			}
+		}
+	}
+	file, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(file, img); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// Weights writes a heatmap of each named tensor of the model to the directory, all of the tensors with
+// one or two dimensions if there are no names, it returns the paths of the images
+func Weights(m *model.Model, directory string, names []string) ([]string, error) {
+	if len(names) == 0 {
+		for _, t := range m.Tensors {
+			if len(t.Shape) == 1 || len(t.Shape) == 2 {
+				names = append(names, t.Name)
+			}
+		}
+	}
+	var paths []string
+	for _, name := range names {
+		t, err := m.Tensor(name)
+		if err != nil {
+			return paths, err
+		}
+		g, err := NewGrid(t)
+		if err != nil {
+			return paths, err
+		}
+		path := filepath.Join(directory, name+".png")
+		if err := Heatmap(path, g); err != nil {
+			return paths, err
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
+
+// Parameters returns the sorted names of the random variables of a distribution,
+// the tensors of which are named <name>.Mean and <name>.Stddev
+func Parameters(m *model.Model) []string {
+	var names []string
+	for _, t := range m.Tensors {
+		if strings.HasSuffix(t.Name, ".Mean") {
+			names = append(names, strings.TrimSuffix(t.Name, ".Mean"))
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// histogram creates a histogram plot of the values of a tensor
+func histogram(m *model.Model, name, title string) (*plot.Plot, error) {
+	t, err := m.Tensor(name)
+	if err != nil {
+		return nil, err
+	}
+	g, err := NewGrid(model.Tensor{Name: t.Name, DType: t.DType, Shape: []int{t.Elements()}, Data: t.Data})
+	if err != nil {
+		return nil, err
+	}
+	p := plot.New()
+	p.Title.Text = title
+	h, err := plotter.NewHist(plotter.Values(g.Values), 32)
+	if err != nil {
+		return nil, err
+	}
+	p.Add(h)
+	return p, nil
+}
+
+// Histograms writes an image per random variable of the distributions to the directory with a row of
+// mean and standard deviation histograms per distribution, the distributions are labeled with the labels,
+// it returns the paths of the images
+func Histograms(directory string, labels []string, distributions []*model.Model) ([]string, error) {
+	if len(labels) != len(distributions) || len(distributions) == 0 {
+		return nil, fmt.Errorf("%d labels for %d distributions", len(labels), len(distributions))
+	}
+	var paths []string
+	for _, name := range Parameters(distributions[0]) {
+		plots := make([][]*plot.Plot, len(distributions))
+		for i, d := range distributions {
+			plots[i] = make([]*plot.Plot, 2)
+			for j, suffix := range []string{".Mean", ".Stddev"} {
+				p, err := histogram(d, name+suffix, labels[i]+" "+name+suffix)
+				if err != nil {
+					return paths, err
+				}
+				plots[i][j] = p
+			}
+		}
+		path := filepath.Join(directory, name+".histogram.png")
+		if err := save(path, plots); err != nil {
+			return paths, err
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
+
+// save saves a grid of plots to a PNG file
+func save(name string, plots [][]*plot.Plot) error {
+	rows, cols := len(plots), len(plots[0])
+	img := vgimg.New(vg.Length(cols)*4*vg.Inch, vg.Length(rows)*3*vg.Inch)
+	dc := draw.New(img)
+	tiles := draw.Tiles{
+		Rows: rows,
+		Cols: cols,
+		PadX: vg.Millimeter,
+		PadY: vg.Millimeter,
+	}
+	canvases := plot.Align(plots, tiles, dc)
+	for i := range plots {
+		for j := range plots[i] {
+			plots[i][j].Draw(canvases[i][j])
+		}
+	}
+	file, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	if _, err := (vgimg.PngCanvas{Canvas: img}).WriteTo(file); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// Attention returns the attention of each symbol of the text over the context slots of the symbols,
+// the rows are the symbols and the columns are the attended slots, the slots of the later symbols are still empty
+func Attention(n trnn.Network, t tokenizer.Tokenizer, text []byte) (Grid, []int, error) {
+	symbols := t.Encode(text)
+	if len(symbols) == 0 {
+		return Grid{}, nil, fmt.Errorf("the text is empty")
+	}
+	if len(symbols) > trnn.Context {
+		return Grid{}, nil, fmt.Errorf("%d symbols do not fit in the context of %d", len(symbols), trnn.Context)
+	}
+	state := n.NewState()
+	state.Attend = true
+	g := Grid{
+		Cols:   len(symbols),
+		Rows:   len(symbols),
+		Values: make([]float64, 0, len(symbols)*len(symbols)),
+	}
+	for _, symbol := range symbols {
+		state.Next(symbol)
+		for _, weight := range state.Attention[:len(symbols)] {
+			g.Values = append(g.Values, float64(weight))
+		}
+	}
+	return g, symbols, nil
+}
+
+// AttentionMap writes the attention of the trnn network over the text to a PNG or SVG file
+func AttentionMap(name string, n trnn.Network, t tokenizer.Tokenizer, text []byte) error {
+	g, symbols, err := Attention(n, t, text)
+	if err != nil {
+		return err
+	}
+	p := plot.New()
+	p.Title.Text = fmt.Sprintf("attention over %q", text)
+	p.X.Label.Text = "attended symbol"
+	p.Y.Label.Text = "symbol"
+	h := plotter.NewHeatMap(g, moreland.ExtendedBlackBody().Palette(255))
+	p.Add(h)
+	ticks := make([]plot.Tick, len(symbols))
+	reversed := make([]plot.Tick, len(symbols))
+	for i, symbol := range symbols {
+		label := fmt.Sprintf("%q", t.Decode([]int{symbol}))
+		ticks[i] = plot.Tick{Value: float64(i), Label: label}
+		reversed[i] = plot.Tick{Value: float64(len(symbols) - 1 - i), Label: label}
+	}
+	p.X.Tick.Marker = plot.ConstantTicks(ticks)
+	p.Y.Tick.Marker = plot.ConstantTicks(reversed)
+	size := vg.Length(len(symbols))*vg.Centimeter/2 + 3*vg.Inch
+	return p.Save(size, size, name)
+}
@@ -0,0 +1,63 @@
+// Copyright 2023 The RNN Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visualize
+
+import (
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pointlander/rnn/model"
+	"github.com/pointlander/rnn/tokenizer"
+	"github.com/pointlander/rnn/trnn"
+)
+
+func TestVisualize(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	d := trnn.NewDistribution(rng, 256, trnn.Defaults.Stddev)
+	n := d.Sample(rng)
+	g, symbols, err := Attention(n, tokenizer.Byte{}, []byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(symbols) != 5 || g.Rows != 5 || g.Cols != 5 {
+		t.Fatalf("attention is %d by %d for %d symbols", g.Rows, g.Cols, len(symbols))
+	}
+	for r := 0; r < g.Rows; r++ {
+		sum := 0.0
+		for _, weight := range g.Values[r*g.Cols : (r+1)*g.Cols] {
+			if weight < 0 {
+				t.Fatalf("negative attention %v", g.Values)
+			}
+			sum += weight
+		}
+		if sum <= 0 || sum > 1.0001 {
+			t.Fatalf("attention of symbol %d sums to %f", r, sum)
+		}
+	}
+
+	directory := t.TempDir()
+	if err := AttentionMap(filepath.Join(directory, "attention.png"), n, tokenizer.Byte{}, []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	paths, err := Weights(n.Model(tokenizer.Byte{}), directory, []string{"Q", "DecoderBias"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := d.Model(tokenizer.Byte{})
+	histograms, err := Histograms(directory, []string{"a", "b"}, []*model.Model{m, m})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(histograms) != len(Parameters(m)) {
+		t.Fatalf("%d histograms for %v", len(histograms), Parameters(m))
+	}
+	for _, path := range append(paths, histograms...) {
+		if info, err := os.Stat(path); err != nil || info.Size() == 0 {
+			t.Fatalf("%s was not written: %v", path, err)
+		}
+	}
+}